@@ -0,0 +1,233 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+ 
+package gofr
+
+import (
+	"appengine/blobstore"
+	"appengine/user"
+	"io/ioutil"
+	"net/http"
+	"rss"
+	"storage"
+	"strconv"
+	"time"
+)
+
+func registerAdmin() {
+	RegisterAdminJSONRoute("/admin/stats", adminStats)
+	RegisterAdminJSONRoute("/admin/refreshFeed", adminRefreshFeed)
+	RegisterAdminJSONRoute("/admin/feedDebug", adminFeedDebug)
+	RegisterAdminJSONRoute("/admin/reparseFeed", adminReparseFeed)
+
+	RegisterHTMLRoute("/admin/rawFeed", adminRawFeed)
+}
+
+func adminStats(pfc *PFContext) (interface{}, error) {
+	return storage.NewInstanceStats(pfc.C)
+}
+
+// adminRefreshFeed fetches and stores a single feed immediately, the
+// way the scheduled update does. Since Feed entities are shared, the 
+// subscriptions of every user are then brought up to date, in a task
+func adminRefreshFeed(pfc *PFContext) (interface{}, error) {
+	c := pfc.C
+	feedURL := pfc.R.FormValue("url")
+
+	if feedURL == "" {
+		return nil, NewReadableError(_l("Missing URL"), nil)
+	}
+
+	feedMeta, err := storage.FeedMetaByURL(c, feedURL)
+	if err != nil {
+		return nil, err
+	} else if feedMeta == nil {
+		return nil, NewReadableErrorWithCode(_l("Feed not found"), http.StatusNotFound, nil)
+	}
+
+	previousCounter := feedMeta.UpdateCounter
+	started := time.Now()
+
+	doneChannel := make(chan *storage.FeedMeta, 1)
+	updateFeed(c, doneChannel, feedURL, feedMeta)
+
+	// The outcome is recorded on the feed's metadata (details are 
+	// logged)
+	if feedMeta, err = storage.FeedMetaByURL(c, feedURL); err != nil {
+		return nil, err
+	} else if feedMeta == nil {
+		return nil, NewReadableErrorWithCode(_l("Feed not found"), http.StatusNotFound, nil)
+	}
+
+	result := map[string]interface{} {
+		"success": true,
+		"entryCount": feedMeta.UpdateCounter - previousCounter,
+	}
+	if !feedMeta.LastFailure.Before(started) {
+		result["success"] = false
+		result["error"] = _l("The feed could not be updated (%d consecutive failures)", feedMeta.FailureCount)
+		return result, nil
+	}
+
+	params := taskParams {
+		"url": feedURL,
+	}
+	if err := startTask(pfc, "updateFeedSubscribers", params, refreshQueue); err != nil {
+		return nil, NewReadableError(_l("Cannot update subscribers - too busy"), &err)
+	}
+
+	return result, nil
+}
+
+// adminFeedDebug downloads and parses a feed, reporting what the parser
+// made of each entry. Nothing is written
+func adminFeedDebug(pfc *PFContext) (interface{}, error) {
+	feedURL := pfc.R.FormValue("url")
+	if feedURL == "" {
+		return nil, NewReadableError(_l("Missing URL"), nil)
+	}
+
+	client := createHttpClient(pfc.C)
+	response, err := client.Get(feedURL)
+	if err != nil {
+		return nil, NewReadableError(_l("An error occurred while downloading the feed"), &err)
+	}
+
+	defer response.Body.Close()
+
+	result := map[string]interface{} {
+		"status": response.Status,
+		"contentType": response.Header.Get("Content-Type"),
+	}
+
+	// Marshaling errors still yield a feed, so report both
+	parsed, err := rss.UnmarshalStream(feedURL, response.Body)
+	if err != nil {
+		result["error"] = err.Error()
+	}
+
+	result["format"] = parsed.Format
+	result["encoding"] = parsed.Encoding
+	result["isHtml"] = parsed.IsHTML
+	result["truncated"] = parsed.Truncated
+	result["lenient"] = parsed.Lenient
+
+	if parsedFeed := parsed.Feed; parsedFeed != nil {
+		entries := make([]map[string]interface{}, len(parsedFeed.Entries))
+		for i, entry := range parsedFeed.Entries {
+			entries[i] = map[string]interface{} {
+				"guid": entry.GUID,
+				"title": entry.Title,
+				"published": entry.Published,
+				"publishedRaw": entry.PublishedRaw,
+				"updated": entry.Updated,
+				"warnings": entry.Warnings,
+			}
+		}
+
+		result["title"] = parsedFeed.Title
+		result["entryCount"] = len(parsedFeed.Entries)
+		result["entries"] = entries
+	}
+
+	return result, nil
+}
+
+// adminReparseFeed runs the parser over the document last kept for a 
+// feed and rewrites the stored entries in place. Useful after a parser
+// fix, without waiting for the feed to change
+func adminReparseFeed(pfc *PFContext) (interface{}, error) {
+	c := pfc.C
+	feedURL := pfc.R.FormValue("url")
+
+	if feedURL == "" {
+		return nil, NewReadableError(_l("Missing URL"), nil)
+	}
+
+	rawFeed, err := storage.RawFeedByURL(c, feedURL)
+	if err != nil {
+		return nil, err
+	} else if rawFeed == nil {
+		return nil, NewReadableErrorWithCode(_l("No document kept for this feed"), http.StatusNotFound, nil)
+	}
+
+	content, err := ioutil.ReadAll(blobstore.NewReader(c, rawFeed.BlobKey))
+	if err != nil {
+		return nil, NewReadableError(_l("Error reading the stored document"), &err)
+	}
+
+	parsed, err := rss.Unmarshal(feedURL, content)
+	if err != nil {
+		return nil, NewReadableError(_l("Error reading RSS content: %s", err), &err)
+	}
+
+	rewritten, err := storage.ReparseEntries(c, parsed.Feed)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{} {
+		"fetched": rawFeed.Fetched,
+		"entryCount": len(parsed.Feed.Entries),
+		"rewritten": rewritten,
+	}, nil
+}
+
+// adminRawFeed sends the latest document fetched for a feed, as kept 
+// when storeRawFeeds is set. Documents past their retention period are
+// discarded instead
+func adminRawFeed(pfc *PFContext) {
+	c := pfc.C
+	w := pfc.W
+
+	if !user.IsAdmin(c) {
+		http.Error(w, _l("Administrator access required"), http.StatusForbidden)
+		return
+	}
+
+	feedURL := pfc.R.FormValue("url")
+	rawFeed, err := storage.RawFeedByURL(c, feedURL)
+	if err != nil {
+		c.Errorf("Error loading raw feed (%s): %s", feedURL, err)
+		http.Error(w, _l("Unexpected error"), http.StatusInternalServerError)
+		return
+	} else if rawFeed == nil {
+		http.Error(w, _l("No document kept for this feed"), http.StatusNotFound)
+		return
+	}
+
+	if time.Since(rawFeed.Fetched) > rawFeedRetention {
+		if err := storage.DeleteRawFeed(c, feedURL); err != nil {
+			c.Warningf("Error deleting raw feed (%s): %s", feedURL, err)
+		} else if err := blobstore.Delete(c, rawFeed.BlobKey); err != nil {
+			c.Warningf("Error deleting raw feed blob (%s): %s", feedURL, err)
+		}
+
+		http.Error(w, _l("No document kept for this feed"), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("X-Gofr-Fetched", rawFeed.Fetched.Format(time.RFC3339))
+	w.Header().Set("X-Gofr-Original-Length", strconv.Itoa(rawFeed.Length))
+	blobstore.Send(w, rawFeed.BlobKey)
+}
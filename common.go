@@ -26,17 +26,49 @@ package gofr
 import (
 	"appengine"
 	"appengine/urlfetch"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
 const (
 	fetchDeadlineSeconds = 60
+
+	// Keep the latest document of each feed in the blobstore, for 
+	// debugging (see /admin/rawFeed)
+	storeRawFeeds = false
+	maxRawFeedBytes = 512 * 1024
+	rawFeedRetention = 48 * time.Hour
+
+	// Wait after a 429 that doesn't say how long to wait, and the 
+	// longest wait honored
+	defaultRetryAfter = time.Hour
+	maxRetryAfter = 7 * 24 * time.Hour
+
+	// Limits on fetching an article's web page for its full content
+	fullContentFetchDeadline = 20 * time.Second
+	maxFullContentBytes = 1024 * 1024
+
+	// Limit on delivering a webhook notification
+	webhookDeadline = 20 * time.Second
+
+	// Limits on confirming the canonical address of a feed
+	canonicalFetchDeadline = 20 * time.Second
+	canonicalVerifyInterval = 7 * 24 * time.Hour
+
+	// Limit on fetching a feed to validate
+	validateFetchDeadline = 20 * time.Second
+
+	// Limits on fetching a subscription list to import
+	opmlFetchDeadline = 20 * time.Second
+	maxOPMLBytes = 1024 * 1024
 )
 
 var (
@@ -50,6 +82,13 @@ var (
 		"like":   true,
 	}
 
+	validDisplayModes = map[string]bool {
+		"summary": true,
+		"full":    true,
+	}
+
+	privateNetworks = parseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
 	supportedFavIconMimeTypes = []string {
 		"image/vnd.microsoft.icon",
 		"image/png",
@@ -57,15 +96,108 @@ var (
 	}
 )
 
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		if _, network, err := net.ParseCIDR(cidr); err != nil {
+			panic(err)
+		} else {
+			networks[i] = network
+		}
+	}
+
+	return networks
+}
+
 func createHttpClient(context appengine.Context) *http.Client {
+	return createHttpClientWithDeadline(context, time.Duration(fetchDeadlineSeconds) * time.Second)
+}
+
+// rateLimitedUntil reports whether the server has asked us to back off
+// (429, or 503 with Retry-After), returning the time after which we can 
+// try again. Retry-After is either a number of seconds or an HTTP date
+func rateLimitedUntil(response *http.Response, now time.Time) (time.Time, bool) {
+	retryAfter := strings.TrimSpace(response.Header.Get("Retry-After"))
+	if response.StatusCode != http.StatusTooManyRequests && 
+		(response.StatusCode != http.StatusServiceUnavailable || retryAfter == "") {
+		return time.Time{}, false
+	}
+
+	wait := defaultRetryAfter
+	if seconds, err := strconv.Atoi(retryAfter); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if until, err := http.ParseTime(retryAfter); err == nil {
+		wait = until.Sub(now)
+	}
+
+	if wait < 0 {
+		wait = 0
+	} else if wait > maxRetryAfter {
+		wait = maxRetryAfter
+	}
+
+	return now.Add(wait), true
+}
+
+func createHttpClientWithDeadline(context appengine.Context, deadline time.Duration) *http.Client {
 	return &http.Client {
 		Transport: &urlfetch.Transport {
 			Context: context,
-			Deadline: time.Duration(fetchDeadlineSeconds) * time.Second,
+			Deadline: deadline,
 		},
 	}
 }
 
+// createGuardedHttpClient returns a client for fetching addresses 
+// given by users, which won't follow redirects to addresses that 
+// aren't fetchable (see isFetchableURL)
+func createGuardedHttpClient(context appengine.Context, deadline time.Duration) *http.Client {
+	client := createHttpClientWithDeadline(context, deadline)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("Too many redirects")
+		} else if !isFetchableURL(req.URL.String()) {
+			return errors.New("Redirected to an address that can't be loaded: " + req.URL.String())
+		}
+		return nil
+	}
+
+	return client
+}
+
+// isFetchableURL returns true if the URL can be fetched on a user's 
+// behalf: a web address on a public host, rather than an address on 
+// the local network
+func isFetchableURL(rawURL string) bool {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") {
+		return false
+	}
+
+	host := strings.ToLower(parsedURL.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+
+	if host == "" || host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".internal") {
+		return false
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+			return false
+		}
+		for _, privateNet := range privateNetworks {
+			if privateNet.Contains(ip) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
 // resolveURL accepts two URLs and returns the partialURL resolved
 // in terms of the sourceURL. If partialURL is already absolute, it's
 // returned as-is.
@@ -84,6 +216,75 @@ func resolveURL(sourceURL string, partialURL string) (string, error) {
 	return partialURL, nil
 }
 
+// candidateFeedURLs returns the variants of a URL worth trying when
+// looking for a feed, in order of priority: the URL as-is, the URL with 
+// "www." added or removed, the URL with its scheme switched between 
+// HTTP and HTTPS, and finally the URL with both changes
+func candidateFeedURLs(rawURL string) []string {
+	candidates := []string { rawURL }
+
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil || parsedURL.Host == "" {
+		return candidates
+	}
+
+	wwwToggled := *parsedURL
+	if strings.HasPrefix(strings.ToLower(wwwToggled.Host), "www.") {
+		wwwToggled.Host = wwwToggled.Host[len("www."):]
+	} else {
+		wwwToggled.Host = "www." + wwwToggled.Host
+	}
+	candidates = append(candidates, wwwToggled.String())
+
+	toggledScheme := ""
+	if scheme := strings.ToLower(parsedURL.Scheme); scheme == "http" {
+		toggledScheme = "https"
+	} else if scheme == "https" {
+		toggledScheme = "http"
+	}
+
+	if toggledScheme != "" {
+		schemeToggled := *parsedURL
+		schemeToggled.Scheme = toggledScheme
+		candidates = append(candidates, schemeToggled.String())
+
+		wwwToggled.Scheme = toggledScheme
+		candidates = append(candidates, wwwToggled.String())
+	}
+
+	return candidates
+}
+
+// getFirstAvailable requests each of the URLs in order, and returns the 
+// first successful response, along with the URL that produced it. If 
+// none succeed, the result of requesting the first URL is returned
+func getFirstAvailable(client *http.Client, urls []string) (*http.Response, string, error) {
+	var firstResponse *http.Response
+	var firstErr error
+
+	for i, candidateURL := range urls {
+		response, err := client.Get(candidateURL)
+		if err == nil && response.StatusCode < 400 {
+			if firstResponse != nil {
+				firstResponse.Body.Close()
+			}
+			return response, candidateURL, nil
+		}
+
+		if i == 0 {
+			firstResponse, firstErr = response, err
+		} else if err == nil {
+			response.Body.Close()
+		}
+	}
+
+	if len(urls) == 0 {
+		return nil, "", errors.New("No URLs to request")
+	}
+
+	return firstResponse, urls[0], firstErr
+}
+
 // extractLinks parses HTML for any link tags and returns an array
 // containing the attributes of each tag as a map. Attribute keys are
 // automatically converted to lowercase.
@@ -0,0 +1,74 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+
+package gofr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCandidateFeedURLs(t *testing.T) {
+	tests := []struct {
+		url string
+		expected []string
+	}{
+		{
+			"http://example.com/feed",
+			[]string {
+				"http://example.com/feed",
+				"http://www.example.com/feed",
+				"https://example.com/feed",
+				"https://www.example.com/feed",
+			},
+		},
+		{
+			"https://WWW.example.com/feed?x=1",
+			[]string {
+				"https://WWW.example.com/feed?x=1",
+				"https://example.com/feed?x=1",
+				"http://WWW.example.com/feed?x=1",
+				"http://example.com/feed?x=1",
+			},
+		},
+		{
+			// No scheme to switch
+			"ftp://example.com/feed",
+			[]string {
+				"ftp://example.com/feed",
+				"ftp://www.example.com/feed",
+			},
+		},
+		{
+			// Not a URL with a host
+			"example.com/feed",
+			[]string { "example.com/feed" },
+		},
+	}
+
+	for _, test := range tests {
+		if candidates := candidateFeedURLs(test.url); !reflect.DeepEqual(candidates, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.url, test.expected, candidates)
+		}
+	}
+}
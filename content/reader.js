@@ -375,6 +375,7 @@ $().ready(function() {
 			$unreadCount.text(_l("(%d)", [this.unread]));
 			$item.toggleClass('has-unread', this.unread > 0);
 			$sub.toggleClass('no-unread', this.unread < 1);
+			$sub.toggleClass('paused', !!this.paused);
 
 			var parent = this.getParent();
 			if (parent)
@@ -483,6 +484,8 @@ $().ready(function() {
 
 			$.post('subscribe', params, function(response) {
 				resetSubscriptionDom(response, false);
+				if (response.subscriptionUrl)
+					ui.showToast(_l("Subscribed to %s", [response.subscriptionUrl]), false);
 			}, 'json');
 		},
 		'isFolder': function() {
@@ -25,7 +25,14 @@ package gofr
 
 import (
 	"appengine"
+	"appengine/blobstore"
 	"appengine/datastore"
+	"appengine/taskqueue"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"rss"
 	"storage"
 	"time"
@@ -34,22 +41,194 @@ import (
 func registerCron() {
 	RegisterCronRoute("/cron/updateFeeds", updateFeedsJob)
 	RegisterCronRoute("/cron/updateUnreadCounts", updateUnreadCountsJob)
+	RegisterCronRoute("/cron/autoMarkRead", autoMarkReadJob)
+}
+
+// fetchFeed downloads a feed, preferring its canonical address and 
+// falling back to the URL it's stored under if that doesn't respond
+func fetchFeed(c appengine.Context, client *http.Client, url string, canonicalURL string) (*http.Response, error) {
+	if canonicalURL != "" {
+		if response, err := client.Get(canonicalURL); err != nil {
+			c.Warningf("Error downloading canonical feed %s (%s): %s", canonicalURL, url, err)
+		} else if _, limited := rateLimitedUntil(response, time.Now()); limited {
+			// Same publisher, most likely - don't try the other address
+			return response, nil
+		} else if response.StatusCode != http.StatusOK {
+			c.Warningf("Canonical feed %s (%s) responded with %s", canonicalURL, url, response.Status)
+			response.Body.Close()
+		} else {
+			return response, nil
+		}
+	}
+
+	return client.Get(url)
+}
+
+// updateCanonicalURL records the address a feed declares for itself,
+// once it's been fetched and found to serve a feed with the same self
+// link. Until then, the address isn't used to fetch the feed or to 
+// redirect new subscriptions
+func updateCanonicalURL(c appengine.Context, url string, declaredURL string, feedMeta *storage.FeedMeta) {
+	if declaredURL == feedMeta.CanonicalURL {
+		if declaredURL == "" || time.Since(feedMeta.CanonicalVerified) < canonicalVerifyInterval {
+			return
+		}
+	}
+
+	verifiedURL := ""
+	if declaredURL == "" {
+		// No longer declared
+	} else if !isFetchableURL(declaredURL) {
+		c.Warningf("Canonical feed %s (%s) can't be fetched", declaredURL, url)
+	} else if response, err := createGuardedHttpClient(c, canonicalFetchDeadline).Get(declaredURL); err != nil {
+		c.Warningf("Error downloading canonical feed %s (%s): %s", declaredURL, url, err)
+	} else {
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			c.Warningf("Canonical feed %s (%s) responded with %s", declaredURL, url, response.Status)
+		} else if result, err := rss.UnmarshalStream(declaredURL, io.LimitReader(response.Body, rss.MaxFeedSize)); err != nil {
+			c.Warningf("Error parsing canonical feed %s (%s): %s", declaredURL, url, err)
+		} else if result.Feed.Topic != declaredURL {
+			c.Warningf("Canonical feed %s (%s) declares a different self link: %s", declaredURL, url, result.Feed.Topic)
+		} else {
+			verifiedURL = declaredURL
+		}
+	}
+
+	if verifiedURL == feedMeta.CanonicalURL && verifiedURL == "" {
+		return
+	} else if err := storage.SetCanonicalURL(c, url, verifiedURL, time.Now()); err != nil {
+		c.Warningf("Error recording canonical feed %s (%s): %s", declaredURL, url, err)
+	}
+}
+
+// storeRawFeed keeps the start of a fetched document in the blobstore,
+// replacing the one kept previously
+func storeRawFeed(c appengine.Context, url string, content []byte) {
+	writer, err := blobstore.Create(c, "application/xml")
+	if err != nil {
+		c.Warningf("Error creating raw feed blob (%s): %s", url, err)
+		return
+	}
+
+	stored := content
+	if len(stored) > maxRawFeedBytes {
+		stored = stored[:maxRawFeedBytes]
+	}
+
+	if _, err := writer.Write(stored); err != nil {
+		c.Warningf("Error writing raw feed blob (%s): %s", url, err)
+		return
+	} else if err := writer.Close(); err != nil {
+		c.Warningf("Error closing raw feed blob (%s): %s", url, err)
+		return
+	}
+
+	blobKey, err := writer.Key()
+	if err != nil {
+		c.Warningf("Error reading raw feed blob key (%s): %s", url, err)
+		return
+	}
+
+	rawFeed := storage.RawFeed {
+		BlobKey: blobKey,
+		Fetched: time.Now(),
+		Length: len(content),
+	}
+
+	if previous, err := storage.SetRawFeed(c, url, rawFeed); err != nil {
+		c.Warningf("Error recording raw feed (%s): %s", url, err)
+		blobstore.Delete(c, blobKey)
+	} else if previous != "" {
+		if err := blobstore.Delete(c, previous); err != nil {
+			c.Warningf("Error deleting previous raw feed blob (%s): %s", url, err)
+		}
+	}
 }
 
 func updateFeed(c appengine.Context, ch chan<- *storage.FeedMeta, url string, feedMeta *storage.FeedMeta) {
+	c = withLogFields(c, "feed", url)
 	client := createHttpClient(c)
-	if response, err := client.Get(url); err != nil {
+	canonicalURL := ""
+	if !feedMeta.CanonicalVerified.IsZero() {
+		canonicalURL = feedMeta.CanonicalURL
+	}
+
+	if response, err := fetchFeed(c, client, url, canonicalURL); err != nil {
 		c.Errorf("Error downloading feed %s: %s", url, err)
-		goto done
+		goto failed
 	} else {
 		defer response.Body.Close()
-		if parsedFeed, err := rss.UnmarshalStream(url, response.Body); err != nil {
-			c.Errorf("Error reading RSS content (%s): %s", url, err)
+
+		if retryAfter, limited := rateLimitedUntil(response, time.Now()); limited {
+			c.Warningf("Feed %s rate-limited (%s); deferring until %s", url, response.Status, retryAfter)
+			if err := storage.DeferFeed(c, url, time.Now(), retryAfter); err != nil {
+				c.Errorf("Error deferring feed: %s", err)
+			}
+			goto done
+		}
+
+		content, err := ioutil.ReadAll(io.LimitReader(response.Body, rss.MaxFeedSize))
+		if err != nil && len(content) == 0 {
+			c.Errorf("Error reading feed %s: %s", url, err)
+			goto failed
+		} else if err != nil {
+			// Keep what arrived - complete entries can be salvaged
+			c.Warningf("Error reading feed %s (after %d bytes): %s", url, len(content), err)
+		}
+
+		if contentHash := rss.ContentHash(content); bytes.Equal(contentHash, feedMeta.LastContentHash) {
+			// Byte-identical to the last fetch - no need to parse
+			c.Debugf("Feed %s unchanged (%d bytes); skipping", url, len(content))
+			if err := storage.RescheduleFeed(c, url, nil, time.Now()); err != nil {
+				c.Errorf("Error rescheduling feed: %s", err)
+			}
+			goto done
+		}
+
+		if storeRawFeeds {
+			storeRawFeed(c, url, content)
+		}
+
+		result, err := rss.Unmarshal(url, content)
+		if err == nil && result.Lenient {
+			c.Warningf("Feed %s has entries in unexpected places; found %d", url, len(result.Feed.Entries))
+		}
+
+		if err != nil {
+			c.Errorf("Error reading RSS content (%s, %d bytes): %s", url, len(content), err)
+			goto failed
+		} else if result.Truncated {
+			// Store the complete entries, but don't record the build 
+			// date, so the full feed isn't skipped next time
+			c.Warningf("Feed %s truncated (%d bytes); storing %d entries", url, len(content), len(result.Feed.Entries))
+			result.Feed.Updated = time.Time{}
+			if err := storage.UpdateFeed(c, result.Feed, "", time.Now()); err != nil {
+				c.Errorf("Error updating feed: %s", err)
+			}
+			goto done
+		} else if updated := result.Feed.Updated; !updated.IsZero() && !updated.After(feedMeta.LastBuildDate) {
+			// Content differs, but the feed says it hasn't been rebuilt 
+			// (e.g. a timestamp in a comment) - skip the entries
+			c.Debugf("Feed %s not rebuilt since %s; skipping", url, feedMeta.LastBuildDate)
+			if err := storage.RescheduleFeed(c, url, result.Feed, time.Now()); err != nil {
+				c.Errorf("Error rescheduling feed: %s", err)
+			}
 			goto done
-		} else if err := storage.UpdateFeed(c, parsedFeed, "", time.Now()); err != nil {
+		} else if err := storage.UpdateFeed(c, result.Feed, "", time.Now()); err != nil {
 			c.Errorf("Error updating feed: %s", err)
 			goto done
 		}
+
+		updateCanonicalURL(c, url, result.Feed.CanonicalURL(), feedMeta)
+	}
+
+	goto done
+
+failed:
+	if err := storage.RecordFeedFailure(c, url, time.Now()); err != nil {
+		c.Warningf("Error recording feed failure: %s", err)
 	}
 
 done:
@@ -129,3 +308,34 @@ func updateUnreadCountsJob(pfc *PFContext) error {
 
 	return jobError
 }
+
+// autoMarkReadJob starts a task for each user who has asked for old 
+// articles to be marked as read
+func autoMarkReadJob(pfc *PFContext) error {
+	c := pfc.C
+	started := 0
+
+	q := datastore.NewQuery("User").Filter("AutoReadAgeDays >", 0)
+	for t := q.Run(c); ; {
+		user := new(storage.User)
+		if _, err := t.Next(user); err == datastore.Done {
+			break
+		} else if err != nil && !storage.IsFieldMismatch(err) {
+			c.Errorf("Error fetching user: %s", err)
+			return err
+		}
+
+		task := taskqueue.NewPOSTTask("/tasks/autoMarkRead", url.Values {
+			"userID": { user.ID },
+		})
+		if _, err := taskqueue.Add(c, task, modificationQueue); err != nil {
+			c.Errorf("Error queueing auto-read task (user %s): %s", user.ID, err)
+			continue
+		}
+		started++
+	}
+
+	c.Infof("Started %d auto-read tasks", started)
+
+	return nil
+}
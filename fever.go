@@ -0,0 +1,398 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+ 
+
+package gofr
+
+import (
+	"appengine/datastore"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"storage"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fever API (http://feedafever.com/api) compatibility, for existing 
+// mobile clients. Clients authenticate with an API key - the MD5 of 
+// "email:password" - so users set a password for Fever clients, since
+// their own account has none. Groups are folders, and feeds are 
+// subscriptions; both are identified by a hash of their Gofr ID. See
+// storage.NumberFeverItems for how items are numbered
+
+const (
+	feverAPIVersion = 3
+	// The password is all that protects the API key, which is unsalted
+	minFeverPasswordLength = 8
+)
+
+func registerFever() {
+	RegisterAnonHTMLRoute("/fever", feverAPI)
+	RegisterAnonHTMLRoute("/fever/", feverAPI)
+
+	RegisterJSONRoute("/setFeverPassword", setFeverPassword)
+}
+
+// setFeverPassword enables Fever clients for the user, with the email
+// address as the user name. An empty password disables them
+func setFeverPassword(pfc *PFContext) (interface{}, error) {
+	password := pfc.R.PostFormValue("password")
+
+	apiKey := ""
+	if password != "" {
+		if len([]rune(password)) < minFeverPasswordLength {
+			return nil, NewReadableErrorWithCode(_l("The password must be at least %d characters long", minFeverPasswordLength), http.StatusBadRequest, nil)
+		}
+
+		hash := md5.Sum([]byte(pfc.User.EmailAddress + ":" + password))
+		apiKey = hex.EncodeToString(hash[:])
+	}
+
+	if err := storage.SetFeverAPIKey(pfc.C, pfc.UserID, apiKey); err != nil {
+		return nil, NewReadableError(_l("Error saving Fever password"), &err)
+	}
+
+	return map[string]interface{} {
+		"enabled": apiKey != "",
+		"email": pfc.User.EmailAddress,
+	}, nil
+}
+
+// feverID maps a folder or subscription ID to the integer Fever uses
+func feverID(id string) int64 {
+	hash := fnv.New32a()
+	hash.Write([]byte(id))
+	return int64(hash.Sum32() & 0x7fffffff)
+}
+
+func feverIDList(ids []int64) string {
+	strs := make([]string, len(ids))
+	for i, id := range ids {
+		strs[i] = strconv.FormatInt(id, 10)
+	}
+
+	return strings.Join(strs, ",")
+}
+
+func feverAPI(pfc *PFContext) {
+	r := pfc.R
+	w := pfc.W
+
+	response := map[string]interface{} {
+		"api_version": feverAPIVersion,
+		"auth": 0,
+	}
+
+	if user, err := storage.UserByFeverAPIKey(pfc.C, strings.ToLower(r.FormValue("api_key"))); err != nil {
+		pfc.C.Errorf("Error authenticating Fever client: %s", err)
+		http.Error(w, _l("Unexpected error"), http.StatusInternalServerError)
+		return
+	} else if user != nil {
+		pfc.User = user
+		pfc.UserID = storage.UserID(user.ID)
+		pfc.C = withLogFields(pfc.C, "user", user.ID, "api", "fever")
+
+		if err := feverRespond(pfc, response); err != nil {
+			if readableError, ok := err.(ReadableError); ok && readableError.httpCode == http.StatusBadRequest {
+				// Malformed request - not a server failure
+				pfc.C.Warningf("Bad request from Fever client: %s", err)
+				http.Error(w, localize(pfc.Locale, err.Error()), http.StatusBadRequest)
+				return
+			}
+
+			pfc.C.Errorf("Error responding to Fever client: %s", err)
+			http.Error(w, _l("Unexpected error"), http.StatusInternalServerError)
+			return
+		}
+
+		response["auth"] = 1
+	}
+
+	bf, _ := json.Marshal(response)
+	w.Header().Set("Content-type", "application/json; charset=utf-8")
+	w.Write(bf)
+}
+
+// feverRespond carries out the request of an authenticated client, 
+// adding what was asked for to the response
+func feverRespond(pfc *PFContext, response map[string]interface{}) error {
+	c := pfc.C
+	r := pfc.R
+
+	has := func(name string) bool {
+		_, ok := r.Form[name]
+		return ok
+	}
+
+	if has("mark") {
+		if err := feverMark(pfc); err != nil {
+			return err
+		}
+	}
+
+	response["last_refreshed_on_time"] = time.Now().Unix()
+
+	if has("groups") || has("feeds") {
+		userSubscriptions, err := storage.NewUserSubscriptions(c, pfc.UserID)
+		if err != nil {
+			return err
+		}
+
+		feedIDsByGroup := make(map[string][]int64)
+		for _, subscription := range userSubscriptions.Subscriptions {
+			if subscription.Parent != "" {
+				feedIDsByGroup[subscription.Parent] = append(feedIDsByGroup[subscription.Parent], feverID(subscription.ID))
+			}
+		}
+
+		feedsGroups := make([]map[string]interface{}, 0, len(feedIDsByGroup))
+		for folderID, feedIDs := range feedIDsByGroup {
+			feedsGroups = append(feedsGroups, map[string]interface{} {
+				"group_id": feverID(folderID),
+				"feed_ids": feverIDList(feedIDs),
+			})
+		}
+		response["feeds_groups"] = feedsGroups
+
+		if has("groups") {
+			groups := make([]map[string]interface{}, len(userSubscriptions.Folders))
+			for i, folder := range userSubscriptions.Folders {
+				groups[i] = map[string]interface{} {
+					"id": feverID(folder.ID),
+					"title": folder.Title,
+				}
+			}
+			response["groups"] = groups
+		}
+
+		if has("feeds") {
+			feeds := make([]map[string]interface{}, len(userSubscriptions.Subscriptions))
+			for i, subscription := range userSubscriptions.Subscriptions {
+				var lastUpdated int64
+				if !subscription.LastRefresh.IsZero() {
+					lastUpdated = subscription.LastRefresh.Unix()
+				}
+
+				feeds[i] = map[string]interface{} {
+					"id": feverID(subscription.ID),
+					"favicon_id": 0,
+					"title": subscription.Title,
+					"url": subscription.ID,
+					"site_url": subscription.Link,
+					"is_spark": 0,
+					"last_updated_on_time": lastUpdated,
+				}
+			}
+			response["feeds"] = feeds
+		}
+	}
+
+	if has("favicons") {
+		// Favicons are sent as data URIs, which would mean fetching 
+		// each one; clients fall back to their own
+		response["favicons"] = []interface{} {}
+	}
+
+	if has("links") {
+		response["links"] = []interface{} {}
+	}
+
+	if has("items") || has("unread_item_ids") || has("saved_item_ids") {
+		lastID, err := storage.NumberFeverItems(c, pfc.UserID)
+		if err != nil {
+			return err
+		}
+
+		if has("items") {
+			var withIDs []int64
+			for _, idAsString := range strings.Split(r.FormValue("with_ids"), ",") {
+				if id, err := strconv.ParseInt(strings.TrimSpace(idAsString), 10, 64); err == nil {
+					withIDs = append(withIDs, id)
+				}
+			}
+
+			sinceID, _ := strconv.ParseInt(r.FormValue("since_id"), 10, 64)
+			maxID, _ := strconv.ParseInt(r.FormValue("max_id"), 10, 64)
+
+			feverItems, err := storage.FeverItems(c, pfc.UserID, sinceID, maxID, withIDs)
+			if err != nil {
+				return err
+			}
+
+			items := make([]map[string]interface{}, len(feverItems))
+			for i, feverItem := range feverItems {
+				article := feverItem.Article
+				isRead, isSaved := 1, 0
+				if article.IsUnread() {
+					isRead = 0
+				}
+				if article.HasProperty("star") {
+					isSaved = 1
+				}
+
+				items[i] = map[string]interface{} {
+					"id": feverItem.ID,
+					"feed_id": feverID(feverItem.Ref.SubscriptionID),
+					"title": article.Details.Title,
+					"author": article.Details.Author,
+					"html": article.Details.Content,
+					"url": article.Details.Link,
+					"is_saved": isSaved,
+					"is_read": isRead,
+					"created_on_time": article.Published.Unix(),
+				}
+			}
+
+			response["items"] = items
+			response["total_items"] = lastID
+		}
+
+		if has("unread_item_ids") {
+			if ids, err := storage.FeverItemIDs(c, pfc.UserID, "unread"); err != nil {
+				return err
+			} else {
+				response["unread_item_ids"] = feverIDList(ids)
+			}
+		}
+
+		if has("saved_item_ids") {
+			if ids, err := storage.FeverItemIDs(c, pfc.UserID, "star"); err != nil {
+				return err
+			} else {
+				response["saved_item_ids"] = feverIDList(ids)
+			}
+		}
+	}
+
+	return nil
+}
+
+// feverMark marks an item as read, unread, saved or unsaved, or a 
+// feed or group as read. Feeds and groups are marked in a task, and 
+// only up to the time the client last refreshed (before), so that 
+// articles it hasn't seen yet stay unread
+func feverMark(pfc *PFContext) error {
+	c := pfc.C
+	r := pfc.R
+
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		return NewReadableErrorWithCode(_l("Invalid identifier"), http.StatusBadRequest, &err)
+	}
+
+	as := r.FormValue("as")
+
+	switch r.FormValue("mark") {
+	case "item":
+		ref, err := storage.FeverItemRef(c, pfc.UserID, id)
+		if err == storage.ErrUnknownFeverItem {
+			// Clients mark what they have cached, which may be gone
+			c.Infof("Ignoring mark of unknown item %d", id)
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		propertyName, propertyValue := "", true
+		switch as {
+		case "read":
+			propertyName = "read"
+		case "unread":
+			propertyName = "unread"
+		case "saved":
+			propertyName = "star"
+		case "unsaved":
+			propertyName, propertyValue = "star", false
+		default:
+			return nil
+		}
+
+		if _, err = storage.SetProperty(c, ref, propertyName, propertyValue, -1); err == datastore.ErrNoSuchEntity {
+			c.Infof("Ignoring mark of purged item %d", id)
+			return nil
+		}
+		return err
+	case "feed", "group":
+		if as != "read" {
+			return nil
+		}
+
+		scope := storage.ArticleScope {
+			FolderRef: storage.FolderRef {
+				UserID: pfc.UserID,
+			},
+		}
+
+		// Group 0 is every item; otherwise, find the folder or 
+		// subscription with the matching ID
+		if id != 0 || r.FormValue("mark") == "feed" {
+			userSubscriptions, err := storage.NewUserSubscriptions(c, pfc.UserID)
+			if err != nil {
+				return err
+			}
+
+			found := false
+			if r.FormValue("mark") == "feed" {
+				for _, subscription := range userSubscriptions.Subscriptions {
+					if feverID(subscription.ID) == id {
+						scope.FolderID = subscription.Parent
+						scope.SubscriptionID = subscription.ID
+						found = true
+						break
+					}
+				}
+			} else {
+				for _, folder := range userSubscriptions.Folders {
+					if feverID(folder.ID) == id {
+						scope.FolderID = folder.ID
+						found = true
+						break
+					}
+				}
+			}
+
+			if !found {
+				return nil
+			}
+		}
+
+		before := time.Now()
+		if seconds, err := strconv.ParseInt(r.FormValue("before"), 10, 64); err == nil && seconds > 0 {
+			before = time.Unix(seconds, 0)
+		}
+
+		params := taskParams {
+			"subscriptionID": scope.SubscriptionID,
+			"folderID":       scope.FolderID,
+			"before":         before.Format(time.RFC3339Nano),
+		}
+
+		return startTask(pfc, "markAllAsRead", params, modificationQueue)
+	}
+
+	return nil
+}
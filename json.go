@@ -27,13 +27,21 @@ import (
 	"appengine"
 	"appengine/blobstore"
 	"appengine/channel"
+	"appengine/datastore"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
 	"io/ioutil"
+	"net/http"
 	"net/url"
-	"regexp"
 	"rss"
 	"storage"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 	"unicode/utf8"
 )
 
@@ -42,33 +50,87 @@ const (
 	importQueue = "imports"
 	refreshQueue = "refreshes"
 	modificationQueue = "modifications"
+	webhookQueue = "webhooks"
 
 	subscriptionStalePeriodInMinutes = 10
+	refreshAllPeriodInMinutes = 5
+
+	minRefreshIntervalInMinutes = 15
+	maxRefreshIntervalInMinutes = 24 * 60
+
+	maxAutoReadAgeInDays = 365
+
+	defaultHistogramDays = 30
+	maxHistogramDays = 90
+
+	maxPreferencesBytes = 8 * 1024
+
+	maxTitleLength = 200
+
+	maxPropertyRefs = 100
+
+	maxMuteWords = 100
+	maxMuteWordLength = 100
 )
 
+type subscribeResult struct {
+	*storage.UserSubscriptions
+	SubscriptionURL string `json:"subscriptionUrl"`
+}
+
 func registerJson() {
 	RegisterJSONRoute("/syncFeeds",     syncFeeds)
+	RegisterJSONRoute("/refreshAll",    refreshAll)
 	RegisterJSONRoute("/subscriptions", subscriptions)
 	RegisterJSONRoute("/articles",      articles)
+	RegisterJSONRoute("/article",       article)
 	RegisterJSONRoute("/articleExtras", articleExtras)
+	RegisterJSONRoute("/fullContent",   fullContent)
+	RegisterJSONRoute("/history",       history)
+	RegisterJSONRoute("/popular",       popular)
+	RegisterJSONRoute("/itemStates",    itemStates)
 	RegisterJSONRoute("/createFolder",  createFolder)
 	RegisterJSONRoute("/rename",        rename)
+	RegisterJSONRoute("/setFolderDefaultFilter", setFolderDefaultFilter)
 	RegisterJSONRoute("/setProperty",   setProperty)
+	RegisterJSONRoute("/getProperties", getProperties)
 	RegisterJSONRoute("/setTags",       setTags)
+	RegisterJSONRoute("/setReadPosition", setReadPosition)
+	RegisterJSONRoute("/setRefreshInterval", setRefreshInterval)
+	RegisterJSONRoute("/setAutoReadAge", setAutoReadAge)
+	RegisterJSONRoute("/setMuteWords",  setMuteWords)
+	RegisterJSONRoute("/articleHistogram", articleHistogram)
+	RegisterJSONRoute("/preferences",   preferences)
+	RegisterJSONRoute("/pauseSubscription", pauseSubscription)
+	RegisterJSONRoute("/setResurfaceUpdates", setResurfaceUpdates)
+	RegisterJSONRoute("/setSubscriptionWebhook", setSubscriptionWebhook)
+	RegisterJSONRoute("/touchSubscription", touchSubscription)
+	RegisterJSONRoute("/setDedupByLink", setDedupByLink)
+	RegisterJSONRoute("/setDisplayMode", setDisplayMode)
 	RegisterJSONRoute("/subscribe",     subscribe)
 	RegisterJSONRoute("/unsubscribe",   unsubscribe)
+	RegisterJSONRoute("/unsubscribeBatch", unsubscribeBatch)
 	RegisterJSONRoute("/markAllAsRead", markAllAsRead)
+	RegisterJSONRoute("/purgeRead",     purgeRead)
+	RegisterJSONRoute("/hasUnread",     hasUnread)
+	RegisterJSONRoute("/unreadCount",   unreadCount)
+	RegisterJSONRoute("/recountUnread", recountUnread)
+	RegisterJSONRoute("/markNewerUnread", markNewerUnread)
 	RegisterJSONRoute("/moveSubscription", moveSubscription)
 	RegisterJSONRoute("/removeFolder",  removeFolder);
 	RegisterJSONRoute("/removeTag",     removeTag);
+	RegisterJSONRoute("/validateFeed",  validateFeed)
+	RegisterJSONRoute("/discover",      discover)
 
 	RegisterJSONRoute("/authUpload",    authUpload)
+	RegisterJSONRoute("/importURL",     importURL)
 	RegisterJSONRoute("/initChannel",   initChannel)
 
 	// PostFormValue before blobstore.ParseUpload results in
 	// "blobstore: error reading next mime part with boundary",
 	// so we read post form values after parsing the uploaded file
 	RegisterJSONRouteSansPreparse("/import",        importOPML)
+	RegisterJSONRouteSansPreparse("/importJSON",    importJSON)
 }
 
 func subscriptions(pfc *PFContext) (interface{}, error) {
@@ -118,19 +180,353 @@ func syncFeeds(pfc *PFContext) (interface{}, error) {
 	return userSubscriptions, nil
 }
 
-func articles(pfc *PFContext) (interface{}, error) {
+// refreshAll queues a fetch of every feed the user is subscribed to 
+// (other than paused ones), returning the number queued. Each feed is 
+// queued once, and feeds fetched within the last few minutes (e.g. by 
+// another user's refresh) are skipped
+func refreshAll(pfc *PFContext) (interface{}, error) {
+	c := pfc.C
+
+	period := time.Duration(refreshAllPeriodInMinutes) * time.Minute
+	if since := time.Since(pfc.User.LastRefreshAll); since < period {
+		wait := (period - since) / time.Second * time.Second
+		return nil, NewReadableErrorWithCode(_l("Feeds were refreshed recently; try again in %s", wait), 
+			http.StatusTooManyRequests, nil)
+	}
+
+	pfc.User.LastRefreshAll = time.Now()
+	if err := pfc.User.Save(c); err != nil {
+		return nil, NewReadableError(_l("Error refreshing feeds"), &err)
+	}
+
+	feedURLs, err := storage.FeedsToRefresh(c, pfc.UserID, time.Now().Add(-period))
+	if err != nil {
+		return nil, NewReadableError(_l("Error refreshing feeds"), &err)
+	}
+
+	queued := 0
+	for _, feedURL := range feedURLs {
+		params := taskParams {
+			"url": feedURL,
+		}
+		if err := startTask(pfc, "refreshFeed", params, refreshQueue); err != nil {
+			c.Warningf("Could not queue refresh of %s: %s", feedURL, err)
+			continue
+		}
+		queued++
+	}
+
+	return map[string]int {
+		"queued": queued,
+	}, nil
+}
+
+// preferences returns the user's preferences document (GET), or 
+// replaces it with the one posted as "preferences" (POST). The document
+// is opaque, other than having to be a JSON object
+func preferences(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	if r.Method == "POST" {
+		preferencesJSON := []byte(r.PostFormValue("preferences"))
+		if len(preferencesJSON) > maxPreferencesBytes {
+			return nil, NewReadableErrorWithCode(_l("Preferences are too large"), http.StatusRequestEntityTooLarge, nil)
+		}
+
+		var object map[string]interface{}
+		if err := json.Unmarshal(preferencesJSON, &object); err != nil {
+			return nil, NewReadableErrorWithCode(_l("Preferences are not valid"), http.StatusBadRequest, &err)
+		} else if object == nil {
+			return nil, NewReadableErrorWithCode(_l("Preferences are not valid"), http.StatusBadRequest, nil)
+		}
+
+		if err := storage.SetUserPreferences(pfc.C, pfc.UserID, preferencesJSON); err != nil {
+			return nil, NewReadableError(_l("Error updating settings"), &err)
+		}
+
+		return json.RawMessage(preferencesJSON), nil
+	}
+
+	if preferencesJSON, err := storage.UserPreferences(pfc.C, pfc.UserID); err != nil {
+		return nil, err
+	} else if preferencesJSON == nil {
+		return map[string]interface{} {}, nil
+	} else {
+		return json.RawMessage(preferencesJSON), nil
+	}
+}
+
+// articleHistogram returns the number of articles fetched on each of
+// the last few days, within a filter's scope
+func articleHistogram(pfc *PFContext) (interface{}, error) {
 	r := pfc.R
 
 	filter, err := storage.ArticleFilterFromJSON(pfc.UserID, r.FormValue("filter"))
 	if err != nil {
 		return nil, err
+	} else if err := validateScope(pfc, filter.ArticleScope); err != nil {
+		return nil, err
+	}
+
+	if !validProperties[filter.Property] {
+		filter.Property = ""
+	}
+
+	days := defaultHistogramDays
+	if daysAsString := r.FormValue("days"); daysAsString != "" {
+		if days, err = strconv.Atoi(daysAsString); err != nil || days < 1 || days > maxHistogramDays {
+			return nil, NewReadableErrorWithCode(_l("Number of days not valid"), http.StatusBadRequest, nil)
+		}
+	}
+
+	if counts, err := storage.ArticleCountsByDay(pfc.C, filter, days); err != nil {
+		return nil, NewReadableError(_l("Error counting articles"), &err)
+	} else {
+		return counts, nil
+	}
+}
+
+func articles(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	var filter storage.ArticleFilter
+	var err error
+
+	// A view ID (from a previous page) stands in for the filter
+	if viewID := r.FormValue("view"); viewID != "" {
+		if filter, err = storage.ResolveView(pfc.C, pfc.UserID, viewID); err != nil {
+			return nil, NewReadableErrorWithCode(_l("View not found"), http.StatusNotFound, &err)
+		}
+	} else if filter, err = storage.ArticleFilterFromJSON(pfc.UserID, r.FormValue("filter")); err != nil {
+		return nil, err
+	}
+
+	if !filter.PropertySpecified && filter.Tag == "" && filter.FolderID != "" {
+		// Fall back to the folder's default filter
+		if property, err := storage.FolderDefaultFilter(pfc.C, filter.FolderRef); err != nil {
+			return nil, err
+		} else {
+			filter.Property = property
+		}
 	}
 
 	if !validProperties[filter.Property] {
 		filter.Property = ""
 	}
 
-	return storage.NewArticlePage(pfc.C, filter, r.FormValue("continue"))
+	if sort := r.FormValue("sort"); sort == "unreadFirst" {
+		filter.UnreadFirst = true
+	} else if sort != "" {
+		return nil, NewReadableErrorWithCode(_l("Sort order not valid"), http.StatusBadRequest, nil)
+	}
+
+	if fields := r.FormValue("fields"); fields == "summary" {
+		filter.SummaryOnly = true
+	} else if fields == "preferred" {
+		// Summaries for subscriptions displayed that way
+		filter.SummaryByDisplayMode = true
+	} else if fields != "" && fields != "full" {
+		return nil, NewReadableErrorWithCode(_l("Field selection not valid"), http.StatusBadRequest, nil)
+	}
+
+	if author := r.FormValue("author"); author != "" {
+		// Tags and authors aren't indexed together
+		if filter.Tag != "" {
+			return nil, NewReadableErrorWithCode(_l("Tagged articles can't be filtered by author"), http.StatusBadRequest, nil)
+		}
+		filter.Author = author
+	}
+
+	if exclude := r.FormValue("exclude"); exclude != "" {
+		if !validProperties[exclude] {
+			return nil, NewReadableErrorWithCode(_l("Property not valid"), http.StatusBadRequest, nil)
+		}
+		filter.Exclude = exclude
+	}
+
+	if query := strings.TrimSpace(r.FormValue("q")); query != "" {
+		// Text filtering scans each page in memory, so it's only 
+		// offered within a single subscription
+		if filter.SubscriptionID == "" {
+			return nil, NewReadableErrorWithCode(_l("Text filtering requires a subscription"), http.StatusBadRequest, nil)
+		}
+		filter.Query = query
+	}
+
+	if newerThan := r.FormValue("newerThan"); newerThan != "" {
+		if filter.NewerThan, err = time.Parse(time.RFC3339Nano, newerThan); err != nil {
+			return nil, NewReadableErrorWithCode(_l("Invalid value for newerThan"), http.StatusBadRequest, &err)
+		}
+	}
+
+	page, err := storage.NewArticlePage(pfc.C, filter, r.FormValue("continue"))
+	if err == storage.ErrInvalidCursor {
+		return nil, NewReadableErrorWithCode(_l("Invalid value for continue"), http.StatusBadRequest, &err)
+	}
+
+	return page, err
+}
+
+// itemStates returns the read, star and like state of articles that 
+// changed since a time, so that clients can reconcile state without 
+// downloading content. Clients keep the same since value while 
+// following the continue token
+func itemStates(pfc *PFContext) (interface{}, error) {
+	var since time.Time
+	if sinceAsString := pfc.R.FormValue("since"); sinceAsString != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339Nano, sinceAsString); err != nil {
+			return nil, NewReadableErrorWithCode(_l("Invalid value for since"), http.StatusBadRequest, &err)
+		}
+	}
+
+	page, err := storage.ArticleStatesSince(pfc.C, pfc.UserID, since, pfc.R.FormValue("continue"))
+	if err == storage.ErrInvalidCursor {
+		return nil, NewReadableErrorWithCode(_l("Invalid value for continue"), http.StatusBadRequest, &err)
+	} else if err != nil {
+		return nil, NewReadableError(_l("Error reading article states"), &err)
+	}
+
+	return page, nil
+}
+
+// popular returns the articles in the folder (or all folders) most 
+// liked by everyone subscribed to their feeds
+func popular(pfc *PFContext) (interface{}, error) {
+	ref := storage.FolderRef {
+		UserID: pfc.UserID,
+		FolderID: pfc.R.FormValue("folder"),
+	}
+
+	if ref.FolderID != "" {
+		if exists, err := storage.FolderExists(pfc.C, ref); err != nil {
+			return nil, err
+		} else if !exists {
+			return nil, NewReadableError(_l("Folder not found"), nil)
+		}
+	}
+
+	if page, err := storage.PopularArticles(pfc.C, ref); err != nil {
+		return nil, NewReadableError(_l("Error reading articles"), &err)
+	} else {
+		return page, nil
+	}
+}
+
+func history(pfc *PFContext) (interface{}, error) {
+	page, err := storage.NewHistoryPage(pfc.C, pfc.UserID, pfc.R.FormValue("continue"))
+	if err == storage.ErrInvalidCursor {
+		return nil, NewReadableErrorWithCode(_l("Invalid value for continue"), http.StatusBadRequest, &err)
+	}
+
+	return page, err
+}
+
+func article(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	folderID := r.FormValue("folder")
+	subscriptionID := r.FormValue("subscription")
+	articleID := r.FormValue("article")
+	markRead := r.FormValue("markRead") == "true"
+
+	if articleID == "" || subscriptionID == "" {
+		return nil, NewReadableError(_l("Article not found"), nil)
+	}
+
+	ref := storage.ArticleRef {
+		SubscriptionRef: storage.SubscriptionRef {
+			FolderRef: storage.FolderRef {
+				UserID: pfc.UserID,
+				FolderID: folderID,
+			},
+			SubscriptionID: subscriptionID,
+		},
+		ArticleID: articleID,
+	}
+
+	if article, err := storage.LoadArticle(pfc.C, ref, markRead); err == datastore.ErrNoSuchEntity {
+		return nil, NewReadableErrorWithCode(_l("Article not found"), http.StatusNotFound, nil)
+	} else if err != nil {
+		return nil, NewReadableError(_l("Error loading article"), &err)
+	} else {
+		if r.FormValue("text") == "true" {
+			if article.ContentText = article.Details.ContentText; article.ContentText == "" {
+				article.ContentText = rss.PlainText(article.Details.Content)
+			}
+		}
+
+		return article, nil
+	}
+}
+
+// fullContent returns the body of an article as found on its web page,
+// for feeds that only carry excerpts. The page is fetched the first 
+// time, and the result kept with the article
+func fullContent(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+	c := pfc.C
+
+	articleID := r.FormValue("article")
+	subscriptionID := r.FormValue("subscription")
+	if articleID == "" || subscriptionID == "" {
+		return nil, NewReadableError(_l("Article not found"), nil)
+	}
+
+	ref := storage.ArticleRef {
+		SubscriptionRef: storage.SubscriptionRef {
+			FolderRef: storage.FolderRef {
+				UserID: pfc.UserID,
+				FolderID: r.FormValue("folder"),
+			},
+			SubscriptionID: subscriptionID,
+		},
+		ArticleID: articleID,
+	}
+
+	article, err := storage.LoadArticle(c, ref, false)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, NewReadableErrorWithCode(_l("Article not found"), http.StatusNotFound, nil)
+	} else if err != nil {
+		return nil, NewReadableError(_l("Error loading article"), &err)
+	} else if article.FullContent != "" {
+		return map[string]string { "content": article.FullContent }, nil
+	}
+
+	pageURL := article.Details.Link
+	if pageURL == "" {
+		return nil, NewReadableErrorWithCode(_l("This article has no web page"), http.StatusNotFound, nil)
+	} else if !isFetchableURL(pageURL) {
+		return nil, NewReadableErrorWithCode(_l("This article's web page can't be loaded"), http.StatusBadRequest, nil)
+	}
+
+	client := createGuardedHttpClient(c, fullContentFetchDeadline)
+	response, err := client.Get(pageURL)
+	if err != nil {
+		return nil, NewReadableError(_l("An error occurred while downloading the page"), &err)
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, NewReadableError(_l("The server responded with %s", response.Status), nil)
+	}
+
+	page, err := ioutil.ReadAll(io.LimitReader(response.Body, maxFullContentBytes))
+	if err != nil {
+		return nil, NewReadableError(_l("An error occurred while downloading the page"), &err)
+	}
+
+	body := rss.ExtractMainContent(string(page))
+	if body == "" {
+		return nil, NewReadableErrorWithCode(_l("Couldn't find the article on its web page"), http.StatusNotFound, nil)
+	}
+
+	if err := storage.SetFullContent(c, ref, body); err != nil {
+		c.Warningf("Error caching full content: %s", err)
+	}
+
+	return map[string]string { "content": body }, nil
 }
 
 func articleExtras(pfc *PFContext) (interface{}, error) {
@@ -158,6 +554,23 @@ func articleExtras(pfc *PFContext) (interface{}, error) {
 	return storage.LoadArticleExtras(pfc.C, ref)
 }
 
+// validateTitle checks a folder or subscription name chosen by the 
+// user, failing with tooLongMessage if it's over the limit. Emptiness 
+// is left to the caller
+func validateTitle(title string, tooLongMessage string) error {
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		return NewReadableError(tooLongMessage, nil)
+	}
+
+	for _, r := range title {
+		if unicode.IsControl(r) {
+			return NewReadableError(_l("Name contains invalid characters"), nil)
+		}
+	}
+
+	return nil
+}
+
 func createFolder(pfc *PFContext) (interface{}, error) {
 	r := pfc.R
 
@@ -166,11 +579,11 @@ func createFolder(pfc *PFContext) (interface{}, error) {
 		return nil, NewReadableError(_l("Missing folder name"), nil)
 	}
 
-	if utf8.RuneCountInString(title) > 200 {
-		return nil, NewReadableError(_l("Folder name is too long"), nil)
+	if err := validateTitle(title, _l("Folder name is too long")); err != nil {
+		return nil, err
 	}
 
-	if exists, err := storage.IsFolderDuplicate(pfc.C, pfc.UserID, title); err != nil {
+	if exists, err := storage.IsFolderDuplicate(pfc.C, pfc.UserID, title, ""); err != nil {
 		return nil, err
 	} else if exists {
 		return nil, NewReadableError(_l("A folder with that name already exists"), nil)
@@ -189,6 +602,8 @@ func rename(pfc *PFContext) (interface{}, error) {
 	title := r.PostFormValue("title")
 	if title == "" {
 		return nil, NewReadableError(_l("Name not specified"), nil)
+	} else if err := validateTitle(title, _l("Name is too long")); err != nil {
+		return nil, err
 	}
 
 	ref, err := storage.SubscriptionRefFromJSON(pfc.UserID, r.PostFormValue("ref"))
@@ -213,7 +628,7 @@ func rename(pfc *PFContext) (interface{}, error) {
 			return nil, NewReadableError(_l("Folder not found"), nil)
 		}
 
-		if isDupe, err := storage.IsFolderDuplicate(pfc.C, pfc.UserID, title); err != nil {
+		if isDupe, err := storage.IsFolderDuplicate(pfc.C, pfc.UserID, title, ref.FolderID); err != nil {
 			return nil, err
 		} else if isDupe {
 			return nil, NewReadableError(_l("A folder with that name already exists"), nil)
@@ -227,75 +642,505 @@ func rename(pfc *PFContext) (interface{}, error) {
 	return storage.NewUserSubscriptions(pfc.C, pfc.UserID)
 }
 
-func setProperty(pfc *PFContext) (interface{}, error) {
+func pauseSubscription(pfc *PFContext) (interface{}, error) {
 	r := pfc.R
 
-	folderID := r.PostFormValue("folder")
-	subscriptionID := r.PostFormValue("subscription")
-	articleID := r.PostFormValue("article")
-	propertyName := r.PostFormValue("property")
-	propertyValue := r.PostFormValue("set") == "true"
+	ref := storage.SubscriptionRef {
+		FolderRef: storage.FolderRef {
+			UserID: pfc.UserID,
+			FolderID: r.PostFormValue("folder"),
+		},
+		SubscriptionID: r.PostFormValue("subscription"),
+	}
 
-	if articleID == "" || subscriptionID == "" {
-		return nil, NewReadableError(_l("Article not found"), nil)
+	if !ref.IsSubscriptionExplicit() {
+		return nil, NewReadableError(_l("Subscription not found"), nil)
+	} else if exists, err := storage.SubscriptionExists(pfc.C, ref); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, NewReadableError(_l("Subscription not found"), nil)
 	}
 
-	if !validProperties[propertyName] {
-		return nil, NewReadableError(_l("Property not valid"), nil)
+	paused := r.PostFormValue("paused") != "false"
+	if err := storage.SetPaused(pfc.C, ref, paused); err != nil {
+		return nil, NewReadableError(_l("Error updating subscription"), &err)
 	}
 
-	ref := storage.ArticleRef {
-		SubscriptionRef: storage.SubscriptionRef {
-			FolderRef: storage.FolderRef {
-				UserID: pfc.UserID,
-				FolderID: folderID,
-			},
-			SubscriptionID: subscriptionID,
+	return storage.NewUserSubscriptions(pfc.C, pfc.UserID)
+}
+
+func setResurfaceUpdates(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	ref := storage.SubscriptionRef {
+		FolderRef: storage.FolderRef {
+			UserID: pfc.UserID,
+			FolderID: r.PostFormValue("folder"),
 		},
-		ArticleID: articleID,
+		SubscriptionID: r.PostFormValue("subscription"),
 	}
 
-	if properties, err := storage.SetProperty(pfc.C, ref, propertyName, propertyValue); err != nil {
-		return nil, NewReadableError(_l("Error updating article"), &err)
-	} else {
-		return properties, nil
+	if !ref.IsSubscriptionExplicit() {
+		return nil, NewReadableError(_l("Subscription not found"), nil)
+	} else if exists, err := storage.SubscriptionExists(pfc.C, ref); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, NewReadableError(_l("Subscription not found"), nil)
 	}
-}
 
-func setTags(pfc *PFContext) (interface{}, error) {
-	r := pfc.R
+	resurface := r.PostFormValue("enabled") != "false"
+	if err := storage.SetResurfaceUpdates(pfc.C, ref, resurface); err != nil {
+		return nil, NewReadableError(_l("Error updating subscription"), &err)
+	}
 
-	folderID := r.PostFormValue("folder")
-	subscriptionID := r.PostFormValue("subscription")
-	articleID := r.PostFormValue("article")
-	tagsAsString := r.PostFormValue("tags")
+	return storage.NewUserSubscriptions(pfc.C, pfc.UserID)
+}
 
-	tags := make([]string, 0, 10)
-OuterLoop:
-	for _, tag := range strings.Split(tagsAsString, ",") {
-		if trimmedTag := strings.TrimSpace(tag); len(trimmedTag) > 0 {
-			for _, existingTag := range tags {
-				if existingTag == trimmedTag {
-					continue OuterLoop
-				}
-			}
-			
-			tags = append(tags, trimmedTag)
+// setMuteWords replaces the comma-separated words (or phrases) that 
+// have new articles stored as read
+func setMuteWords(pfc *PFContext) (interface{}, error) {
+	words := make([]string, 0, 10)
+	seen := make(map[string]bool)
+
+	for _, word := range strings.Split(pfc.R.PostFormValue("words"), ",") {
+		if word = strings.TrimSpace(word); word == "" || seen[strings.ToLower(word)] {
+			continue
+		} else if utf8.RuneCountInString(word) > maxMuteWordLength {
+			return nil, NewReadableErrorWithCode(_l("Mute word is too long"), http.StatusBadRequest, nil)
 		}
+
+		seen[strings.ToLower(word)] = true
+		words = append(words, word)
 	}
 
-	if articleID == "" || subscriptionID == "" {
-		return nil, NewReadableError(_l("Article not found"), nil)
+	if len(words) > maxMuteWords {
+		return nil, NewReadableErrorWithCode(_l("Too many mute words"), http.StatusBadRequest, nil)
 	}
 
-	ref := storage.ArticleRef {
-		SubscriptionRef: storage.SubscriptionRef {
-			FolderRef: storage.FolderRef {
-				UserID: pfc.UserID,
-				FolderID: folderID,
-			},
-			SubscriptionID: subscriptionID,
-		},
+	if err := storage.SetMuteWords(pfc.C, pfc.UserID, words); err != nil {
+		return nil, NewReadableError(_l("Error saving mute words"), &err)
+	}
+
+	return map[string]interface{} {
+		"words": words,
+	}, nil
+}
+
+func setDedupByLink(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	ref := storage.SubscriptionRef {
+		FolderRef: storage.FolderRef {
+			UserID: pfc.UserID,
+			FolderID: r.PostFormValue("folder"),
+		},
+		SubscriptionID: r.PostFormValue("subscription"),
+	}
+
+	if !ref.IsSubscriptionExplicit() {
+		return nil, NewReadableError(_l("Subscription not found"), nil)
+	} else if exists, err := storage.SubscriptionExists(pfc.C, ref); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, NewReadableError(_l("Subscription not found"), nil)
+	}
+
+	dedup := r.PostFormValue("enabled") != "false"
+	if err := storage.SetDedupByLink(pfc.C, ref, dedup); err != nil {
+		return nil, NewReadableError(_l("Error updating subscription"), &err)
+	}
+
+	return storage.NewUserSubscriptions(pfc.C, pfc.UserID)
+}
+
+// setDisplayMode sets whether a subscription is read as summaries or 
+// as full content. The server only stores the preference, for clients
+// to apply (and for "fields=preferred" on /articles); an empty mode 
+// removes it
+func setDisplayMode(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	ref := storage.SubscriptionRef {
+		FolderRef: storage.FolderRef {
+			UserID: pfc.UserID,
+			FolderID: r.PostFormValue("folder"),
+		},
+		SubscriptionID: r.PostFormValue("subscription"),
+	}
+
+	mode := r.PostFormValue("mode")
+	if mode != "" && !validDisplayModes[mode] {
+		return nil, NewReadableErrorWithCode(_l("Display mode not valid"), http.StatusBadRequest, nil)
+	}
+
+	if !ref.IsSubscriptionExplicit() {
+		return nil, NewReadableError(_l("Subscription not found"), nil)
+	} else if exists, err := storage.SubscriptionExists(pfc.C, ref); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, NewReadableError(_l("Subscription not found"), nil)
+	}
+
+	if err := storage.SetDisplayMode(pfc.C, ref, mode); err != nil {
+		return nil, NewReadableError(_l("Error updating subscription"), &err)
+	}
+
+	return storage.NewUserSubscriptions(pfc.C, pfc.UserID)
+}
+
+func touchSubscription(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	ref := storage.SubscriptionRef {
+		FolderRef: storage.FolderRef {
+			UserID: pfc.UserID,
+			FolderID: r.PostFormValue("folder"),
+		},
+		SubscriptionID: r.PostFormValue("subscription"),
+	}
+
+	if !ref.IsSubscriptionExplicit() {
+		return nil, NewReadableError(_l("Subscription not found"), nil)
+	} else if exists, err := storage.SubscriptionExists(pfc.C, ref); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, NewReadableError(_l("Subscription not found"), nil)
+	}
+
+	if lastVisited, err := storage.TouchSubscription(pfc.C, ref); err != nil {
+		return nil, NewReadableError(_l("Error updating subscription"), &err)
+	} else {
+		// The client resets its own count, so there's no need to 
+		// send back all subscriptions
+		return map[string]interface{} {
+			"lastVisited": lastVisited,
+		}, nil
+	}
+}
+
+func setSubscriptionWebhook(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	ref := storage.SubscriptionRef {
+		FolderRef: storage.FolderRef {
+			UserID: pfc.UserID,
+			FolderID: r.PostFormValue("folder"),
+		},
+		SubscriptionID: r.PostFormValue("subscription"),
+	}
+
+	if !ref.IsSubscriptionExplicit() {
+		return nil, NewReadableError(_l("Subscription not found"), nil)
+	} else if exists, err := storage.SubscriptionExists(pfc.C, ref); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, NewReadableError(_l("Subscription not found"), nil)
+	}
+
+	// An empty URL removes the webhook
+	webhookURL := strings.TrimSpace(r.PostFormValue("url"))
+	secret := ""
+
+	if webhookURL != "" {
+		if parsed, err := url.ParseRequestURI(webhookURL); err != nil {
+			return nil, NewReadableErrorWithCode(_l("URL is not valid"), http.StatusBadRequest, &err)
+		} else if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return nil, NewReadableErrorWithCode(_l("URL is not valid"), http.StatusBadRequest, nil)
+		} else if !isFetchableURL(webhookURL) {
+			return nil, NewReadableErrorWithCode(_l("URL is not valid"), http.StatusBadRequest, nil)
+		}
+
+		// Receivers use the secret to verify notifications. It's only
+		// revealed here, when the webhook is set
+		secretBytes := make([]byte, 16)
+		if _, err := rand.Read(secretBytes); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(secretBytes)
+	}
+
+	if err := storage.SetWebhook(pfc.C, ref, webhookURL, secret); err != nil {
+		return nil, NewReadableError(_l("Error updating subscription"), &err)
+	}
+
+	if subscriptions, err := storage.NewUserSubscriptions(pfc.C, pfc.UserID); err != nil {
+		return nil, err
+	} else {
+		return map[string]interface{} {
+			"secret": secret,
+			"subscriptions": subscriptions,
+		}, nil
+	}
+}
+
+func setAutoReadAge(pfc *PFContext) (interface{}, error) {
+	// Zero (or empty) keeps unread articles unread
+	days := 0
+	if daysAsString := pfc.R.PostFormValue("days"); daysAsString != "" {
+		var err error
+		if days, err = strconv.Atoi(daysAsString); err != nil || days < 0 || days > maxAutoReadAgeInDays {
+			return nil, NewReadableErrorWithCode(_l("Age not valid"), http.StatusBadRequest, nil)
+		}
+	}
+
+	if err := storage.SetAutoReadAge(pfc.C, pfc.UserID, days); err != nil {
+		return nil, NewReadableError(_l("Error updating settings"), &err)
+	}
+
+	return map[string]interface{} {
+		"autoReadAgeDays": days,
+	}, nil
+}
+
+func setRefreshInterval(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	ref := storage.SubscriptionRef {
+		FolderRef: storage.FolderRef {
+			UserID: pfc.UserID,
+			FolderID: r.PostFormValue("folder"),
+		},
+		SubscriptionID: r.PostFormValue("subscription"),
+	}
+
+	if !ref.IsSubscriptionExplicit() {
+		return nil, NewReadableError(_l("Subscription not found"), nil)
+	} else if exists, err := storage.SubscriptionExists(pfc.C, ref); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, NewReadableError(_l("Subscription not found"), nil)
+	}
+
+	// Zero (or empty) reverts to the feed's own schedule
+	minutes := 0
+	if minutesAsString := r.PostFormValue("minutes"); minutesAsString != "" {
+		var err error
+		if minutes, err = strconv.Atoi(minutesAsString); err != nil || minutes < 0 {
+			return nil, NewReadableErrorWithCode(_l("Refresh interval not valid"), http.StatusBadRequest, nil)
+		}
+	}
+
+	if minutes > 0 {
+		if minutes < minRefreshIntervalInMinutes {
+			minutes = minRefreshIntervalInMinutes
+		} else if minutes > maxRefreshIntervalInMinutes {
+			minutes = maxRefreshIntervalInMinutes
+		}
+	}
+
+	if err := storage.SetRefreshInterval(pfc.C, ref, minutes); err != nil {
+		return nil, NewReadableError(_l("Error updating subscription"), &err)
+	}
+
+	return storage.NewUserSubscriptions(pfc.C, pfc.UserID)
+}
+
+func setFolderDefaultFilter(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	folderID := r.PostFormValue("folder")
+	property := r.PostFormValue("filter")
+
+	if folderID == "" {
+		return nil, NewReadableError(_l("Folder not found"), nil)
+	}
+
+	// An empty filter clears the default
+	if property != "" && !validProperties[property] {
+		return nil, NewReadableError(_l("Property not valid"), nil)
+	}
+
+	ref := storage.FolderRef {
+		UserID: pfc.UserID,
+		FolderID: folderID,
+	}
+
+	if exists, err := storage.FolderExists(pfc.C, ref); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, NewReadableError(_l("Folder not found"), nil)
+	}
+
+	if err := storage.SetFolderDefaultFilter(pfc.C, ref, property); err != nil {
+		return nil, NewReadableError(_l("Error updating folder"), &err)
+	}
+
+	return storage.NewUserSubscriptions(pfc.C, pfc.UserID)
+}
+
+func setProperty(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	folderID := r.PostFormValue("folder")
+	subscriptionID := r.PostFormValue("subscription")
+	articleID := r.PostFormValue("article")
+	propertyName := r.PostFormValue("property")
+	propertyValue := r.PostFormValue("set") == "true"
+	toggle := r.PostFormValue("toggle") == "true"
+
+	if articleID == "" || subscriptionID == "" {
+		return nil, NewReadableError(_l("Article not found"), nil)
+	}
+
+	if !validProperties[propertyName] {
+		return nil, NewReadableError(_l("Property not valid"), nil)
+	}
+
+	ref := storage.ArticleRef {
+		SubscriptionRef: storage.SubscriptionRef {
+			FolderRef: storage.FolderRef {
+				UserID: pfc.UserID,
+				FolderID: folderID,
+			},
+			SubscriptionID: subscriptionID,
+		},
+		ArticleID: articleID,
+	}
+
+	// If a version is specified, the article is only modified if its
+	// properties haven't changed since
+	version := int64(-1)
+	if v := r.PostFormValue("version"); v != "" {
+		var err error
+		if version, err = strconv.ParseInt(v, 10, 64); err != nil || version < 0 {
+			return nil, NewReadableErrorWithCode(_l("Version not valid"), http.StatusBadRequest, nil)
+		}
+	}
+
+	var state *storage.PropertyState
+	var err error
+	if toggle {
+		state, err = storage.ToggleProperty(pfc.C, ref, propertyName, version)
+	} else {
+		state, err = storage.SetProperty(pfc.C, ref, propertyName, propertyValue, version)
+	}
+
+	if err == storage.ErrVersionMismatch {
+		return nil, NewReadableErrorWithCode(_l("The article has changed; reload and try again"), http.StatusPreconditionFailed, nil)
+	} else if err != nil {
+		return nil, NewReadableError(_l("Error updating article"), &err)
+	}
+
+	// The body remains the list of properties; the version is sent as
+	// the entity tag
+	pfc.W.Header().Set("ETag", strconv.Quote(strconv.FormatInt(state.Version, 10)))
+
+	return state.Properties, nil
+}
+
+// getProperties returns the current properties of several articles at
+// once (e.g. those displayed from a cached page). The articles are 
+// posted as a JSON list of references; results are in the same order,
+// with "found" false for articles that don't exist
+func getProperties(pfc *PFContext) (interface{}, error) {
+	var refs []storage.ArticleRef
+	if err := json.Unmarshal([]byte(pfc.R.PostFormValue("refs")), &refs); err != nil {
+		return nil, NewReadableErrorWithCode(_l("Article list not valid"), http.StatusBadRequest, &err)
+	} else if len(refs) > maxPropertyRefs {
+		return nil, NewReadableErrorWithCode(_l("Too many articles (at most %d)", maxPropertyRefs), http.StatusBadRequest, nil)
+	}
+
+	for i := range refs {
+		refs[i].UserID = pfc.UserID
+	}
+
+	states, err := storage.ArticleProperties(pfc.C, refs)
+	if err != nil {
+		return nil, NewReadableError(_l("Error reading articles"), &err)
+	}
+
+	results := make([]map[string]interface{}, len(refs))
+	for i, state := range states {
+		if state == nil {
+			results[i] = map[string]interface{} {
+				"found": false,
+			}
+		} else {
+			results[i] = map[string]interface{} {
+				"found": true,
+				"properties": state.Properties,
+				"version": state.Version,
+			}
+		}
+	}
+
+	return results, nil
+}
+
+func setReadPosition(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	folderID := r.PostFormValue("folder")
+	subscriptionID := r.PostFormValue("subscription")
+	articleID := r.PostFormValue("article")
+	anchor := r.PostFormValue("anchor")
+
+	if articleID == "" || subscriptionID == "" {
+		return nil, NewReadableError(_l("Article not found"), nil)
+	}
+
+	position, err := strconv.ParseFloat(r.PostFormValue("position"), 64)
+	if err != nil || position < 0 || position > 1 {
+		return nil, NewReadableErrorWithCode(_l("Read position not valid"), http.StatusBadRequest, nil)
+	}
+
+	ref := storage.ArticleRef {
+		SubscriptionRef: storage.SubscriptionRef {
+			FolderRef: storage.FolderRef {
+				UserID: pfc.UserID,
+				FolderID: folderID,
+			},
+			SubscriptionID: subscriptionID,
+		},
+		ArticleID: articleID,
+	}
+
+	if err := storage.SetReadPosition(pfc.C, ref, position, anchor); err == datastore.ErrNoSuchEntity {
+		return nil, NewReadableError(_l("Article not found"), nil)
+	} else if err != nil {
+		return nil, NewReadableError(_l("Error updating article"), &err)
+	}
+
+	return map[string]interface{} {
+		"readPosition": position,
+		"readAnchor": anchor,
+	}, nil
+}
+
+func setTags(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	folderID := r.PostFormValue("folder")
+	subscriptionID := r.PostFormValue("subscription")
+	articleID := r.PostFormValue("article")
+	tagsAsString := r.PostFormValue("tags")
+
+	tags := make([]string, 0, 10)
+OuterLoop:
+	for _, tag := range strings.Split(tagsAsString, ",") {
+		if trimmedTag := strings.TrimSpace(tag); len(trimmedTag) > 0 {
+			for _, existingTag := range tags {
+				if existingTag == trimmedTag {
+					continue OuterLoop
+				}
+			}
+			
+			tags = append(tags, trimmedTag)
+		}
+	}
+
+	if articleID == "" || subscriptionID == "" {
+		return nil, NewReadableError(_l("Article not found"), nil)
+	}
+
+	ref := storage.ArticleRef {
+		SubscriptionRef: storage.SubscriptionRef {
+			FolderRef: storage.FolderRef {
+				UserID: pfc.UserID,
+				FolderID: folderID,
+			},
+			SubscriptionID: subscriptionID,
+		},
 		ArticleID: articleID,
 	}
 
@@ -311,12 +1156,13 @@ OuterLoop:
 }
 
 func subscribe(pfc *PFContext) (interface{}, error) {
-	c := pfc.C
 	r := pfc.R
 
 	subscriptionURL := r.PostFormValue("url")
 	folderId := r.PostFormValue("folder")
 
+	c := withLogFields(pfc.C, "feed", subscriptionURL)
+
 	if subscriptionURL == "" {
 		return nil, NewReadableError(_l("Missing URL"), nil)
 	} else if _, err := url.ParseRequestURI(subscriptionURL); err != nil {
@@ -329,158 +1175,473 @@ func subscribe(pfc *PFContext) (interface{}, error) {
 	}
 
 	if folderId != "" {
-		if exists, err := storage.FolderExists(pfc.C, folderRef); err != nil {
+		if exists, err := storage.FolderExists(c, folderRef); err != nil {
 			return nil, err
 		} else if !exists {
 			return nil, NewReadableError(_l("Folder not found"), nil)
 		}
 	}
 
+	// Optionally, subscribe into a folder created along the way
+	newFolderName := strings.TrimSpace(r.PostFormValue("newFolderName"))
+	if newFolderName != "" {
+		if folderId != "" {
+			return nil, NewReadableErrorWithCode(_l("Specify either a folder or a new folder name"), http.StatusBadRequest, nil)
+		} else if err := validateTitle(newFolderName, _l("Folder name is too long")); err != nil {
+			return nil, err
+		}
+	}
+
 	feedTitle := _l("New Subscription")
 
-	if exists, err := storage.IsFeedAvailable(pfc.C, subscriptionURL); err != nil {
-		return nil, err
-	} else if !exists {
-		// Not a known feed URL
-		// Match it against a list of known WWW links
-		if feedURL, err := storage.WebToFeedURL(pfc.C, subscriptionURL, &feedTitle); err != nil {
+	// Match the URL (and its www/scheme variants, in order) against
+	// known feed URLs, then against the known WWW links of feeds
+	candidateURLs := candidateFeedURLs(subscriptionURL)
+	for _, candidateURL := range candidateURLs {
+		if exists, err := storage.IsFeedAvailable(c, candidateURL); err != nil {
+			return nil, err
+		} else if exists {
+			if feed, err := storage.FeedByURL(c, candidateURL); err == nil && feed != nil && feed.Title != "" {
+				feedTitle = feed.Title
+			}
+
+			subscriptionURL = candidateURL
+			break
+		}
+
+		if feedURL, err := storage.CanonicalToFeedURL(c, candidateURL, &feedTitle); err != nil {
+			return nil, err
+		} else if feedURL != "" {
+			subscriptionURL = feedURL
+			break
+		}
+
+		if feedURL, err := storage.WebToFeedURL(c, candidateURL, &feedTitle); err != nil {
 			return nil, err
 		} else if feedURL != "" {
 			subscriptionURL = feedURL
+			break
+		}
+	}
+
+	if _, subscribed, err := storage.SubscriptionByFeedURL(c, pfc.UserID, subscriptionURL); err != nil {
+		return nil, err
+	} else if subscribed {
+		return nil, NewReadableError(_l("You are already subscribed to %s", feedTitle), nil)
+	}
+
+	// At this point, the URL may have been re-written, so we check again
+	if exists, err := storage.IsFeedAvailable(c, subscriptionURL); err != nil {
+		return nil, err
+	} else if !exists {
+		// Don't have the feed locally - fetch it, trying each of
+		// the variants of the URL until one of them responds
+		client := createHttpClient(c)
+		if response, fetchedURL, err := getFirstAvailable(client, candidateURLs); err != nil {
+			return nil, NewReadableError(_l("An error occurred while downloading the feed"), &err)
 		} else {
-			// Still nothing
-			// Add/remove 'www' to/from URL and try again
-			var modifiedURL string
-			if re := regexp.MustCompile(`://www\.`); re.MatchString(subscriptionURL) {
-				modifiedURL = re.ReplaceAllString(subscriptionURL, "://")
-			} else {
-				re = regexp.MustCompile(`://`)
-				modifiedURL = re.ReplaceAllString(subscriptionURL, "://www.")
+			defer response.Body.Close()
+			subscriptionURL = fetchedURL
+			
+			// Hold on to the content - if it's a web page, it's 
+			// scanned for links to the feed
+			content, err := ioutil.ReadAll(io.LimitReader(response.Body, rss.MaxFeedSize))
+			if err != nil {
+				return nil, NewReadableError(_l("An error occurred while reading the feed"), &err)
 			}
 
-			if feedURL, err := storage.WebToFeedURL(pfc.C, modifiedURL, &feedTitle); err != nil {
-				return nil, err
-			} else if feedURL != "" {
-				subscriptionURL = feedURL
+			if result, err := rss.Unmarshal(subscriptionURL, content); err != nil {
+				c.Warningf("Error parsing RSS (URL %s): %s", subscriptionURL, err)
+				parseErr := err
+
+				// Take the server's word for it too, since sniffing
+				// can miss a page that breaks before its root element
+				isHTML := result.IsHTML || strings.HasPrefix(strings.ToLower(response.Header.Get("Content-Type")), "text/html")
+				if !isHTML {
+					return nil, NewReadableError(_l("The feed appears to be malformed"), &parseErr)
+				}
+
+				// The document is a web page - try to pull out 
+				// an RSS <link />. Failing that, if it's a splash or 
+				// redirect page, try the page it points to
+				linkURL, err := rss.ExtractRSSLink(c, subscriptionURL, string(content))
+				if err == nil && linkURL == "" {
+					if linkURL, err = feedLinkBehindRedirectPage(c, client, subscriptionURL, string(content)); err != nil {
+						c.Warningf("Error following redirect page (%s): %s", subscriptionURL, err)
+						err = nil
+					}
+				}
+
+				if err != nil {
+					return nil, NewReadableError(_l("RSS content not found (and no RSS links to follow)"), &err)
+				} else if linkURL == "" {
+					return nil, NewReadableError(_l("This looks like a web page, not a feed (and it has no RSS links to follow)"), &parseErr)
+				} else {
+					// Validate the RSS file
+					if response, err := client.Get(linkURL); err != nil {
+						return nil, NewReadableError(_l("An error occurred while downloading the feed"), &err)
+					} else {
+						defer response.Body.Close()
+
+						if linked, err := rss.UnmarshalStream(linkURL, response.Body); err != nil {
+							return nil, NewReadableError(_l("RSS content not found"), &err)
+						} else {
+							feedTitle = linked.Feed.Title
+						}
+
+						subscriptionURL = linkURL
+					}
+				}
+			} else {
+				feedTitle = result.Feed.Title
+
+				// If the feed is already known by its canonical 
+				// address, subscribe to that instead - but only if 
+				// the known feed declares the same self link, since 
+				// any feed can claim to be another
+				if canonicalURL := result.Feed.CanonicalURL(); canonicalURL != "" {
+					if known, err := storage.FeedByURL(c, canonicalURL); err != nil {
+						c.Warningf("Error checking for canonical feed %s: %s", canonicalURL, err)
+					} else if known != nil && known.Topic == canonicalURL {
+						if _, subscribed, err := storage.SubscriptionByFeedURL(c, pfc.UserID, canonicalURL); err != nil {
+							return nil, err
+						} else if subscribed {
+							return nil, NewReadableError(_l("You are already subscribed to %s", feedTitle), nil)
+						}
+
+						subscriptionURL = canonicalURL
+					}
+				}
 			}
 		}
-	} else if feed, err := storage.FeedByURL(pfc.C, subscriptionURL); err == nil {
+	} else if feed, err := storage.FeedByURL(c, subscriptionURL); err == nil {
 		if feed.Title != "" {
 			feedTitle = feed.Title
 		}
 	}
 
-	if subscribed, err := storage.IsSubscriptionDuplicate(pfc.C, pfc.UserID, subscriptionURL); err != nil {
-		return nil, err
-	} else if subscribed {
-		return nil, NewReadableError(_l("You are already subscribed to %s", feedTitle), nil)
+	// The new folder is only created once the feed checks out, so 
+	// that a failed subscription doesn't leave an empty folder behind.
+	// A folder that already goes by the name is used instead
+	createdFolder := false
+	if newFolderName != "" {
+		if existingRef, err := storage.FolderByTitle(c, pfc.UserID, newFolderName); err != nil {
+			return nil, err
+		} else if existingRef.FolderID != "" {
+			folderRef = existingRef
+		} else if folderRef, err = storage.CreateFolder(c, pfc.UserID, newFolderName); err != nil {
+			return nil, NewReadableError(_l("An error occurred while adding the new folder"), &err)
+		} else {
+			createdFolder = true
+		}
+
+		folderId = folderRef.FolderID
+	}
+
+	// Removing the new folder also removes the subscription in it
+	rollBack := func() {
+		if createdFolder {
+			if err := storage.DeleteFolder(c, folderRef); err != nil {
+				c.Warningf("Error removing new folder: %s", err)
+			}
+		}
+	}
+
+	// Create subscription entry
+	if _, err := storage.Subscribe(c, folderRef, subscriptionURL, feedTitle, ""); err != nil {
+		rollBack()
+		return nil, NewReadableError(_l("Cannot subscribe"), &err)
+	}
+
+	params := taskParams {
+		"url":      subscriptionURL,
+		"folderID": folderId,
+		"markExistingRead": strconv.FormatBool(r.PostFormValue("markExistingRead") == "true"),
+	}
+	if err := startTask(pfc, "subscribe", params, subscriptionQueue); err != nil {
+		rollBack()
+		return nil, NewReadableError(_l("Cannot subscribe - too busy"), &err)
+	}
+
+	if userSubscriptions, err := storage.NewUserSubscriptions(c, pfc.UserID); err != nil {
+		return nil, err
+	} else {
+		// The URL may have been re-written along the way; 
+		// let the client know what it ended up being
+		return subscribeResult {
+			UserSubscriptions: userSubscriptions,
+			SubscriptionURL: subscriptionURL,
+		}, nil
+	}
+}
+
+func unsubscribe(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	subscriptionID := r.PostFormValue("subscription")
+	folderID := r.PostFormValue("folder")
+
+	// Remove a subscription
+	ref := storage.SubscriptionRef {
+		FolderRef: storage.FolderRef {
+			UserID: pfc.UserID,
+			FolderID: folderID,
+		},
+		SubscriptionID: subscriptionID,
+	}
+
+	// Alternatively, the subscription can be identified by its feed
+	// URL (or one of its variants), for scripting
+	if feedURL := r.PostFormValue("url"); subscriptionID == "" && feedURL != "" {
+		found := false
+		for _, candidateURL := range candidateFeedURLs(feedURL) {
+			var err error
+			if ref, found, err = storage.SubscriptionByFeedURL(pfc.C, pfc.UserID, candidateURL); err != nil {
+				return nil, err
+			} else if found {
+				break
+			}
+		}
+
+		if !found {
+			return nil, NewReadableError(_l("Subscription not found"), nil)
+		}
+	}
+
+	if err := unsubscribeRef(pfc, ref); err != nil {
+		return nil, err
+	}
+
+	return storage.NewUserSubscriptions(pfc.C, pfc.UserID)
+}
+
+// unsubscribeRef removes a subscription, and starts a task 
+// to purge its articles
+func unsubscribeRef(pfc *PFContext, ref storage.SubscriptionRef) error {
+	if exists, err := storage.SubscriptionExists(pfc.C, ref); err != nil {
+		return err
+	} else if !exists {
+		return NewReadableError(_l("Subscription not found"), nil)
+	}
+
+	if err := storage.Unsubscribe(pfc.C, ref); err != nil {
+		return err
+	}
+
+	params := taskParams {
+		"subscriptionID": ref.SubscriptionID,
+		"folderID": ref.FolderID,
+	}
+	if err := startTask(pfc, "unsubscribe", params, modificationQueue); err != nil {
+		return NewReadableError(_l("Cannot unsubscribe - too busy"), &err)
+	}
+
+	return nil
+}
+
+func unsubscribeBatch(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	var refs []storage.SubscriptionRef
+	if folderID := r.PostFormValue("folder"); folderID != "" {
+		// Everything within the folder
+		folderRef := storage.FolderRef {
+			UserID: pfc.UserID,
+			FolderID: folderID,
+		}
+
+		if exists, err := storage.FolderExists(pfc.C, folderRef); err != nil {
+			return nil, err
+		} else if !exists {
+			return nil, NewReadableError(_l("Folder not found"), nil)
+		}
+
+		var err error
+		if refs, err = storage.SubscriptionRefsWithinFolder(pfc.C, folderRef); err != nil {
+			return nil, err
+		}
+	} else if refsAsJSON := r.PostFormValue("refs"); refsAsJSON != "" {
+		if err := json.Unmarshal([]byte(refsAsJSON), &refs); err != nil {
+			return nil, NewReadableErrorWithCode(_l("Subscription list not valid"), http.StatusBadRequest, &err)
+		}
+	} else {
+		return nil, NewReadableError(_l("No subscriptions specified"), nil)
+	}
+
+	results := make([]map[string]interface{}, len(refs))
+	for i, ref := range refs {
+		ref.UserID = pfc.UserID
+
+		result := map[string]interface{} {
+			"ref": ref,
+			"success": true,
+		}
+
+		if !ref.IsSubscriptionExplicit() {
+			result["success"] = false
+			result["error"] = _l("Subscription not found")
+		} else if err := unsubscribeRef(pfc, ref); err != nil {
+			pfc.C.Warningf("Error unsubscribing from %s: %s", ref.SubscriptionID, err)
+
+			result["success"] = false
+			if _, ok := err.(ReadableError); ok {
+				result["error"] = err.Error()
+			} else {
+				result["error"] = _l("An unexpected error has occurred")
+			}
+		}
+
+		results[i] = result
+	}
+
+	if subscriptions, err := storage.NewUserSubscriptions(pfc.C, pfc.UserID); err != nil {
+		return nil, err
+	} else {
+		return map[string]interface{} {
+			"results": results,
+			"subscriptions": subscriptions,
+		}, nil
+	}
+}
+
+func importOPML(pfc *PFContext) (interface{}, error) {
+	c := pfc.C
+	r := pfc.R
+
+	blobs, other, err := blobstore.ParseUpload(r)
+	if err != nil {
+		return nil, NewReadableError(_l("Error receiving file"), &err)
+	} else if len(other["client"]) > 0 {
+		if clientID := other["client"][0]; clientID != "" {
+			pfc.ChannelID = string(pfc.UserID) + "," + clientID
+		}
+	}
+
+	var blobKey appengine.BlobKey
+	if blobInfos := blobs["opml"]; len(blobInfos) == 0 {
+		return nil, NewReadableError(_l("File not uploaded"), nil)
+	} else {
+		blobKey = blobInfos[0].BlobKey
+		reader := blobstore.NewReader(c, blobKey)
+
+		if _, err := rss.ParseOPML(reader); err != nil {
+			if err := blobstore.Delete(c, blobKey); err != nil {
+				c.Warningf("Error deleting blob (key %s): %s", blobKey, err)
+			}
+
+			return nil, NewReadableError(_l("Error reading OPML file"), &err)
+		}
+	}
+
+	params := taskParams {
+		"opmlBlobKey": string(blobKey),
+	}
+	if err := startTask(pfc, "import", params, importQueue); err != nil {
+		// Remove the blob
+		if err := blobstore.Delete(c, blobKey); err != nil {
+			c.Warningf("Error deleting blob (key %s): %s", blobKey, err)
+		}
+
+		return nil, NewReadableError(_l("Cannot import - too busy"), &err)
 	}
 
-	// At this point, the URL may have been re-written, so we check again
-	if exists, err := storage.IsFeedAvailable(pfc.C, subscriptionURL); err != nil {
-		return nil, err
-	} else if !exists {
-		// Don't have the feed locally - fetch it
-		client := createHttpClient(c)
-		if response, err := client.Get(subscriptionURL); err != nil {
-			return nil, NewReadableError(_l("An error occurred while downloading the feed"), &err)
-		} else {
-			defer response.Body.Close()
-			
-			var body string
-			if bytes, err := ioutil.ReadAll(response.Body); err != nil {
-				return nil, NewReadableError(_l("An error occurred while reading the feed"), &err)
-			} else {
-				body = string(bytes)
-			}
-
-			reader := strings.NewReader(body)
-			if feed, err := rss.UnmarshalStream(subscriptionURL, reader); err != nil {
-				c.Warningf("Error parsing RSS (URL %s): %s", subscriptionURL, err)
+	return _l("Importing, please wait…"), nil
+}
 
-				// Parse failed. Assume it's an HTML document and 
-				// try to pull out an RSS <link />
-				if linkURL, err := rss.ExtractRSSLink(c, subscriptionURL, body); linkURL == "" || err != nil {
-					return nil, NewReadableError(_l("RSS content not found (and no RSS links to follow)"), &err)
-				} else {
-					// Validate the RSS file
-					if response, err := client.Get(linkURL); err != nil {
-						return nil, NewReadableError(_l("An error occurred while downloading the feed"), &err)
-					} else {
-						defer response.Body.Close()
+// importURL imports the subscriptions in an OPML file hosted at a URL,
+// for scripted migrations. The file is fetched and checked here, then
+// kept in the blobstore for the import task, as if it were uploaded
+func importURL(pfc *PFContext) (interface{}, error) {
+	c := pfc.C
 
-						if feed, err := rss.UnmarshalStream(linkURL, response.Body); err != nil {
-							return nil, NewReadableError(_l("RSS content not found"), &err)
-						} else {
-							feedTitle = feed.Title
-						}
+	opmlURL := strings.TrimSpace(pfc.R.PostFormValue("url"))
+	if opmlURL == "" {
+		return nil, NewReadableErrorWithCode(_l("Missing URL"), http.StatusBadRequest, nil)
+	} else if !isFetchableURL(opmlURL) {
+		return nil, NewReadableErrorWithCode(_l("URL is not valid"), http.StatusBadRequest, nil)
+	}
 
-						subscriptionURL = linkURL
-					}
-				}
-			} else {
-				feedTitle = feed.Title
-			}
-		}
-	} else if feed, err := storage.FeedByURL(pfc.C, subscriptionURL); err == nil {
-		if feed.Title != "" {
-			feedTitle = feed.Title
-		}
+	client := createGuardedHttpClient(c, opmlFetchDeadline)
+	response, err := client.Get(opmlURL)
+	if err != nil {
+		return nil, NewReadableError(_l("An error occurred while downloading the file"), &err)
 	}
 
-	// Create subscription entry
-	if _, err := storage.Subscribe(pfc.C, folderRef, subscriptionURL, feedTitle); err != nil {
-		return nil, NewReadableError(_l("Cannot subscribe"), &err)
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, NewReadableError(_l("The server responded with %s", response.Status), nil)
 	}
 
-	params := taskParams {
-		"url":      subscriptionURL,
-		"folderID": folderId,
+	// Read one byte past the limit, to tell a file that's too large 
+	// from one that just fits
+	content, err := ioutil.ReadAll(io.LimitReader(response.Body, maxOPMLBytes + 1))
+	if err != nil {
+		return nil, NewReadableError(_l("An error occurred while downloading the file"), &err)
+	} else if len(content) > maxOPMLBytes {
+		return nil, NewReadableErrorWithCode(_l("File is too large"), http.StatusRequestEntityTooLarge, nil)
 	}
-	if err := startTask(pfc, "subscribe", params, subscriptionQueue); err != nil {
-		return nil, NewReadableError(_l("Cannot subscribe - too busy"), &err)
+
+	if _, err := rss.ParseOPML(bytes.NewReader(content)); err != nil {
+		return nil, NewReadableError(_l("Error reading OPML file"), &err)
 	}
 
-	return storage.NewUserSubscriptions(c, pfc.UserID)
-}
+	writer, err := blobstore.Create(c, "text/x-opml")
+	if err != nil {
+		return nil, NewReadableError(_l("Error receiving file"), &err)
+	}
 
-func unsubscribe(pfc *PFContext) (interface{}, error) {
-	r := pfc.R
+	if _, err := writer.Write(content); err != nil {
+		return nil, NewReadableError(_l("Error receiving file"), &err)
+	} else if err := writer.Close(); err != nil {
+		return nil, NewReadableError(_l("Error receiving file"), &err)
+	}
 
-	subscriptionID := r.PostFormValue("subscription")
-	folderID := r.PostFormValue("folder")
+	blobKey, err := writer.Key()
+	if err != nil {
+		return nil, NewReadableError(_l("Error receiving file"), &err)
+	}
 
-	// Remove a subscription
-	ref := storage.SubscriptionRef {
-		FolderRef: storage.FolderRef {
-			UserID: pfc.UserID,
-			FolderID: folderID,
-		},
-		SubscriptionID: subscriptionID,
+	params := taskParams {
+		"opmlBlobKey": string(blobKey),
 	}
+	if err := startTask(pfc, "import", params, importQueue); err != nil {
+		// Remove the blob
+		if err := blobstore.Delete(c, blobKey); err != nil {
+			c.Warningf("Error deleting blob (key %s): %s", blobKey, err)
+		}
 
-	if exists, err := storage.SubscriptionExists(pfc.C, ref); err != nil {
-		return nil, err
-	} else if !exists {
-		return nil, NewReadableError(_l("Subscription not found"), nil)
+		return nil, NewReadableError(_l("Cannot import - too busy"), &err)
 	}
 
-	if err := storage.Unsubscribe(pfc.C, ref); err != nil {
-		return nil, err
+	return _l("Importing, please wait…"), nil
+}
+
+// feedLinkBehindRedirectPage looks for a feed link on the page that a
+// splash or redirect page points to. Only a single hop is followed
+func feedLinkBehindRedirectPage(c appengine.Context, client *http.Client, pageURL string, content string) (string, error) {
+	targetURL, err := rss.ExtractRedirectLink(pageURL, content)
+	if err != nil || targetURL == "" {
+		return "", err
 	}
 
-	params := taskParams {
-		"subscriptionID": subscriptionID,
-		"folderID": folderID,
+	response, err := client.Get(targetURL)
+	if err != nil {
+		return "", err
 	}
-	if err := startTask(pfc, "unsubscribe", params, modificationQueue); err != nil {
-		return nil, NewReadableError(_l("Cannot unsubscribe - too busy"), &err)
+	defer response.Body.Close()
+
+	targetContent, err := ioutil.ReadAll(io.LimitReader(response.Body, rss.MaxFeedSize))
+	if err != nil {
+		return "", err
 	}
 
-	return storage.NewUserSubscriptions(pfc.C, pfc.UserID)
+	return rss.ExtractRSSLink(c, targetURL, string(targetContent))
 }
 
-func importOPML(pfc *PFContext) (interface{}, error) {
+// importJSON accepts a subscription export in JSON (Feedly, Google 
+// Reader), uploaded as "json", and starts importing it
+func importJSON(pfc *PFContext) (interface{}, error) {
 	c := pfc.C
 	r := pfc.R
 
@@ -494,25 +1655,25 @@ func importOPML(pfc *PFContext) (interface{}, error) {
 	}
 
 	var blobKey appengine.BlobKey
-	if blobInfos := blobs["opml"]; len(blobInfos) == 0 {
+	if blobInfos := blobs["json"]; len(blobInfos) == 0 {
 		return nil, NewReadableError(_l("File not uploaded"), nil)
 	} else {
 		blobKey = blobInfos[0].BlobKey
 		reader := blobstore.NewReader(c, blobKey)
 
-		if _, err := rss.ParseOPML(reader); err != nil {
+		if _, err := rss.ParseSubscriptionsJSON(reader); err != nil {
 			if err := blobstore.Delete(c, blobKey); err != nil {
 				c.Warningf("Error deleting blob (key %s): %s", blobKey, err)
 			}
 
-			return nil, NewReadableError(_l("Error reading OPML file"), &err)
+			return nil, NewReadableError(_l("Error reading subscription file"), &err)
 		}
 	}
 
 	params := taskParams {
-		"opmlBlobKey": string(blobKey),
+		"jsonBlobKey": string(blobKey),
 	}
-	if err := startTask(pfc, "import", params, importQueue); err != nil {
+	if err := startTask(pfc, "importJSON", params, importQueue); err != nil {
 		// Remove the blob
 		if err := blobstore.Delete(c, blobKey); err != nil {
 			c.Warningf("Error deleting blob (key %s): %s", blobKey, err)
@@ -524,43 +1685,183 @@ func importOPML(pfc *PFContext) (interface{}, error) {
 	return _l("Importing, please wait…"), nil
 }
 
+// validateScope makes sure that the subscription or folder 
+// referenced by the scope exists
+func validateScope(pfc *PFContext, scope storage.ArticleScope) error {
+	if scope.SubscriptionID != "" {
+		if exists, err := storage.SubscriptionExists(pfc.C, storage.SubscriptionRef(scope)); err != nil {
+			return err
+		} else if !exists {
+			return NewReadableError(_l("Subscription not found"), nil)
+		}
+	} else if scope.FolderID != "" {
+		if exists, err := storage.FolderExists(pfc.C, scope.FolderRef); err != nil {
+			return err
+		} else if !exists {
+			return NewReadableError(_l("Folder not found"), nil)
+		}
+	}
+
+	return nil
+}
+
+func hasUnread(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	scope := storage.ArticleScope {
+		FolderRef: storage.FolderRef {
+			UserID: pfc.UserID,
+			FolderID: r.PostFormValue("folder"),
+		},
+		SubscriptionID: r.PostFormValue("subscription"),
+	}
+
+	if err := validateScope(pfc, scope); err != nil {
+		return nil, err
+	}
+
+	if unread, err := storage.HasUnread(pfc.C, scope); err != nil {
+		return nil, err
+	} else {
+		return map[string]bool { "hasUnread": unread }, nil
+	}
+}
+
+func unreadCount(pfc *PFContext) (interface{}, error) {
+	if count, err := storage.UnreadCount(pfc.C, pfc.UserID); err != nil {
+		return nil, err
+	} else {
+		return map[string]int { "unread": count }, nil
+	}
+}
+
+func recountUnread(pfc *PFContext) (interface{}, error) {
+	if count, err := storage.RecountUnread(pfc.C, pfc.UserID); err != nil {
+		return nil, NewReadableError(_l("Error counting unread articles"), &err)
+	} else {
+		return map[string]int { "unread": count }, nil
+	}
+}
+
 func markAllAsRead(pfc *PFContext) (interface{}, error) {
 	r := pfc.R
 
 	subscriptionID := r.PostFormValue("subscription")
 	folderID := r.PostFormValue("folder")
 
+	scope := storage.ArticleScope {
+		FolderRef: storage.FolderRef {
+			UserID: pfc.UserID,
+			FolderID: folderID,
+		},
+		SubscriptionID: subscriptionID,
+	}
+
+	if err := validateScope(pfc, scope); err != nil {
+		return nil, err
+	}
+
+	params := taskParams {
+		"subscriptionID": subscriptionID,
+		"folderID":       folderID,
+		"keepFlagged":    strconv.FormatBool(r.PostFormValue("keepFlagged") == "true"),
+	}
+
 	if subscriptionID != "" {
-		ref := storage.SubscriptionRef {
+		// A single subscription can be marked within the request, if 
+		// need be
+		if err := startTaskOrRun(pfc, "markAllAsRead", params, modificationQueue, markAllAsReadTask); err != nil {
+			return nil, NewReadableError(_l("Error marking items as read"), &err)
+		}
+	} else if err := startTask(pfc, "markAllAsRead", params, modificationQueue); err != nil {
+		return nil, err
+	}
+
+	return _l("Please wait…"), nil
+}
+
+func purgeRead(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	subscriptionID := r.PostFormValue("subscription")
+	folderID := r.PostFormValue("folder")
+
+	scope := storage.ArticleScope {
+		FolderRef: storage.FolderRef {
+			UserID: pfc.UserID,
+			FolderID: folderID,
+		},
+		SubscriptionID: subscriptionID,
+	}
+
+	if err := validateScope(pfc, scope); err != nil {
+		return nil, err
+	}
+
+	params := taskParams {
+		"subscriptionID": subscriptionID,
+		"folderID":       folderID,
+	}
+	if err := startTask(pfc, "purgeRead", params, modificationQueue); err != nil {
+		return nil, err
+	}
+
+	return _l("Please wait…"), nil
+}
+
+func markNewerUnread(pfc *PFContext) (interface{}, error) {
+	r := pfc.R
+
+	folderID := r.PostFormValue("folder")
+	subscriptionID := r.PostFormValue("subscription")
+	articleID := r.PostFormValue("article")
+
+	if articleID == "" || subscriptionID == "" {
+		return nil, NewReadableError(_l("Article not found"), nil)
+	}
+
+	ref := storage.ArticleRef {
+		SubscriptionRef: storage.SubscriptionRef {
 			FolderRef: storage.FolderRef {
 				UserID: pfc.UserID,
 				FolderID: folderID,
 			},
 			SubscriptionID: subscriptionID,
-		}
-		if exists, err := storage.SubscriptionExists(pfc.C, ref); err != nil {
-			return nil, err
-		} else if !exists {
-			return nil, NewReadableError(_l("Subscription not found"), nil)
-		}
-	} else if folderID != "" {
-		ref := storage.FolderRef {
+		},
+		ArticleID: articleID,
+	}
+
+	// Scope defaults to the entire collection
+	scope := storage.ArticleScope {
+		FolderRef: storage.FolderRef {
 			UserID: pfc.UserID,
-			FolderID: folderID,
-		}
+		},
+	}
 
-		if exists, err := storage.FolderExists(pfc.C, ref); err != nil {
-			return nil, err
-		} else if !exists {
-			return nil, NewReadableError(_l("Folder not found"), nil)
+	if scopeAsJSON := r.PostFormValue("scope"); scopeAsJSON != "" {
+		var err error
+		if scope, err = storage.ArticleScopeFromJSON(pfc.UserID, scopeAsJSON); err != nil {
+			return nil, NewReadableErrorWithCode(_l("Scope not valid"), http.StatusBadRequest, &err)
 		}
 	}
 
+	if err := validateScope(pfc, scope); err != nil {
+		return nil, err
+	}
+
+	fetched, err := storage.ArticleFetchTime(pfc.C, ref)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, NewReadableError(_l("Article not found"), nil)
+	} else if err != nil {
+		return nil, err
+	}
+
 	params := taskParams {
-		"subscriptionID": subscriptionID,
-		"folderID":       folderID,
+		"subscriptionID": scope.SubscriptionID,
+		"folderID":       scope.FolderID,
+		"since":          fetched.Format(time.RFC3339Nano),
 	}
-	if err := startTask(pfc, "markAllAsRead", params, modificationQueue); err != nil {
+	if err := startTask(pfc, "markNewerUnread", params, modificationQueue); err != nil {
 		return nil, err
 	}
 
@@ -621,7 +1922,13 @@ func moveSubscription(pfc *PFContext) (interface{}, error) {
 func authUpload(pfc *PFContext) (interface{}, error) {
 	c := pfc.C
 
-	if uploadURL, err := blobstore.UploadURL(c, "/import", nil); err != nil {
+	// Uploads are handed to the importer for their format
+	uploadPath := "/import"
+	if pfc.R.FormValue("format") == "json" {
+		uploadPath = "/importJSON"
+	}
+
+	if uploadURL, err := blobstore.UploadURL(c, uploadPath, nil); err != nil {
 		return nil, err
 	} else {
 		return map[string]string { "uploadUrl": uploadURL.String() }, nil
@@ -704,3 +2011,66 @@ func removeTag(pfc *PFContext) (interface{}, error) {
 
 	return storage.NewUserSubscriptions(pfc.C, pfc.UserID)
 }
+
+func discover(pfc *PFContext) (interface{}, error) {
+	feeds, err := storage.SearchFeeds(pfc.C, pfc.R.FormValue("q"))
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]map[string]string, len(feeds))
+	for i, feed := range feeds {
+		candidates[i] = map[string]string {
+			"url": feed.URL,
+			"title": feed.Title,
+			"link": feed.Link,
+		}
+	}
+
+	return map[string]interface{} {
+		"feeds": candidates,
+	}, nil
+}
+
+func validateFeed(pfc *PFContext) (interface{}, error) {
+	feedURL := pfc.R.FormValue("url")
+
+	if feedURL == "" {
+		return nil, NewReadableError(_l("Missing URL"), nil)
+	} else if _, err := url.ParseRequestURI(feedURL); err != nil {
+		return nil, NewReadableError(_l("URL is not valid"), &err)
+	} else if !isFetchableURL(feedURL) {
+		return nil, NewReadableErrorWithCode(_l("URL is not valid"), http.StatusBadRequest, nil)
+	}
+
+	result := map[string]interface{} {
+		"valid": false,
+	}
+
+	// Nothing is queued or written here - the feed is only
+	// downloaded and parsed
+	client := createGuardedHttpClient(pfc.C, validateFetchDeadline)
+	if response, err := client.Get(feedURL); err != nil {
+		result["error"] = _l("An error occurred while downloading the feed")
+	} else {
+		defer response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			pfc.C.Infof("Feed to validate (%s) responded with %s", feedURL, response.Status)
+			result["error"] = _l("An error occurred while downloading the feed")
+		} else if parsed, err := rss.UnmarshalStream(feedURL, io.LimitReader(response.Body, rss.MaxFeedSize)); err != nil {
+			result["error"] = _l("Error reading RSS content: %s", err)
+		} else {
+			result["valid"] = true
+			result["format"] = parsed.Format
+			result["title"] = parsed.Feed.Title
+			result["entryCount"] = len(parsed.Feed.Entries)
+			// Feeds with a hub can push updates, rather than wait
+			// for the next scheduled fetch
+			result["hasHub"] = parsed.Feed.HubURL != ""
+			result["hubURL"] = parsed.Feed.HubURL
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,44 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+
+package gofr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateTitle(t *testing.T) {
+	if err := validateTitle(strings.Repeat("é", maxTitleLength), "too long"); err != nil {
+		t.Errorf("unexpected error at %d runes: %s", maxTitleLength, err)
+	}
+
+	err := validateTitle(strings.Repeat("é", maxTitleLength + 1), "too long")
+	if readable, ok := err.(ReadableError); !ok || readable.Error() != "too long" {
+		t.Errorf("expected the given message at %d runes, got %v", maxTitleLength + 1, err)
+	}
+
+	if err := validateTitle("Tab\there", "too long"); err == nil {
+		t.Errorf("expected an error for control characters")
+	}
+}
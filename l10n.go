@@ -25,9 +25,222 @@ package gofr
 
 import (
 	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// Locale of the messages as written
+const defaultLocale = "en"
+
+// messageCatalogs maps locales (lowercase language tags) to 
+// translations of messages, keyed by the untranslated message. 
+// Messages without a translation are shown untranslated
+var messageCatalogs = map[string]map[string]string {
+	"fr": {
+		"Please sign in": "Veuillez vous connecter",
+		"An unexpected error has occurred": "Une erreur inattendue s'est produite",
+		"Subscription not found": "Abonnement introuvable",
+		"Folder not found": "Dossier introuvable",
+		"Article not found": "Article introuvable",
+		"Missing URL": "URL manquante",
+		"URL is not valid": "URL non valide",
+		"An error occurred while downloading the feed": "Une erreur s'est produite lors du téléchargement du flux",
+		"The feed appears to be malformed": "Le flux semble mal formé",
+		"Error updating subscription": "Erreur lors de la mise à jour de l'abonnement",
+		"Name is too long": "Le nom est trop long",
+		"Folder name is too long": "Le nom du dossier est trop long",
+		"Name contains invalid characters": "Le nom contient des caractères non valides",
+		"Mute word is too long": "Le mot masqué est trop long",
+		"Too many mute words": "Trop de mots masqués",
+		"(%d folders created, %d merged)": "(%d dossiers créés, %d fusionnés)",
+		"The password must be at least %d characters long": "Le mot de passe doit comporter au moins %d caractères",
+		"Error exporting articles": "Erreur lors de l'exportation des articles",
+		"Tagged articles can't be filtered by author": "Les articles étiquetés ne peuvent pas être filtrés par auteur",
+		"Invalid value for continue": "Valeur non valide pour continue",
+	},
+	"es": {
+		"Please sign in": "Inicie sesión",
+		"An unexpected error has occurred": "Se ha producido un error inesperado",
+		"Subscription not found": "Suscripción no encontrada",
+		"Folder not found": "Carpeta no encontrada",
+		"Article not found": "Artículo no encontrado",
+		"Missing URL": "Falta la URL",
+		"URL is not valid": "La URL no es válida",
+		"An error occurred while downloading the feed": "Se ha producido un error al descargar el feed",
+		"The feed appears to be malformed": "El feed parece estar mal formado",
+		"Error updating subscription": "Error al actualizar la suscripción",
+		"Name is too long": "El nombre es demasiado largo",
+		"Folder name is too long": "El nombre de la carpeta es demasiado largo",
+		"Name contains invalid characters": "El nombre contiene caracteres no válidos",
+		"Mute word is too long": "La palabra silenciada es demasiado larga",
+		"Too many mute words": "Demasiadas palabras silenciadas",
+		"(%d folders created, %d merged)": "(%d carpetas creadas, %d combinadas)",
+		"The password must be at least %d characters long": "La contraseña debe tener al menos %d caracteres",
+		"Error exporting articles": "Error al exportar los artículos",
+		"Tagged articles can't be filtered by author": "Los artículos etiquetados no se pueden filtrar por autor",
+		"Invalid value for continue": "Valor no válido para continue",
+	},
+}
+
+// _l formats a message in the default locale. Messages are translated
+// when they're sent to the client (see localize)
 func _l(format string, v ...interface {}) string {
-	// FIXME
 	return fmt.Sprintf(format, v...)
 }
+
+// localeFallbacks returns the locales to try for a language tag, most
+// specific first (e.g. "pt-br", then "pt")
+func localeFallbacks(tag string) []string {
+	tag = strings.ToLower(strings.Replace(strings.TrimSpace(tag), "_", "-", -1))
+	if tag == "" {
+		return nil
+	}
+
+	fallbacks := []string { tag }
+	for i := strings.LastIndex(tag, "-"); i > 0; i = strings.LastIndex(tag, "-") {
+		tag = tag[:i]
+		fallbacks = append(fallbacks, tag)
+	}
+
+	return fallbacks
+}
+
+type weightedLanguage struct {
+	tag string
+	quality float64
+}
+
+type languagesByQuality []weightedLanguage
+
+func (s languagesByQuality) Len() int {
+	return len(s)
+}
+
+func (s languagesByQuality) Swap(i int, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s languagesByQuality) Less(i int, j int) bool {
+	return s[i].quality > s[j].quality
+}
+
+// requestLocale picks the locale of the response from the languages
+// the client accepts, in order of preference. If none has a message
+// catalog, it's empty (the default locale)
+func requestLocale(r *http.Request) string {
+	var languages languagesByQuality
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		params := strings.Split(part, ";")
+		language := weightedLanguage { tag: strings.TrimSpace(params[0]), quality: 1 }
+
+		for _, param := range params[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				if quality, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					language.quality = quality
+				}
+			}
+		}
+
+		if language.tag != "" && language.tag != "*" && language.quality > 0 {
+			languages = append(languages, language)
+		}
+	}
+
+	sort.Stable(languages)
+
+	for _, language := range languages {
+		for _, locale := range localeFallbacks(language.tag) {
+			if locale == defaultLocale {
+				return ""
+			} else if _, ok := messageCatalogs[locale]; ok {
+				return locale
+			}
+		}
+	}
+
+	return ""
+}
+
+// localize translates a message into the locale, or returns it as-is
+// if there's no translation. Since messages are formatted before
+// they're translated, messages with parameters (%d and %s only) are 
+// matched against the catalogs' formats, and the values carried over
+func localize(locale string, message string) string {
+	for _, candidate := range localeFallbacks(locale) {
+		if translation, ok := messageCatalogs[candidate][message]; ok {
+			return translation
+		}
+
+		for _, format := range catalogFormats[candidate] {
+			if matches := format.pattern.FindStringSubmatch(message); matches != nil {
+				return fillFormat(format.translation, matches[1:])
+			}
+		}
+	}
+
+	return message
+}
+
+// catalogFormat matches messages formatted from a catalog message 
+// with parameters
+type catalogFormat struct {
+	pattern *regexp.Regexp
+	translation string
+}
+
+var formatVerbScanner = regexp.MustCompile(`%[ds]`)
+
+// catalogFormats holds, by locale, the catalog messages that have 
+// parameters, compiled once
+var catalogFormats = compileCatalogFormats()
+
+func compileCatalogFormats() map[string][]catalogFormat {
+	formats := make(map[string][]catalogFormat)
+	for locale, catalog := range messageCatalogs {
+		for message, translation := range catalog {
+			if formatVerbScanner.MatchString(message) {
+				formats[locale] = append(formats[locale], catalogFormat {
+					pattern: formatPattern(message),
+					translation: translation,
+				})
+			}
+		}
+	}
+
+	return formats
+}
+
+// formatPattern returns an expression matching messages formatted 
+// from format, capturing the values as text
+func formatPattern(format string) *regexp.Regexp {
+	pattern := "^"
+	start := 0
+	for _, verb := range formatVerbScanner.FindAllStringIndex(format, -1) {
+		pattern += regexp.QuoteMeta(format[start:verb[0]])
+		if format[verb[1] - 1] == 'd' {
+			pattern += "(-?[0-9]+)"
+		} else {
+			pattern += "(.*?)"
+		}
+		start = verb[1]
+	}
+	pattern += regexp.QuoteMeta(format[start:]) + "$"
+
+	return regexp.MustCompile(pattern)
+}
+
+// fillFormat replaces the verbs of a translated format with values, 
+// in order
+func fillFormat(format string, values []string) string {
+	i := 0
+	return formatVerbScanner.ReplaceAllStringFunc(format, func(verb string) string {
+		if i >= len(values) {
+			return verb
+		}
+		i++
+		return values[i - 1]
+	})
+}
@@ -0,0 +1,98 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+
+package gofr
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestLocaleFallbacks(t *testing.T) {
+	if fallbacks := localeFallbacks(" pt_BR "); !reflect.DeepEqual(fallbacks, []string { "pt-br", "pt" }) {
+		t.Errorf("unexpected fallbacks %v", fallbacks)
+	}
+	if fallbacks := localeFallbacks(""); fallbacks != nil {
+		t.Errorf("expected no fallbacks, got %v", fallbacks)
+	}
+}
+
+func TestRequestLocale(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		locale string
+	}{
+		{ "", "" },
+		{ "fr-CA,fr;q=0.9", "fr" },
+		{ "de, es;q=0.5, fr;q=0.8", "fr" },
+		{ "en-US,fr;q=0.5", "" },
+		{ "fr;q=0, es", "es" },
+		{ "*", "" },
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Language", test.acceptLanguage)
+
+		if locale := requestLocale(r); locale != test.locale {
+			t.Errorf("%q: expected %q, got %q", test.acceptLanguage, test.locale, locale)
+		}
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	tests := []struct {
+		locale string
+		message string
+		expected string
+	}{
+		{ "", _l("Folder not found"), "Folder not found" },
+		{ "fr", _l("Folder not found"), "Dossier introuvable" },
+		{ "es-mx", _l("Folder not found"), "Carpeta no encontrada" },
+		{ "fr", "Not in the catalog", "Not in the catalog" },
+		{ "fr", _l("The password must be at least %d characters long", 8), "Le mot de passe doit comporter au moins 8 caractères" },
+		{ "es", _l("(%d folders created, %d merged)", 3, 12), "(3 carpetas creadas, 12 combinadas)" },
+		// Only numbers stand in for %d
+		{ "fr", "The password must be at least eight characters long", "The password must be at least eight characters long" },
+	}
+
+	for _, test := range tests {
+		if localized := localize(test.locale, test.message); localized != test.expected {
+			t.Errorf("%s/%q: expected %q, got %q", test.locale, test.message, test.expected, localized)
+		}
+	}
+}
+
+// Every message in a catalog has to be translated into every other
+func TestMessageCatalogsMatch(t *testing.T) {
+	for locale, catalog := range messageCatalogs {
+		for otherLocale, otherCatalog := range messageCatalogs {
+			for message := range catalog {
+				if _, ok := otherCatalog[message]; !ok {
+					t.Errorf("%q is translated for %s, but not for %s", message, locale, otherLocale)
+				}
+			}
+		}
+	}
+}
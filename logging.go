@@ -0,0 +1,94 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+ 
+package gofr
+
+import (
+	"appengine"
+)
+
+// loggingContext prefixes each message logged through it with what the
+// message concerns, e.g. "[task=import user=1234 feed=http://…]", so 
+// that the lines belonging to a single refresh or import can be picked
+// out. Since it's a Context in its own right, it can be handed to the 
+// storage package, which then logs with the same prefix
+type loggingContext struct {
+	appengine.Context
+	fields string
+}
+
+// withLogFields returns a context that prefixes log messages with the 
+// key/value pairs (in addition to any the context already has). Pairs
+// with empty values are skipped
+func withLogFields(c appengine.Context, keyValues ...string) appengine.Context {
+	fields := ""
+	if parent, ok := c.(loggingContext); ok {
+		c = parent.Context
+		fields = parent.fields
+	}
+
+	for i := 0; i + 1 < len(keyValues); i += 2 {
+		if keyValues[i + 1] == "" {
+			continue
+		}
+		if fields != "" {
+			fields += " "
+		}
+		fields += keyValues[i] + "=" + keyValues[i + 1]
+	}
+
+	if fields == "" {
+		return c
+	}
+
+	return loggingContext {
+		Context: c,
+		fields: fields,
+	}
+}
+
+// The fields are passed as an argument, rather than as part of the 
+// format, since URLs may contain '%'
+func (c loggingContext)withFields(args []interface{}) []interface{} {
+	return append([]interface{} { c.fields }, args...)
+}
+
+func (c loggingContext)Debugf(format string, args ...interface{}) {
+	c.Context.Debugf("[%s] " + format, c.withFields(args)...)
+}
+
+func (c loggingContext)Infof(format string, args ...interface{}) {
+	c.Context.Infof("[%s] " + format, c.withFields(args)...)
+}
+
+func (c loggingContext)Warningf(format string, args ...interface{}) {
+	c.Context.Warningf("[%s] " + format, c.withFields(args)...)
+}
+
+func (c loggingContext)Errorf(format string, args ...interface{}) {
+	c.Context.Errorf("[%s] " + format, c.withFields(args)...)
+}
+
+func (c loggingContext)Criticalf(format string, args ...interface{}) {
+	c.Context.Criticalf("[%s] " + format, c.withFields(args)...)
+}
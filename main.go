@@ -38,6 +38,9 @@ func init() {
 	registerTasks()
 	registerCron()
 	registerWeb()
+	registerAdmin()
+	registerFever()
+	registerPublish()
 }
 
 type PFContext struct {
@@ -48,6 +51,8 @@ type PFContext struct {
 	UserID storage.UserID
 	User *storage.User
 	LoginURL string
+	// Locale of messages sent to the client; empty for the default
+	Locale string
 }
 
 func Run(w http.ResponseWriter, r *http.Request) {
@@ -66,6 +71,7 @@ func Run(w http.ResponseWriter, r *http.Request) {
 		C: c,
 		W: w,
 		LoginURL: loginURL,
+		Locale: requestLocale(r),
 	}
 
 	routeRequest(&pfc)
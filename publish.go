@@ -0,0 +1,210 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+ 
+
+package gofr
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"net"
+	"net/http"
+	"net/url"
+	"rss"
+	"storage"
+	"strings"
+	"time"
+)
+
+// Folders republished as feeds, for other readers to poll. Since feed
+// readers can't sign in, access is granted by a per-user token, set 
+// with /setFeedToken. RSS 2.0 is served unless Atom is asked for, with
+// the ".atom" extension or the Accept header
+
+func registerPublish() {
+	RegisterAnonHTMLRoute("/feed", publishedFeed)
+	RegisterAnonHTMLRoute("/feed.rss", publishedFeed)
+	RegisterAnonHTMLRoute("/feed.atom", publishedFeed)
+
+	RegisterJSONRoute("/setFeedToken", setFeedToken)
+}
+
+// setFeedToken issues a new feed token, replacing any previous one 
+// (so feeds using it stop working). With "revoke", the token is 
+// removed and folders are no longer published
+func setFeedToken(pfc *PFContext) (interface{}, error) {
+	token := ""
+	if pfc.R.PostFormValue("revoke") != "true" {
+		tokenBytes := make([]byte, 16)
+		if _, err := rand.Read(tokenBytes); err != nil {
+			return nil, err
+		}
+		token = hex.EncodeToString(tokenBytes)
+	}
+
+	if err := storage.SetFeedToken(pfc.C, pfc.UserID, token); err != nil {
+		return nil, NewReadableError(_l("Error updating settings"), &err)
+	}
+
+	return map[string]interface{} {
+		"token": token,
+	}, nil
+}
+
+// wantsAtom determines the format of a published feed, from the 
+// extension of the path, or failing that, the Accept header
+func wantsAtom(r *http.Request) bool {
+	if strings.HasSuffix(r.URL.Path, ".atom") {
+		return true
+	} else if strings.HasSuffix(r.URL.Path, ".rss") {
+		return false
+	}
+
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/atom+xml") && 
+		!strings.Contains(accept, "application/rss+xml")
+}
+
+// publishedEntryID returns a tag URI identifying a published article.
+// Article IDs are only unique within their source feed, so the feed 
+// is part of it
+func publishedEntryID(host string, article storage.Article) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	return "tag:" + host + ",2013:" + url.QueryEscape(article.Source) + "/" + url.QueryEscape(article.ID)
+}
+
+func publishedFeed(pfc *PFContext) {
+	c := pfc.C
+	r := pfc.R
+	w := pfc.W
+
+	user, err := storage.UserByFeedToken(c, r.FormValue("token"))
+	if err != nil {
+		c.Errorf("Error authenticating feed request: %s", err)
+		http.Error(w, localize(pfc.Locale, _l("Unexpected error")), http.StatusInternalServerError)
+		return
+	} else if user == nil {
+		http.Error(w, localize(pfc.Locale, _l("Feed not found")), http.StatusNotFound)
+		return
+	}
+
+	c = withLogFields(c, "user", user.ID)
+	folderRef := storage.FolderRef {
+		UserID: storage.UserID(user.ID),
+		FolderID: r.FormValue("folder"),
+	}
+
+	var folder *storage.Folder
+	if folderRef.FolderID != "" {
+		if folder, err = storage.LoadFolder(c, folderRef); err != nil {
+			c.Warningf("Error loading folder: %s", err)
+		}
+	}
+	if folder == nil {
+		http.Error(w, localize(pfc.Locale, _l("Feed not found")), http.StatusNotFound)
+		return
+	}
+
+	filter := storage.ArticleFilter {
+		ArticleScope: storage.ArticleScope {
+			FolderRef: folderRef,
+		},
+	}
+
+	page, err := storage.NewArticlePage(c, filter, "")
+	if err != nil {
+		c.Errorf("Error reading articles: %s", err)
+		http.Error(w, localize(pfc.Locale, _l("Error reading articles")), http.StatusInternalServerError)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+
+	feed := rss.Feed {
+		URL: scheme + "://" + r.Host + r.URL.RequestURI(),
+		Title: folder.Title,
+		Description: _l("Articles in %s", folder.Title),
+		WWWURL: scheme + "://" + r.Host + "/reader",
+		Entries: make([]*rss.Entry, 0, len(page.Articles)),
+	}
+
+	for _, article := range page.Articles {
+		if article.Fetched.After(feed.Updated) {
+			feed.Updated = article.Fetched
+		}
+		if article.Details == nil {
+			continue
+		}
+
+		entry := &rss.Entry {
+			GUID: publishedEntryID(r.Host, article),
+			Title: article.Details.Title,
+			WWWURL: article.Details.Link,
+			Author: article.Details.Author,
+			Content: article.Details.Content,
+			Published: article.Published,
+			Updated: article.Details.Updated,
+			CommentsURL: article.Details.CommentsURL,
+		}
+		for _, media := range article.Media {
+			entry.Media = append(entry.Media, rss.Media {
+				URL: media.URL,
+				Type: media.Type,
+			})
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	if feed.Updated.IsZero() {
+		feed.Updated = time.Now()
+	}
+
+	var output []byte
+	contentType := "application/rss+xml; charset=utf-8"
+	if wantsAtom(r) {
+		output, err = feed.MarshalAtom()
+		contentType = "application/atom+xml; charset=utf-8"
+	} else {
+		output, err = feed.MarshalRSS2()
+	}
+
+	if err != nil {
+		c.Errorf("Error generating feed: %s", err)
+		http.Error(w, localize(pfc.Locale, _l("Error generating feed")), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-type", contentType)
+	w.Header().Set("Vary", "Accept")
+
+	w.Write([]byte(xml.Header))
+	w.Write(output)
+}
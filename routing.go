@@ -30,6 +30,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"storage"
+	"strings"
 )
 
 type requestHandler interface {
@@ -54,6 +55,7 @@ type htmlRequestHandler struct {
 type jsonRequestHandler struct {
 	RouteHandler JSONRouteHandler
 	LoginRequired bool
+	AdminRequired bool
 	NoFormPreparse bool
 }
 
@@ -103,14 +105,24 @@ func (handler jsonRequestHandler)handleRequest(pfc *PFContext) {
 
 	aeUser := user.Current(pfc.C)
 	if handler.LoginRequired && aeUser == nil {
-		jsonObj := map[string]string { "errorMessage": _l("Please sign in") }
+		jsonObj := map[string]string { "errorMessage": localize(pfc.Locale, _l("Please sign in")) }
 		bf, _ := json.Marshal(jsonObj)
 
 		w.Header().Set("Content-type", "application/json; charset=utf-8")
 		http.Error(w, string(bf), 401)
 		return
+	} else if handler.AdminRequired && !user.IsAdmin(c) {
+		jsonObj := map[string]string { "errorMessage": localize(pfc.Locale, _l("Administrator access required")) }
+		bf, _ := json.Marshal(jsonObj)
+
+		w.Header().Set("Content-type", "application/json; charset=utf-8")
+		http.Error(w, string(bf), http.StatusForbidden)
+		return
 	} else if aeUser != nil {
 		pfc.UserID = storage.UserID(aeUser.ID)
+		pfc.C = withLogFields(pfc.C, "user", aeUser.ID)
+		c = pfc.C
+
 		if !handler.NoFormPreparse {
 			if clientID := pfc.R.PostFormValue("client"); clientID != "" {
 				pfc.ChannelID = aeUser.ID + "," + clientID
@@ -152,7 +164,7 @@ func (handler jsonRequestHandler)handleRequest(pfc *PFContext) {
 			}
 		}
 
-		jsonObj := map[string]string { "errorMessage": message }
+		jsonObj := map[string]string { "errorMessage": localize(pfc.Locale, message) }
 		bf, _ := json.Marshal(jsonObj)
 
 		w.Header().Set("Content-type", "application/json; charset=utf-8")
@@ -161,11 +173,17 @@ func (handler jsonRequestHandler)handleRequest(pfc *PFContext) {
 }
 
 func (handler taskRequestHandler)handleRequest(pfc *PFContext) {
+	pfc.C = withLogFields(pfc.C, "task", strings.TrimPrefix(pfc.R.URL.Path, "/tasks/"))
+
 	if userID := pfc.R.PostFormValue("userID"); userID != "" {
 		pfc.UserID = storage.UserID(userID)
+		pfc.C = withLogFields(pfc.C, "user", userID)
 		if channelID := pfc.R.PostFormValue("channelID"); channelID != "" {
 			pfc.ChannelID = channelID
 		}
+		// Tasks have no Accept-Language; the locale of the request
+		// that started them is passed along instead
+		pfc.Locale = pfc.R.PostFormValue("locale")
 
 		if user, err := storage.UserByID(pfc.C, pfc.UserID); err != nil {
 			pfc.C.Errorf("Error loading user: %s", err)
@@ -183,6 +201,7 @@ func (handler taskRequestHandler)handleRequest(pfc *PFContext) {
 		http.Error(pfc.W, err.Error(), http.StatusInternalServerError)
 		response = map[string] string { "error": err.Error() }
 	} else {
+		taskMessage.Message = localize(pfc.Locale, taskMessage.Message)
 		response = taskMessage
 	}
 
@@ -243,6 +262,19 @@ func RegisterJSONRouteSansPreparse(pattern string, handler JSONRouteHandler) {
 	routes = append(routes, route)
 }
 
+func RegisterAdminJSONRoute(pattern string, handler JSONRouteHandler) {
+	route := route {
+		Pattern: pattern,
+		Handler: jsonRequestHandler {
+			RouteHandler: handler,
+			LoginRequired: true,
+			AdminRequired: true,
+		},
+	}
+
+	routes = append(routes, route)
+}
+
 func RegisterAnonHTMLRoute(pattern string, handler HTMLRouteHandler) {
 	route := route {
 		Pattern: pattern,
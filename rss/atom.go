@@ -24,16 +24,24 @@
 package rss
 
 import (
+	"bytes"
 	"encoding/xml"
+	"io"
+	"regexp"
+	"sanitize"
 	"strings"
 	"time"
 )
 
 var supportedAtomTimeFormats = []string {
-	"2006-01-02T15:04:05Z07:00",
+	time.RFC3339,
 	"January 2, 2006",
 }
 
+var atomTimeFormat = timeFormat {
+	Layouts: supportedAtomTimeFormats,
+}
+
 type atomFeed struct {
 	XMLName xml.Name `xml:"feed"`
 	Id string `xml:"id"`
@@ -67,20 +75,93 @@ type atomEntry struct {
 	Author atomAuthor `xml:"author"`
 }
 
+// atomText is a text construct (RFC 4287, section 3.1). For "text" and
+// "html", the content is character data (HTML being escaped). For 
+// "xhtml", it's inline markup wrapped in a single <div>
 type atomText struct {
 	Type string `xml:"type,attr"`
 	Content string `xml:",chardata"`
+	InnerXML string `xml:",innerxml"`
+}
+
+// HTML returns the content of the construct; for "xhtml", that's the
+// markup within the wrapping <div>
+func (text atomText) HTML() string {
+	if text.Type != "xhtml" {
+		return text.Content
+	}
+
+	return xhtmlDivContent(text.InnerXML)
+}
+
+// xhtmlDivContent returns the markup inside the <div> wrapping an 
+// xhtml text construct. If the <div> is namespace-prefixed (e.g. 
+// <xhtml:div>), the prefix is stripped from the markup, since browsers
+// won't recognize <xhtml:p> as a paragraph. Content that isn't wrapped
+// (contrary to the spec) is returned whole
+func xhtmlDivContent(innerXML string) string {
+	decoder := xml.NewDecoder(strings.NewReader(innerXML))
+	decoder.Strict = false
+
+	depth := 0
+	var start int64 = -1
+	prefix := ""
+	for {
+		offset := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return strings.TrimSpace(innerXML)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				if t.Name.Local != "div" {
+					return strings.TrimSpace(innerXML)
+				}
+				start = decoder.InputOffset()
+				prefix = t.Name.Space
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+			if depth == 0 && start >= 0 {
+				return strings.TrimSpace(withoutXMLPrefix(innerXML[start:offset], prefix))
+			}
+		case xml.CharData:
+			if depth == 0 && len(bytes.TrimSpace(t)) > 0 {
+				return strings.TrimSpace(innerXML)
+			}
+		}
+	}
+
+	return strings.TrimSpace(innerXML)
+}
+
+// withoutXMLPrefix removes a namespace prefix from the tags in a 
+// fragment of markup, along with its declarations
+func withoutXMLPrefix(markup string, prefix string) string {
+	if prefix == "" {
+		return markup
+	}
+
+	quoted := regexp.QuoteMeta(prefix)
+	markup = regexp.MustCompile(`(</?)` + quoted + `:`).ReplaceAllString(markup, "$1")
+	return regexp.MustCompile(`\s+xmlns:` + quoted + `\s*=\s*(?:"[^"]*"|'[^']*')`).ReplaceAllString(markup, "")
 }
 
 func (nativeFeed *atomFeed) Marshal() (feed *Feed, err error) {
 	updated := time.Time {}
 	if nativeFeed.Updated != "" {
-		updated, err = parseTime(supportedAtomTimeFormats, nativeFeed.Updated)
+		updated, err = atomTimeFormat.parse(nativeFeed.Updated)
 	}
 
 	hubURL := ""
 	linkUrl := ""
 	topic := ""
+	nextURL := ""
 
 	for _, link := range nativeFeed.Link {
 		rels := strings.Split(link.Rel, " ")
@@ -94,18 +175,23 @@ func (nativeFeed *atomFeed) Marshal() (feed *Feed, err error) {
 			} else if rel == "hub" {
 				hubURL = link.Href
 				break
+			} else if rel == "next" {
+				// Paginated feed (RFC 5005)
+				nextURL = link.Href
+				break
 			}
 		}
 	}
 
 	feed = &Feed {
-		Title: nativeFeed.Title,
+		Title: normalizeTitle(nativeFeed.Title),
 		Description: nativeFeed.Description,
 		Updated: updated,
 		WWWURL: linkUrl,
 		Format: "Atom",
 		HubURL: hubURL,
 		Topic: topic,
+		NextURL: strings.TrimSpace(nextURL),
 	}
 
 	if nativeFeed.Entry != nil {
@@ -126,32 +212,47 @@ func (nativeFeed *atomFeed) Marshal() (feed *Feed, err error) {
 func (nativeEntry *atomEntry) Marshal() (entry *Entry, err error) {
 	guid := nativeEntry.Id
 	
-	content := nativeEntry.Content.Content
-	if content == "" && nativeEntry.Summary.Content != "" {
-		content = nativeEntry.Summary.Content
+	content := nativeEntry.Content.HTML()
+	if content == "" {
+		content = nativeEntry.Summary.HTML()
 	}
 
+	title := nativeEntry.EntryTitle.HTML()
+	if nativeEntry.EntryTitle.Type == "xhtml" {
+		title = sanitize.StripTags(title)
+	}
+
+	var warnings []string
+
 	published := time.Time {}
+	publishedRaw := nativeEntry.Published
 	if nativeEntry.Published != "" {
-		published, err = parseTime(supportedAtomTimeFormats, nativeEntry.Published)
+		if published, err = atomTimeFormat.parse(nativeEntry.Published); err != nil {
+			warnings = append(warnings, err.Error())
+		}
 	}
 
 	updated := published
 	if nativeEntry.Updated != "" {
-		updated, err = parseTime(supportedAtomTimeFormats, nativeEntry.Updated)
+		if updated, err = atomTimeFormat.parse(nativeEntry.Updated); err != nil {
+			warnings = append(warnings, err.Error())
+		}
 		if published.IsZero() {
 			published = updated // e.g. xkcd
+			publishedRaw = nativeEntry.Updated
 		}
 	}
 
 	entry = &Entry {
 		GUID: guid,
 		Author: nativeEntry.Author.Name,
-		Title: nativeEntry.EntryTitle.Content,
+		Title: normalizeTitle(title),
 		Content: content,
 		Published: published,
+		PublishedRaw: strings.TrimSpace(publishedRaw),
 		Updated: updated,
 		Media: make([]Media, 0, 20),
+		Warnings: warnings,
 	}
 
 	// Links and enclosures
@@ -165,6 +266,10 @@ func (nativeEntry *atomEntry) Marshal() (entry *Entry, err error) {
 				Title: link.Title,
 			}
 
+			if media.Type == "" {
+				media.Type = inferMediaType(media.URL)
+			}
+
 			entry.Media = append(entry.Media, media)
 		}
 	}
@@ -0,0 +1,55 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+
+package rss
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestAtomTextHTML(t *testing.T) {
+	tests := []struct {
+		document string
+		expected string
+	}{
+		{ `<title>Fish &amp; Chips</title>`, `Fish & Chips` },
+		{ `<title type="text">Plain</title>`, `Plain` },
+		{ `<title type="html">&lt;b&gt;Bold&lt;/b&gt;</title>`, `<b>Bold</b>` },
+		{ `<title type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"> <p>Para</p> </div></title>`, `<p>Para</p>` },
+		{ `<title type="xhtml" xmlns:xhtml="http://www.w3.org/1999/xhtml"><xhtml:div><xhtml:p>Para</xhtml:p><xhtml:br/></xhtml:div></title>`, `<p>Para</p><br/>` },
+		{ `<title type="xhtml"><xhtml:div xmlns:xhtml="http://www.w3.org/1999/xhtml"><xhtml:p class="x">Para</xhtml:p></xhtml:div></title>`, `<p class="x">Para</p>` },
+		// Not wrapped in a <div>, contrary to the spec
+		{ `<title type="xhtml"><p>Para</p></title>`, `<p>Para</p>` },
+	}
+
+	for _, test := range tests {
+		var text atomText
+		if err := xml.Unmarshal([]byte(test.document), &text); err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.document, err)
+		}
+		if html := text.HTML(); html != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.document, test.expected, html)
+		}
+	}
+}
@@ -30,12 +30,17 @@ import (
 	"crypto/md5"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"html"
 	"io"
-	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
 	"regexp"
 	"sanitize"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -51,6 +56,10 @@ type (
 		Entries []*Entry
 		HubURL string
 		Topic string
+		ContentHash []byte
+		ContentLength int
+		Encoding string
+		NextURL string
 	}
 	Entry struct {
 		GUID string
@@ -59,8 +68,16 @@ type (
 		WWWURL string
 		Content string
 		Published time.Time
+		// Publication date as it appears in the feed, whether or not 
+		// it could be parsed
+		PublishedRaw string
 		Updated time.Time
 		Media []Media
+		Warnings []string
+		CommentsURL string
+		CommentCount int
+		SourceTitle string
+		SourceURL string
 	}
 	Media struct {
 		URL string
@@ -70,12 +87,47 @@ type (
 	SortableTimes []time.Time
 )
 
+// MaxFeedSize is the largest number of bytes read from a feed document.
+// Longer documents are parsed as though they'd been truncated
+var MaxFeedSize int64 = 8 << 20
+
 var (
+	mediaTypesByExtension = map[string]string {
+		".mp3": "audio/mpeg",
+		".m4a": "audio/mp4",
+		".aac": "audio/aac",
+		".ogg": "audio/ogg",
+		".oga": "audio/ogg",
+		".opus": "audio/opus",
+		".wav": "audio/wav",
+		".flac": "audio/flac",
+		".mp4": "video/mp4",
+		".m4v": "video/mp4",
+		".mov": "video/quicktime",
+		".webm": "video/webm",
+		".ogv": "video/ogg",
+		".jpg": "image/jpeg",
+		".jpeg": "image/jpeg",
+		".png": "image/png",
+		".gif": "image/gif",
+		".webp": "image/webp",
+		".svg": "image/svg+xml",
+		".pdf": "application/pdf",
+	}
 	badEntityScanner = regexp.MustCompile(`(&)(?:[^#a-zA-Z]|#[^0-9]|#[0-9]+[^0-9;]|[a-zA-Z]+[^a-zA-Z;])`)
+	xmlDeclarationScanner = regexp.MustCompile(`^(?s)\x{FEFF}?\s*<\?xml\s.*?\?>`)
 )
 
 const (
 	maxSummaryLength = 400
+	// Documents shorter than this are taken at their word when they 
+	// have no entries
+	minLenientContentLength = 512
+	// Average adult reading speed, for reading time estimates
+	wordsPerMinute = 200
+
+	minUpdateInterval = 30 * time.Minute
+	maxUpdateInterval = 24 * time.Hour
 )
 
 func (s SortableTimes) Len() int {
@@ -91,9 +143,9 @@ func (s SortableTimes) Less(i int, j int) bool {
 }
 
 func (feed *Feed)DurationBetweenUpdates() time.Duration {
-	if feed.HourlyUpdateFrequency != 0 {
+	if hours := float64(feed.HourlyUpdateFrequency); hours > 0 && !math.IsInf(hours, 0) {
 		// Set explicitly
-		return time.Duration(feed.HourlyUpdateFrequency) * time.Hour
+		return clampUpdateInterval(time.Duration(hours * float64(time.Hour)))
 	}
 
 	// Compute frequency by analyzing entries in the feed
@@ -116,17 +168,17 @@ func (feed *Feed)DurationBetweenUpdates() time.Duration {
 		durationBetweenUpdates = time.Duration(deltaSum / float64(len(pubDates) - 1)) * time.Hour
 	}
 
-	// Clamp the frequency
-	minFrequency := time.Duration(30) * time.Minute // 30 minutes
-	maxFrequency := time.Duration(24) * time.Hour   // 1 day
+	return clampUpdateInterval(durationBetweenUpdates)
+}
 
-	if durationBetweenUpdates > maxFrequency {
-		return maxFrequency
-	} else if durationBetweenUpdates < minFrequency {
-		return minFrequency
+func clampUpdateInterval(interval time.Duration) time.Duration {
+	if interval > maxUpdateInterval {
+		return maxUpdateInterval
+	} else if interval < minUpdateInterval {
+		return minUpdateInterval
 	}
 
-	return durationBetweenUpdates
+	return interval
 }
 
 func (entry *Entry)LatestModification() time.Time {
@@ -150,6 +202,22 @@ func (feed Feed)Digest() []byte {
 	return hasher.Sum(nil)
 }
 
+// CanonicalURL returns the address the feed declares for itself (its
+// self link), if it's usable and differs from the fetched URL
+func (feed Feed)CanonicalURL() string {
+	if feed.Topic == "" || feed.Topic == feed.URL {
+		return ""
+	}
+
+	if parsed, err := url.Parse(feed.Topic); err != nil || !parsed.IsAbs() {
+		return ""
+	} else if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return ""
+	}
+
+	return feed.Topic
+}
+
 func (entry *Entry)UniqueID() string {
 	if entry.GUID != "" {
 		return entry.GUID
@@ -162,6 +230,28 @@ func (entry *Entry)UniqueID() string {
 	return entry.WWWURL
 }
 
+// synthesizeGUID returns a stable identifier for entries that don't
+// provide one. Precedence is: explicit GUID, then the entry's link,
+// then a hash of the title and publication date
+func synthesizeGUID(guid string, link string, title string, published time.Time) string {
+	if guid != "" {
+		return guid
+	} else if link != "" {
+		return link
+	}
+
+	hasher := md5.New()
+
+	// Separated, so that the end of a title can't pass for a date
+	io.WriteString(hasher, title)
+	io.WriteString(hasher, "\x00")
+	if !published.IsZero() {
+		io.WriteString(hasher, published.UTC().Format(time.RFC3339))
+	}
+
+	return fmt.Sprintf("urn:md5:%x", hasher.Sum(nil))
+}
+
 func (entry Entry)Digest() []byte {
 	hasher := md5.New()
 
@@ -191,14 +281,118 @@ func (entry Entry)Digest() []byte {
 	return hasher.Sum(nil)
 }
 
+// ContentDigest hashes the text of the entry, ignoring markup and 
+// whitespace, so that only meaningful edits change it
+func (entry Entry)ContentDigest() []byte {
+	hasher := md5.New()
+
+	io.WriteString(hasher, normalizeTitle(DeHTMLize(entry.Title)))
+	io.WriteString(hasher, normalizeTitle(DeHTMLize(entry.Content)))
+
+	return hasher.Sum(nil)
+}
+
 type FeedMarshaler interface {
 	Marshal() (*Feed, error)
 }
 
+type entryMarshaler interface {
+	Marshal() (*Entry, error)
+}
+
 type GenericFeed struct {
 	XMLName xml.Name
 }
 
+// documentRoot returns the name of the document's root element. 
+// Whatever precedes it (comments, processing instructions, a DOCTYPE)
+// is skipped. If that isn't well-formed, the offset of the root 
+// element is returned as well, so that it can be dropped before the 
+// document is parsed
+func documentRoot(content []byte) (GenericFeed, int64, error) {
+	root, err := firstElement(content)
+	if err == nil {
+		return root, 0, nil
+	}
+
+	if offset := prologueLength(content); offset > 0 {
+		if root, prologueErr := firstElement(withoutPrologue(content, offset)); prologueErr == nil {
+			return root, offset, nil
+		}
+	}
+
+	return GenericFeed{}, 0, err
+}
+
+func firstElement(content []byte) (GenericFeed, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	decoder.CharsetReader = charset.NewReader
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return GenericFeed{}, err
+		}
+
+		if element, ok := token.(xml.StartElement); ok {
+			return GenericFeed { XMLName: element.Name }, nil
+		}
+	}
+}
+
+// prologueLength scans the raw document for the start of its root 
+// element, stepping over comments, processing instructions and 
+// directives without checking that they're well-formed. Returns 0 if
+// there's no root element to be found
+func prologueLength(content []byte) int64 {
+	for offset := 0; ; {
+		start := bytes.IndexByte(content[offset:], '<')
+		if start < 0 {
+			return 0
+		}
+		offset += start
+
+		var terminator string
+		rest := content[offset:]
+		if bytes.HasPrefix(rest, []byte("<!--")) {
+			terminator = "-->"
+		} else if bytes.HasPrefix(rest, []byte("<?")) {
+			terminator = "?>"
+		} else if bytes.HasPrefix(rest, []byte("<!")) {
+			terminator = ">"
+			// A DOCTYPE's internal subset may contain '>'
+			if subset := bytes.IndexByte(rest, '['); subset >= 0 && subset < bytes.IndexByte(rest, '>') {
+				subsetEnd := bytes.IndexByte(rest[subset:], ']')
+				if subsetEnd < 0 {
+					return 0
+				}
+				offset += subset + subsetEnd
+				rest = content[offset:]
+			}
+		} else {
+			return int64(offset)
+		}
+
+		end := bytes.Index(rest[1:], []byte(terminator))
+		if end < 0 {
+			return 0
+		}
+		offset += 1 + end + len(terminator)
+	}
+}
+
+// withoutPrologue returns a copy of the document with everything 
+// between the XML declaration and the root element removed
+func withoutPrologue(content []byte, rootOffset int64) []byte {
+	declaration := xmlDeclarationScanner.Find(content)
+
+	document := make([]byte, 0, len(declaration) + len(content) - int(rootOffset))
+	document = append(document, declaration...)
+	document = append(document, content[rootOffset:]...)
+
+	return document
+}
+
 func fixEntities(content []byte) (fixed bool, fixedContent []byte) {
 	buf := bytes.Buffer{}
 	start := 0
@@ -216,76 +410,259 @@ func fixEntities(content []byte) (fixed bool, fixedContent []byte) {
 	return
 }
 
-func UnmarshalStream(url string, reader io.Reader) (feed *Feed, err error) {
-	// Read the stream into memory (we'll need to parse it twice)
-	var contentReader *bytes.Reader
-	var content []byte
-	if content, err = ioutil.ReadAll(reader); err == nil {
-		contentReader = bytes.NewReader(content)
+// ContentHash returns the hash of a raw feed document, used to detect
+// feeds that haven't changed since they were last fetched
+func ContentHash(content []byte) []byte {
+	hash := md5.Sum(content)
+	return hash[:]
+}
 
-		genericFeed := GenericFeed{}
+// ParseResult describes the outcome of parsing a document. It's 
+// returned even when parsing fails, to help decide what to do next
+type ParseResult struct {
+	Feed *Feed
+	// Detected format - "RSS1", "RSS2" or "Atom"; empty if unknown
+	Format string
+	// Declared character encoding
+	Encoding string
+	// Set when the document is a web page, rather than a malformed
+	// feed, and so may contain links to the actual feed
+	IsHTML bool
+	// Set when the document ended abruptly. The feed contains the 
+	// entries that were complete
+	Truncated bool
+	// Set when the entries weren't where the format expects them, 
+	// and were collected from anywhere in the document instead
+	Lenient bool
+}
 
-		decoder := xml.NewDecoder(contentReader)
-		decoder.CharsetReader = charset.NewReader
+// isTruncationError returns true if decoding failed because the
+// document ended before its elements were closed
+func isTruncationError(err error) bool {
+	if err == io.ErrUnexpectedEOF {
+		return true
+	} else if syntaxErr, ok := err.(*xml.SyntaxError); ok {
+		return syntaxErr.Msg == "unexpected EOF"
+	}
 
-		// First pass - parse the feed as-is
-		if err = decoder.Decode(&genericFeed); err != nil {
-			// Error - check for invalid entities and correct as appropriate
-			if fixed, fixedContent := fixEntities(content); fixed {
-				// At least one replacement was made. Retry
-				contentReader = bytes.NewReader(fixedContent)
-				decoder = xml.NewDecoder(contentReader)
-				decoder.CharsetReader = charset.NewReader
+	return false
+}
 
-				// Try decoding again
-				err = decoder.Decode(&genericFeed)
-			}
+// salvageTruncatedFeed cuts a truncated document after its last 
+// complete item (or entry), then closes the elements still open at 
+// that point. Documents converted from other character sets can't be 
+// cut reliably, and aren't salvaged
+func salvageTruncatedFeed(content []byte) ([]byte, bool) {
+	converted := false
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		if label = strings.ToLower(label); label != "utf-8" && label != "utf8" {
+			converted = true
 		}
+		return charset.NewReader(label, input)
+	}
+
+	var open, openAtCut []xml.Name
+	cut := int64(-1)
 
+	for {
+		token, err := decoder.RawToken()
 		if err != nil {
-			return
+			break
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			open = append(open, element.Name)
+		case xml.EndElement:
+			if len(open) == 0 {
+				return nil, false
+			}
+			open = open[:len(open) - 1]
+
+			if element.Name.Local == "item" || element.Name.Local == "entry" {
+				cut = decoder.InputOffset()
+				openAtCut = append([]xml.Name{}, open...)
+			}
 		}
+	}
+
+	if cut < 0 || converted {
+		return nil, false
+	}
 
-		var xmlFeed FeedMarshaler
-		if genericFeed.XMLName.Space == "http://www.w3.org/1999/02/22-rdf-syntax-ns#" && genericFeed.XMLName.Local == "RDF" {
-			xmlFeed = &rss1Feed { }
-		} else if genericFeed.XMLName.Local == "rss" {
-			xmlFeed = &rss2Feed { }
-		} else if genericFeed.XMLName.Space == "http://www.w3.org/2005/Atom" && genericFeed.XMLName.Local == "feed" {
-			xmlFeed = &atomFeed { }
+	var buf bytes.Buffer
+	buf.Write(content[:cut])
+	for i := len(openAtCut) - 1; i >= 0; i-- {
+		if name := openAtCut[i]; name.Space != "" {
+			buf.WriteString("</" + name.Space + ":" + name.Local + ">")
 		} else {
-			err = errors.New("Unsupported type of feed (" +
-				genericFeed.XMLName.Space + ":" + genericFeed.XMLName.Local + ")")
-			return
+			buf.WriteString("</" + name.Local + ">")
+		}
+	}
+
+	return buf.Bytes(), true
+}
+
+// lenientEntries collects every item (or entry) element in the 
+// document, regardless of namespace or where it's nested, for feeds 
+// that wrap their entries unconventionally
+func lenientEntries(content []byte, newEntryMarshaler func() entryMarshaler) (entries []*Entry, err error) {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	decoder.CharsetReader = charset.NewReader
+	decoder.Strict = false
+
+	for {
+		token, tokenErr := decoder.Token()
+		if tokenErr != nil {
+			break
 		}
 
-		contentReader.Seek(0, 0)
+		start, ok := token.(xml.StartElement)
+		if !ok || (start.Name.Local != "item" && start.Name.Local != "entry") {
+			continue
+		}
 
-		decoder = xml.NewDecoder(contentReader)
-		decoder.CharsetReader = charset.NewReader
+		nativeEntry := newEntryMarshaler()
+		if decodeErr := decoder.DecodeElement(nativeEntry, &start); decodeErr != nil {
+			break
+		}
 
-		if err = decoder.Decode(xmlFeed); err == nil {
-			if feed, err = xmlFeed.Marshal(); err == nil {
-				feed.URL = url
-			}
+		entry, entryErr := nativeEntry.Marshal()
+		if entryErr != nil && err == nil {
+			err = entryErr
+		}
+		if entry != nil {
+			entries = append(entries, entry)
 		}
 	}
 
-	return
+	return entries, err
+}
+
+// isHTMLDocument sniffs the content to decide whether it's a web
+// page (which may link to a feed) rather than a broken feed
+func isHTMLDocument(content []byte) bool {
+	if strings.HasPrefix(http.DetectContentType(content), "text/html") {
+		return true
+	}
+
+	head := content
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+
+	return bytes.Contains(bytes.ToLower(head), []byte("<html"))
 }
 
-func parseTime(supportedFormats []string, timeSpec string) (time.Time, error) {
-	if timeSpec != "" {
-		for _, format := range supportedFormats {
-			if parsedTime, err := time.Parse(format, timeSpec); err == nil {
-				return parsedTime.UTC(), nil
+// Unmarshal parses a feed document that's already in memory. The 
+// content isn't copied, and must not be modified afterwards
+func Unmarshal(url string, content []byte) (*ParseResult, error) {
+	result := &ParseResult {
+		Encoding: "utf-8",
+	}
+
+	if int64(len(content)) > MaxFeedSize {
+		content = content[:MaxFeedSize]
+	}
+
+	// Determine the format from the root element, without parsing the
+	// rest of the document
+	genericFeed, rootOffset, err := documentRoot(content)
+	if err != nil {
+		result.IsHTML = isHTMLDocument(content)
+		return result, err
+	}
+
+	document := content
+	if rootOffset > 0 {
+		// Something before the root (e.g. a comment containing "--")
+		// won't parse, and only the declaration is worth keeping
+		document = withoutPrologue(content, rootOffset)
+	}
+
+	var newFeedMarshaler func() FeedMarshaler
+	var newEntryMarshaler func() entryMarshaler
+	if genericFeed.XMLName.Space == "http://www.w3.org/1999/02/22-rdf-syntax-ns#" && genericFeed.XMLName.Local == "RDF" {
+		newFeedMarshaler = func() FeedMarshaler { return &rss1Feed { } }
+		newEntryMarshaler = func() entryMarshaler { return &rss1Entry { } }
+		result.Format = "RSS1"
+	} else if genericFeed.XMLName.Local == "rss" {
+		newFeedMarshaler = func() FeedMarshaler { return &rss2Feed { } }
+		newEntryMarshaler = func() entryMarshaler { return &rss2Entry { } }
+		result.Format = "RSS2"
+	} else if genericFeed.XMLName.Space == "http://www.w3.org/2005/Atom" && genericFeed.XMLName.Local == "feed" {
+		newFeedMarshaler = func() FeedMarshaler { return &atomFeed { } }
+		newEntryMarshaler = func() entryMarshaler { return &atomEntry { } }
+		result.Format = "Atom"
+	} else {
+		// Web pages have an html root, well-formed or not
+		result.IsHTML = strings.ToLower(genericFeed.XMLName.Local) == "html"
+		return result, errors.New("Unsupported type of feed (" +
+			genericFeed.XMLName.Space + ":" + genericFeed.XMLName.Local + ")")
+	}
+
+	decode := func(document []byte) (FeedMarshaler, error) {
+		xmlFeed := newFeedMarshaler()
+
+		// Note the declared encoding, if the document has one
+		decoder := xml.NewDecoder(bytes.NewReader(document))
+		decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+			result.Encoding = strings.ToLower(label)
+			return charset.NewReader(label, input)
+		}
+
+		return xmlFeed, decoder.Decode(xmlFeed)
+	}
+
+	parsedContent := document
+	xmlFeed, err := decode(parsedContent)
+	if err != nil {
+		// Error - check for invalid entities and correct as appropriate
+		if fixed, fixedContent := fixEntities(document); fixed {
+			// At least one replacement was made. Retry
+			parsedContent = fixedContent
+			xmlFeed, err = decode(parsedContent)
+		}
+	}
+
+	if err != nil && isTruncationError(err) {
+		// The document ended abruptly (e.g. the connection was reset,
+		// or it's over the size limit). Keep whatever entries arrived 
+		// complete
+		if salvaged, ok := salvageTruncatedFeed(parsedContent); ok {
+			if xmlFeed, err = decode(salvaged); err == nil {
+				result.Truncated = true
+			}
+		}
+	}
+
+	if err != nil {
+		return result, err
+	}
+
+	feed, err := xmlFeed.Marshal()
+	if feed != nil && len(feed.Entries) == 0 && len(parsedContent) >= minLenientContentLength {
+		// Nothing where the format expects entries, but there's more
+		// to the document than an empty feed - look for them anywhere
+		if entries, entryErr := lenientEntries(parsedContent, newEntryMarshaler); len(entries) > 0 {
+			feed.Entries = entries
+			result.Lenient = true
+			if err == nil {
+				err = entryErr
 			}
 		}
+	}
 
-		return time.Time {}, errors.New("Unrecognized time format: " + timeSpec)
+	if feed != nil {
+		feed.URL = url
+		feed.ContentHash = ContentHash(content)
+		feed.ContentLength = len(content)
+		feed.Encoding = result.Encoding
 	}
 
-	return time.Time {}, nil
+	result.Feed = feed
+	return result, err
 }
 
 func substr(s string, pos int, length int) string {
@@ -299,6 +676,26 @@ func substr(s string, pos int, length int) string {
 }
 
 var extraSpaceStripper *regexp.Regexp = regexp.MustCompile(`\s\s+`)
+var whitespaceCollapser *regexp.Regexp = regexp.MustCompile(`\s+`)
+var blockEndScanner *regexp.Regexp = regexp.MustCompile(`(?i)</(?:p|div|li|h[1-6]|blockquote|pre|tr|dt|dd)\s*>`)
+
+// inferMediaType guesses the MIME type of an enclosure from its file 
+// extension, for feeds that omit the type attribute
+func inferMediaType(mediaURL string) string {
+	mediaPath := mediaURL
+	if parsedURL, err := url.Parse(mediaURL); err == nil {
+		mediaPath = parsedURL.Path
+	}
+
+	return mediaTypesByExtension[strings.ToLower(path.Ext(mediaPath))]
+}
+
+// normalizeTitle decodes any entities in a title, and trims and
+// collapses the whitespace within it
+func normalizeTitle(title string) string {
+	unescaped := html.UnescapeString(title)
+	return strings.TrimSpace(whitespaceCollapser.ReplaceAllString(unescaped, " "))
+}
 
 func DeHTMLize(str string) string {
 	// TODO: This process should be streamlined to do
@@ -309,6 +706,34 @@ func DeHTMLize(str string) string {
 	return extraSpaceStripper.ReplaceAllString(unescaped, "")
 }
 
+// PlainText renders HTML content as text, keeping one line per
+// paragraph (or other block element)
+func PlainText(content string) string {
+	// Line breaks in the source are insignificant; mark the end of 
+	// each block before the tags are stripped
+	collapsed := whitespaceCollapser.ReplaceAllString(content, " ")
+	marked := blockEndScanner.ReplaceAllStringFunc(collapsed, func(tag string) string {
+		return "\n" + tag
+	})
+	unescaped := html.UnescapeString(sanitize.StripTags(marked))
+
+	lines := make([]string, 0, 20)
+	for _, line := range strings.Split(unescaped, "\n") {
+		if line = strings.TrimSpace(whitespaceCollapser.ReplaceAllString(line, " ")); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// ReadingTime estimates how long the entry's text takes to read. 
+// Zero if the entry has no text (e.g. media-only entries)
+func (entry Entry)ReadingTime() time.Duration {
+	words := len(strings.Fields(PlainText(entry.Content)))
+	return time.Duration(words) * time.Minute / wordsPerMinute
+}
+
 func (entry Entry)Summary() string {
 	summary := DeHTMLize(entry.Content)
 	if runes := []rune(summary); len(runes) > maxSummaryLength {
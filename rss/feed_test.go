@@ -0,0 +1,124 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+
+package rss
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSynthesizeGUID(t *testing.T) {
+	published := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	if id := synthesizeGUID("guid", "http://example.com/1", "Title", published); id != "guid" {
+		t.Errorf("expected the explicit GUID, got %q", id)
+	}
+	if id := synthesizeGUID("", "http://example.com/1", "Title", published); id != "http://example.com/1" {
+		t.Errorf("expected the link, got %q", id)
+	}
+
+	id := synthesizeGUID("", "", "Title", published)
+	if !strings.HasPrefix(id, "urn:md5:") {
+		t.Fatalf("expected a hash, got %q", id)
+	}
+	if again := synthesizeGUID("", "", "Title", published); again != id {
+		t.Errorf("hash isn't stable: %q vs %q", id, again)
+	}
+	if other := synthesizeGUID("", "", "Title", published.Add(time.Hour)); other == id {
+		t.Errorf("different dates hash the same")
+	}
+	if other := synthesizeGUID("", "", "Other", published); other == id {
+		t.Errorf("different titles hash the same")
+	}
+
+	// The title can't run into the date
+	if synthesizeGUID("", "", "Title2017-03-01T12:00:00Z", time.Time {}) == id {
+		t.Errorf("title and date aren't separated")
+	}
+}
+
+func TestPrologue(t *testing.T) {
+	tests := []struct {
+		document string
+		root string
+		offset int64
+		stripped string
+	}{
+		{ `<rss version="2.0"></rss>`, "rss", 0, "" },
+		{ `<?xml version="1.0"?><!-- fine --><rss/>`, "rss", 0, "" },
+		{ `<?xml version="1.0"?>` + "\n" + `<!DOCTYPE rss [<!ENTITY x "y">]><rss/>`, "rss", 0, "" },
+		// "--" isn't allowed within comments
+		{ `<?xml version="1.0"?><!-- bad -- comment --><rss/>`, "rss", 44, `<?xml version="1.0"?><rss/>` },
+		{ `<!-- bad -- comment --><!DOCTYPE feed><feed xmlns="http://www.w3.org/2005/Atom"/>`, "feed", 38, `<feed xmlns="http://www.w3.org/2005/Atom"/>` },
+	}
+
+	for _, test := range tests {
+		root, offset, err := documentRoot([]byte(test.document))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.document, err)
+			continue
+		}
+		if root.XMLName.Local != test.root || offset != test.offset {
+			t.Errorf("%s: expected root %q at %d, got %q at %d", test.document, test.root, test.offset, root.XMLName.Local, offset)
+		}
+		if offset > 0 {
+			if stripped := string(withoutPrologue([]byte(test.document), offset)); stripped != test.stripped {
+				t.Errorf("%s: expected %q without the prologue, got %q", test.document, test.stripped, stripped)
+			}
+		}
+	}
+
+	// An unterminated comment hides the root
+	if offset := prologueLength([]byte(`<!-- never closed <rss/>`)); offset != 0 {
+		t.Errorf("expected no root after an unterminated comment, got offset %d", offset)
+	}
+	if _, _, err := documentRoot([]byte(`<!-- never closed <rss/>`)); err == nil {
+		t.Errorf("expected an error for an unterminated comment")
+	}
+}
+
+func TestNormalizeTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		expected string
+	}{
+		{ "Plain", "Plain" },
+		{ "  Padded  ", "Padded" },
+		{ "Line\nbreak\tand   spaces", "Line break and spaces" },
+		{ "Fish &amp; Chips", "Fish & Chips" },
+		{ "\n  Hello &amp; Goodbye \t", "Hello & Goodbye" },
+		{ "&lt;b&gt;", "<b>" },
+		{ "Caf&#233;", "Café" },
+		// Only ASCII whitespace is collapsed
+		{ "Non&nbsp;breaking", "Non\u00a0breaking" },
+		{ "", "" },
+	}
+
+	for _, test := range tests {
+		if title := normalizeTitle(test.title); title != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.title, test.expected, title)
+		}
+	}
+}
@@ -25,47 +25,318 @@ package rss
 
 import (
 	"appengine"
-	"strings"
-	"regexp"
+	"golang.org/x/net/html"
+	"io"
 	"net/url"
+	"regexp"
+	"strings"
 )
 
-func ExtractRSSLink(c appengine.Context, sourceURL string, html string) (string, error) {
-	tagRe := regexp.MustCompile(`<link(?:\s+\w+\s*=\s*(?:"[^"]*"|'[^']'))+\s*/?>`)
-	attrRe := regexp.MustCompile(`\b(?P<key>\w+)\s*=\s*(?:"(?P<value>[^"]*)"|'(?P<value>[^'])')`)
-
-	linkURL := ""
-	for _, linkTag := range tagRe.FindAllString(html, -1) {
-		link := make(map[string]string)
-		for _, attr := range attrRe.FindAllStringSubmatch(linkTag, -1) {
-			key := strings.ToLower(attr[1])
-			if attr[2] != "" {
-				link[key] = attr[2]
-			} else if attr[3] != "" {
-				link[key] = attr[3]
+type feedLink struct {
+	URL string
+	Type string
+	Title string
+}
+
+// PreferredFeedTypes determines which feed link wins when a page 
+// advertises more than one (e.g. both RSS and Atom). Links are matched
+// against the types in order. When empty, or when no link matches
+// any of the types, the first link with a title is picked, and failing
+// that, the first link on the page
+var PreferredFeedTypes = []string {}
+
+func feedLinkFromAttrs(attrs map[string]string) (feedLink, bool) {
+	isAlternate := false
+	for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+		if rel == "alternate" {
+			isAlternate = true
+			break
+		}
+	}
+
+	href := strings.TrimSpace(attrs["href"])
+	if !isAlternate || href == "" {
+		return feedLink{}, false
+	}
+
+	// Ignore any parameters (e.g. "; charset=utf-8")
+	mimeType := strings.ToLower(attrs["type"])
+	if semicolon := strings.Index(mimeType, ";"); semicolon > -1 {
+		mimeType = mimeType[:semicolon]
+	}
+
+	mimeType = strings.TrimSpace(mimeType)
+	if mimeType != "application/rss+xml" && mimeType != "application/atom+xml" {
+		return feedLink{}, false
+	}
+
+	return feedLink {
+		URL: href,
+		Type: mimeType,
+		Title: strings.TrimSpace(attrs["title"]),
+	}, true
+}
+
+func preferredFeedLink(links []feedLink) string {
+	for _, preferredType := range PreferredFeedTypes {
+		for _, link := range links {
+			if link.Type == preferredType {
+				return link.URL
 			}
 		}
+	}
+
+	for _, link := range links {
+		if link.Title != "" {
+			return link.URL
+		}
+	}
 
-		if link["rel"] == "alternate" {
-			if link["type"] == "application/rss+xml" || link["type"] == "application/atom+xml" {
-				linkURL = link["href"]
-				break
+	if len(links) > 0 {
+		return links[0].URL
+	}
+
+	return ""
+}
+
+func ExtractRSSLink(c appengine.Context, sourceURL string, content string) (string, error) {
+	links := make([]feedLink, 0, 4)
+
+	// The tokenizer takes care of attribute quoting and ordering, 
+	// and decodes any entities in attribute values
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			if err := tokenizer.Err(); err != io.EOF {
+				return "", err
 			}
+			break
+		} else if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			continue
+		}
+
+		token := tokenizer.Token()
+		if token.Data != "link" {
+			continue
+		}
+
+		attrs := make(map[string]string)
+		for _, attr := range token.Attr {
+			attrs[strings.ToLower(attr.Key)] = attr.Val
+		}
+
+		if link, ok := feedLinkFromAttrs(attrs); ok {
+			links = append(links, link)
 		}
 	}
 
+	linkURL := preferredFeedLink(links)
 	if linkURL != "" {
-		if refURL, err := url.Parse(linkURL); err != nil {
-			return "", err
-		} else if !refURL.IsAbs() {
-			// URL is not absolute. Resolve it.
-			if asURL, err := url.Parse(sourceURL); err != nil {
+		return resolveURL(sourceURL, linkURL)
+	}
+
+	return linkURL, nil
+}
+
+// ExtractRedirectLink returns the page that a splash or redirect page
+// points to, by way of a <meta http-equiv="refresh"> or, failing that,
+// a <link rel="canonical">. It returns an empty string if the page 
+// doesn't point anywhere but itself
+func ExtractRedirectLink(sourceURL string, content string) (string, error) {
+	refreshURL, canonicalURL := "", ""
+
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			if err := tokenizer.Err(); err != io.EOF {
 				return "", err
-			} else {
-				linkURL = asURL.ResolveReference(refURL).String()
+			}
+			break
+		} else if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			continue
+		}
+
+		token := tokenizer.Token()
+		if token.Data != "meta" && token.Data != "link" {
+			continue
+		}
+
+		attrs := make(map[string]string)
+		for _, attr := range token.Attr {
+			attrs[strings.ToLower(attr.Key)] = attr.Val
+		}
+
+		if token.Data == "meta" && strings.EqualFold(attrs["http-equiv"], "refresh") && refreshURL == "" {
+			refreshURL = refreshTarget(attrs["content"])
+		} else if token.Data == "link" && canonicalURL == "" {
+			for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+				if rel == "canonical" {
+					canonicalURL = strings.TrimSpace(attrs["href"])
+					break
+				}
 			}
 		}
 	}
 
-	return linkURL, nil
+	for _, target := range []string { refreshURL, canonicalURL } {
+		if target == "" {
+			continue
+		}
+
+		if resolved, err := resolveURL(sourceURL, target); err != nil {
+			return "", err
+		} else if resolved != sourceURL {
+			return resolved, nil
+		}
+	}
+
+	return "", nil
 }
+
+// refreshTarget returns the URL in the content of a refresh <meta>, 
+// e.g. "0; url='http://example.com/'"
+func refreshTarget(content string) string {
+	semicolon := strings.Index(content, ";")
+	if semicolon < 0 {
+		return ""
+	}
+
+	target := strings.TrimSpace(content[semicolon + 1:])
+	if len(target) >= 4 && strings.EqualFold(target[:3], "url") {
+		target = strings.TrimSpace(target[3:])
+		if !strings.HasPrefix(target, "=") {
+			return ""
+		}
+		target = strings.TrimSpace(target[1:])
+	}
+
+	return strings.Trim(target, "'\"")
+}
+
+// resolveURL resolves a (possibly relative) URL found on a page
+func resolveURL(sourceURL string, ref string) (string, error) {
+	if refURL, err := url.Parse(ref); err != nil {
+		return "", err
+	} else if !refURL.IsAbs() {
+		// URL is not absolute. Resolve it.
+		if asURL, err := url.Parse(sourceURL); err != nil {
+			return "", err
+		} else {
+			return asURL.ResolveReference(refURL).String(), nil
+		}
+	}
+
+	return ref, nil
+}
+
+// Elements that never have content, and so are never closed
+var voidElements = map[string]bool {
+	"area": true, "base": true, "br": true, "col": true, "embed": true, 
+	"hr": true, "img": true, "input": true, "link": true, "meta": true, 
+	"param": true, "source": true, "track": true, "wbr": true,
+}
+
+// Elements whose text is never part of an article
+var boilerplateElements = map[string]bool {
+	"script": true, "style": true, "nav": true, "header": true, 
+	"footer": true, "aside": true, "form": true, "noscript": true,
+}
+
+// Fewest characters of paragraph text a block needs to be taken for 
+// the body of an article
+const minMainContentLength = 200
+
+// ExtractMainContent finds the body of an article on a web page, 
+// readability-style: the element whose paragraphs hold the most text 
+// wins, with <article> and <main> elements favored. It returns the 
+// inner HTML of that element, less any scripts and styles, or an 
+// empty string if nothing looks like an article. Relative URLs are 
+// left as they are
+func ExtractMainContent(content string) string {
+	type openElement struct {
+		name string
+		// Offset of the element's content
+		start int
+		// Characters of text in the element's own paragraphs
+		score int
+	}
+
+	var stack []openElement
+	bestScore, bestStart, bestEnd := 0, 0, 0
+	offset := 0
+	skipDepth := 0
+
+	tokenizer := html.NewTokenizer(strings.NewReader(content))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+
+		tokenStart := offset
+		offset += len(tokenizer.Raw())
+		token := tokenizer.Token()
+
+		switch tokenType {
+		case html.StartTagToken:
+			if voidElements[token.Data] {
+				continue
+			}
+			if boilerplateElements[token.Data] || skipDepth > 0 {
+				skipDepth++
+			}
+			stack = append(stack, openElement { name: token.Data, start: offset })
+		case html.EndTagToken:
+			// Close the element, along with any left open inside it
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].name != token.Data {
+					continue
+				}
+
+				for len(stack) > i {
+					closed := stack[len(stack) - 1]
+					stack = stack[:len(stack) - 1]
+					if skipDepth > 0 {
+						skipDepth--
+					}
+
+					score := closed.score
+					if closed.name == "article" || closed.name == "main" {
+						score *= 2
+					}
+					if score > bestScore {
+						bestScore, bestStart, bestEnd = score, closed.start, tokenStart
+					}
+				}
+				break
+			}
+		case html.TextToken:
+			if skipDepth > 0 {
+				continue
+			}
+
+			// Credit the text to the parent of the paragraph it's in
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].name == "p" {
+					stack[i - 1].score += len(strings.TrimSpace(token.Data))
+					break
+				}
+			}
+		}
+	}
+
+	if bestScore < minMainContentLength {
+		return ""
+	}
+
+	body := content[bestStart:bestEnd]
+	body = scriptStripper.ReplaceAllString(body, "")
+	body = styleStripper.ReplaceAllString(body, "")
+
+	return strings.TrimSpace(body)
+}
+
+var scriptStripper = regexp.MustCompile(`(?is)<script\b.*?</script\s*>`)
+var styleStripper = regexp.MustCompile(`(?is)<style\b.*?</style\s*>`)
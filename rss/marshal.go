@@ -0,0 +1,190 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+ 
+package rss
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// Documents generated from a Feed, e.g. to republish articles. These 
+// are separate from the types feeds are parsed into, which tolerate 
+// far more than should be written
+
+type (
+	rss2Document struct {
+		XMLName xml.Name `xml:"rss"`
+		Version string `xml:"version,attr"`
+		DCNamespace string `xml:"xmlns:dc,attr"`
+		Channel rss2Channel `xml:"channel"`
+	}
+	rss2Channel struct {
+		Title string `xml:"title"`
+		Link string `xml:"link"`
+		Description string `xml:"description"`
+		LastBuildDate string `xml:"lastBuildDate,omitempty"`
+		Items []rss2Item `xml:"item"`
+	}
+	rss2Item struct {
+		Title string `xml:"title,omitempty"`
+		Link string `xml:"link,omitempty"`
+		Description string `xml:"description,omitempty"`
+		Creator string `xml:"dc:creator,omitempty"`
+		GUID rss2GUID `xml:"guid"`
+		PubDate string `xml:"pubDate,omitempty"`
+		Comments string `xml:"comments,omitempty"`
+		Enclosures []rss2DocumentEnclosure `xml:"enclosure"`
+	}
+	rss2DocumentEnclosure struct {
+		URL string `xml:"url,attr"`
+		Length int `xml:"length,attr"`
+		Type string `xml:"type,attr,omitempty"`
+	}
+	rss2GUID struct {
+		IsPermaLink bool `xml:"isPermaLink,attr"`
+		Value string `xml:",chardata"`
+	}
+	atomDocument struct {
+		XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+		Id string `xml:"id"`
+		Title string `xml:"title"`
+		Subtitle string `xml:"subtitle,omitempty"`
+		Updated string `xml:"updated"`
+		Links []atomDocumentLink `xml:"link"`
+		Entries []atomDocumentEntry `xml:"entry"`
+	}
+	atomDocumentEntry struct {
+		Id string `xml:"id"`
+		Title string `xml:"title"`
+		Updated string `xml:"updated"`
+		Published string `xml:"published,omitempty"`
+		Links []atomDocumentLink `xml:"link"`
+		Author *atomDocumentAuthor `xml:"author,omitempty"`
+		Content atomDocumentText `xml:"content"`
+	}
+	atomDocumentLink struct {
+		Rel string `xml:"rel,attr,omitempty"`
+		Type string `xml:"type,attr,omitempty"`
+		Href string `xml:"href,attr"`
+	}
+	atomDocumentAuthor struct {
+		Name string `xml:"name"`
+	}
+	atomDocumentText struct {
+		Type string `xml:"type,attr"`
+		Content string `xml:",chardata"`
+	}
+)
+
+// MarshalRSS2 writes the feed as an RSS 2.0 document
+func (feed *Feed)MarshalRSS2() ([]byte, error) {
+	document := rss2Document {
+		Version: "2.0",
+		DCNamespace: "http://purl.org/dc/elements/1.1/",
+		Channel: rss2Channel {
+			Title: feed.Title,
+			Link: feed.WWWURL,
+			Description: feed.Description,
+			Items: make([]rss2Item, len(feed.Entries)),
+		},
+	}
+
+	if !feed.Updated.IsZero() {
+		document.Channel.LastBuildDate = feed.Updated.Format(time.RFC1123Z)
+	}
+
+	for i, entry := range feed.Entries {
+		item := rss2Item {
+			Title: entry.Title,
+			Link: entry.WWWURL,
+			Description: entry.Content,
+			Creator: entry.Author,
+			GUID: rss2GUID { Value: entry.GUID },
+			Comments: entry.CommentsURL,
+		}
+
+		if !entry.Published.IsZero() {
+			item.PubDate = entry.Published.Format(time.RFC1123Z)
+		}
+
+		for _, media := range entry.Media {
+			item.Enclosures = append(item.Enclosures, rss2DocumentEnclosure {
+				URL: media.URL,
+				Type: media.Type,
+			})
+		}
+
+		document.Channel.Items[i] = item
+	}
+
+	return xml.MarshalIndent(document, "", "  ")
+}
+
+// MarshalAtom writes the feed as an Atom document. Feed.URL becomes 
+// the document's ID and self link
+func (feed *Feed)MarshalAtom() ([]byte, error) {
+	document := atomDocument {
+		Id: feed.URL,
+		Title: feed.Title,
+		Subtitle: feed.Description,
+		Updated: feed.Updated.Format(time.RFC3339),
+		Links: []atomDocumentLink {
+			atomDocumentLink { Rel: "self", Type: "application/atom+xml", Href: feed.URL },
+		},
+		Entries: make([]atomDocumentEntry, len(feed.Entries)),
+	}
+
+	if feed.WWWURL != "" {
+		document.Links = append(document.Links, atomDocumentLink { Rel: "alternate", Type: "text/html", Href: feed.WWWURL })
+	}
+
+	for i, entry := range feed.Entries {
+		atomEntry := atomDocumentEntry {
+			Id: entry.GUID,
+			Title: entry.Title,
+			Updated: entry.LatestModification().Format(time.RFC3339),
+			Content: atomDocumentText { Type: "html", Content: entry.Content },
+		}
+
+		if !entry.Published.IsZero() {
+			atomEntry.Published = entry.Published.Format(time.RFC3339)
+		}
+		if entry.WWWURL != "" {
+			atomEntry.Links = append(atomEntry.Links, atomDocumentLink { Rel: "alternate", Href: entry.WWWURL })
+		}
+		if entry.CommentsURL != "" {
+			atomEntry.Links = append(atomEntry.Links, atomDocumentLink { Rel: "replies", Type: "text/html", Href: entry.CommentsURL })
+		}
+		for _, media := range entry.Media {
+			atomEntry.Links = append(atomEntry.Links, atomDocumentLink { Rel: "enclosure", Type: media.Type, Href: media.URL })
+		}
+		if entry.Author != "" {
+			atomEntry.Author = &atomDocumentAuthor { Name: entry.Author }
+		}
+
+		document.Entries[i] = atomEntry
+	}
+
+	return xml.MarshalIndent(document, "", "  ")
+}
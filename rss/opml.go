@@ -26,6 +26,8 @@ package rss
 import (
 	"encoding/xml"
 	"io"
+	"strings"
+	"time"
 )
 
 type OPML struct {
@@ -37,6 +39,9 @@ type OPML struct {
 
 type head struct {
 	Title string `xml:"title"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+	OwnerName string `xml:"ownerName,omitempty"`
+	OwnerEmail string `xml:"ownerEmail,omitempty"`
 }
 
 type opmlBody struct {
@@ -60,6 +65,16 @@ func (opml *OPML)SetTitle(title string) {
 	opml.Head.Title = title
 }
 
+func (opml *OPML)SetDateCreated(created time.Time) {
+	// OPML dates are expected to be in RFC 822 format
+	opml.Head.DateCreated = created.UTC().Format(time.RFC1123)
+}
+
+func (opml *OPML)SetOwner(name string, email string) {
+	opml.Head.OwnerName = name
+	opml.Head.OwnerEmail = email
+}
+
 func (opml *OPML)Outlines() []*Outline {
 	return opml.Body.Outlines
 }
@@ -72,6 +87,17 @@ func (outline Outline)IsSubscription() bool {
 	return outline.FeedURL != ""
 }
 
+// DisplayTitle returns the title of the outline. Readers don't agree
+// on whether "title" or "text" holds the name shown to the user, so 
+// "title" is preferred when both are present
+func (outline Outline)DisplayTitle() string {
+	if title := strings.TrimSpace(outline.Title); title != "" {
+		return title
+	}
+
+	return strings.TrimSpace(outline.Text)
+}
+
 func NewOPML() OPML {
 	return OPML {
 		Version: "1.0",
@@ -26,6 +26,7 @@ package rss
 import (
 	"time"
 	"encoding/xml"
+	"strings"
 )
 
 var supportedRSS1TimeFormats = []string {
@@ -34,6 +35,10 @@ var supportedRSS1TimeFormats = []string {
 	"2006-01-02",
 }
 
+var rss1TimeFormat = timeFormat {
+	Layouts: supportedRSS1TimeFormats,
+}
+
 type rssLink struct {
 	XMLName xml.Name `xml:"link"`
 	Content string `xml:",chardata"`
@@ -54,6 +59,7 @@ type rss1Feed struct {
 type rss1Entry struct {
 	Id string `xml:"guid"`
 	Published string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Modified string `xml:"http://purl.org/dc/terms/ modified"`
 	EntryTitle string `xml:"title"`
 	Link string `xml:"link"`
 	Author string `xml:"http://purl.org/dc/elements/1.1/ creator"`
@@ -64,7 +70,7 @@ type rss1Entry struct {
 func (nativeFeed *rss1Feed) Marshal() (feed *Feed, err error) {
 	updated := time.Time {}
 	if nativeFeed.Updated != "" {
-		updated, err = parseTime(supportedRSS1TimeFormats, nativeFeed.Updated)
+		updated, err = rss1TimeFormat.parse(nativeFeed.Updated)
 	}
 
 	linkUrl := ""
@@ -75,7 +81,7 @@ func (nativeFeed *rss1Feed) Marshal() (feed *Feed, err error) {
 	}
 
 	feed = &Feed {
-		Title: nativeFeed.Title,
+		Title: normalizeTitle(nativeFeed.Title),
 		Description: nativeFeed.Description,
 		Updated: updated,
 		WWWURL: linkUrl,
@@ -106,17 +112,34 @@ func (nativeEntry *rss1Entry) Marshal() (entry *Entry, err error) {
 
 	published := time.Time {}
 	if nativeEntry.Published != "" {
-		published, err = parseTime(supportedRSS1TimeFormats, nativeEntry.Published)
+		published, err = rss1TimeFormat.parse(nativeEntry.Published)
+	}
+
+	// A bad modification date shouldn't fail the entry
+	updated, modErr := rss1TimeFormat.parse(nativeEntry.Modified)
+	if updated.IsZero() {
+		updated = published
+	} else if published.IsZero() {
+		published = updated
 	}
 
 	entry = &Entry {
 		GUID: guid,
 		Author: nativeEntry.Author,
-		Title: nativeEntry.EntryTitle,
+		Title: normalizeTitle(nativeEntry.EntryTitle),
 		Content: content,
 		Published: published,
+		PublishedRaw: strings.TrimSpace(nativeEntry.Published),
+		Updated: updated,
 		WWWURL: nativeEntry.Link,
 	}
 
+	if err != nil {
+		entry.Warnings = append(entry.Warnings, err.Error())
+	}
+	if modErr != nil {
+		entry.Warnings = append(entry.Warnings, modErr.Error())
+	}
+
 	return entry, err
 }
@@ -25,8 +25,7 @@ package rss
 
 import (
 	"encoding/xml"
-	"errors"
- 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -40,58 +39,43 @@ type (
 		Link []*rssLink `xml:"channel>link"`
 		Entry []*rss2Entry `xml:"channel>item"`
 		UpdatePeriod string `xml:"channel>updatePeriod"`
-		UpdateFrequency int `xml:"channel>updateFrequency"`
+		UpdateFrequency string `xml:"channel>updateFrequency"`
 	}
 	rss2Entry struct {
 		Id string `xml:"guid"`
 		Published string `xml:"pubDate"`
+		DCDate string `xml:"http://purl.org/dc/elements/1.1/ date"`
+		Modified string `xml:"http://purl.org/dc/terms/ modified"`
 		EntryTitle string `xml:"title"`
-		Link string `xml:"link"`
+		// Usually one, though items sometimes carry others (e.g. 
+		// atom:link to the comments)
+		Links []*rssLink `xml:"link"`
 		Author string `xml:"creator"`
 		EncodedContent string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
 		Content string `xml:"description"`
 		Enclosures []rss2Enclosure `xml:"enclosure"`
+		// Namespaced field must precede its unqualified namesake,
+		// otherwise <comments> would match either
+		CommentCount string `xml:"http://purl.org/rss/1.0/modules/slash/ comments"`
+		CommentsURL string `xml:"comments"`
+		Source rss2Source `xml:"source"`
+	}
+	rss2Source struct {
+		URL string `xml:"url,attr"`
+		Title string `xml:",chardata"`
 	}
 	rss2Enclosure struct {
 		URL string `xml:"url,attr"`
 		Length int `xml:"length,attr"`
 		Type string `xml:"type,attr"`
 	}
-	timezone struct {
-		Code string
-		Offset string
-	}
-	timezoneList []timezone
 )
 
 var (
-	// Basic TZ map to improve Golang's understanding of timezone shorthands
-	tzMap = map[string]string {
-		"EEST": "+0300",
-		"AKST": "-0900",
-		"AKDT": "-0800",
-		"HAST": "-1000",
-		"HADT": "-0900",
-		"CHST": "+1000",
-		"EET":  "+0200",
-		"AST":  "-0400",
-		"EST":  "-0500",
-		"EDT":  "-0400",
-		"CST":  "-0600",
-		"CDT":  "-0500",
-		"MST":  "-0700",
-		"MDT":  "-0600",
-		"PST":  "-0800",
-		"PDT":  "-0700",
-		"SST":  "-1100",
-		"SDT":  "-1000",
-		"CET":  "+0100",
-	}
-	timezones timezoneList
-
 	supportedRSS2TimeFormats = []string {
 		"Mon, 02 Jan 2006 15:04:05 -0700",
 		"2006-01-02T15:04:05-07:00",
+		time.RFC3339,
 		"Mon, 02 Jan 2006 15:04:05 Z",
 		"Mon, 02 Jan 2006 15:04:05",
 		"Mon, 2 Jan 2006 15:04:05 -0700",
@@ -100,38 +84,23 @@ var (
 		"Mon, 2 Jan 2006 15:04 -0700",
 		"Mon, 2 Jan 06 15:04:05 -0700",
 		"January 2, 2006",
+		"2006-01-02",
 	}
-)
 
-func (s timezoneList) Len() int {
-	return len(s)
-}
-
-func (s timezoneList) Swap(i int, j int) {
-	s[i], s[j] = s[j], s[i]
-}
-
-func (s timezoneList) Less(i int, j int) bool {
-	// Longer codes before shorter ones
-	return len(s[i].Code) > len(s[j].Code)
-}
-
-func init() {
-	timezones = make(timezoneList, len(tzMap))
-
-	// Put timezones into an array
-	i := 0
-	for code, offset := range tzMap {
-		timezones[i] = timezone {
-			Code: code,
-			Offset: offset,
-		}
-		i++
+	// Long-form layouts, tried with month names translated 
+	// from other languages
+	localizedRSS2TimeFormats = []string {
+		"January 2, 2006",
+		"2 January 2006",
+		"2. January 2006",
+		"2 de January de 2006",
 	}
 
-	// Sort the array (longer codes first)
-	sort.Sort(timezones)
-}
+	rss2TimeFormat = timeFormat {
+		Layouts: supportedRSS2TimeFormats,
+		ResolveTimezoneCodes: true,
+	}
+)
 
 func (nativeFeed *rss2Feed) Marshal() (feed *Feed, err error) {
 	updated := time.Time {}
@@ -160,7 +129,7 @@ func (nativeFeed *rss2Feed) Marshal() (feed *Feed, err error) {
 	}
 
 	feed = &Feed {
-		Title: nativeFeed.Title,
+		Title: normalizeTitle(nativeFeed.Title),
 		Description: nativeFeed.Description,
 		Updated: updated,
 		WWWURL: linkUrl,
@@ -169,8 +138,11 @@ func (nativeFeed *rss2Feed) Marshal() (feed *Feed, err error) {
 		HubURL: hubURL,
 	}
 
-	if nativeFeed.UpdateFrequency != 0 && nativeFeed.UpdatePeriod != "" {
-		updateFrequency := nativeFeed.UpdateFrequency
+	// Frequency is the number of updates per period. Anything that 
+	// isn't a positive integer is ignored, and the interval is 
+	// computed from the entries instead
+	updateFrequency, freqErr := strconv.Atoi(strings.TrimSpace(nativeFeed.UpdateFrequency))
+	if freqErr == nil && updateFrequency > 0 && nativeFeed.UpdatePeriod != "" {
 		updatePeriod := strings.ToLower(nativeFeed.UpdatePeriod)
 
 		if updatePeriod == "hourly" {
@@ -202,25 +174,66 @@ func (nativeFeed *rss2Feed) Marshal() (feed *Feed, err error) {
 }
 
 func (nativeEntry *rss2Entry) Marshal() (entry *Entry, err error) {
-	guid := nativeEntry.Id
 	content := nativeEntry.EncodedContent
 	if content == "" {
 		content = nativeEntry.Content
 	}
 
 	published := time.Time {}
+	publishedRaw := ""
 	if nativeEntry.Published != "" {
+		publishedRaw = nativeEntry.Published
 		published, err = parseRSS2Time(nativeEntry.Published)
+	} else if nativeEntry.DCDate != "" {
+		// Dublin Core date, when pubDate is missing
+		publishedRaw = nativeEntry.DCDate
+		published, err = parseRSS2Time(nativeEntry.DCDate)
 	}
 
+	// A bad modification date shouldn't fail the entry
+	updated, modErr := parseRSS2Time(nativeEntry.Modified)
+	if updated.IsZero() {
+		updated = published
+	} else if published.IsZero() {
+		published = updated
+	}
+
+	wwwURL, repliesURL := nativeEntry.links()
+
+	commentsURL := strings.TrimSpace(nativeEntry.CommentsURL)
+	if commentsURL == "" {
+		commentsURL = repliesURL
+	}
+
+	// <guid> is optional in RSS2; without a stable ID, the same item
+	// would be stored again on every poll
+	guid := synthesizeGUID(strings.TrimSpace(nativeEntry.Id),
+		wwwURL, nativeEntry.EntryTitle, published)
+
 	entry = &Entry {
 		GUID: guid,
 		Author: nativeEntry.Author,
-		Title: nativeEntry.EntryTitle,
+		Title: normalizeTitle(nativeEntry.EntryTitle),
 		Content: content,
 		Published: published,
-		WWWURL: nativeEntry.Link,
+		PublishedRaw: strings.TrimSpace(publishedRaw),
+		Updated: updated,
+		WWWURL: wwwURL,
 		Media: make([]Media, len(nativeEntry.Enclosures)),
+		CommentsURL: commentsURL,
+		SourceTitle: normalizeTitle(nativeEntry.Source.Title),
+		SourceURL: strings.TrimSpace(nativeEntry.Source.URL),
+	}
+
+	if commentCount, err := strconv.Atoi(strings.TrimSpace(nativeEntry.CommentCount)); err == nil {
+		entry.CommentCount = commentCount
+	}
+
+	if err != nil {
+		entry.Warnings = append(entry.Warnings, err.Error())
+	}
+	if modErr != nil {
+		entry.Warnings = append(entry.Warnings, modErr.Error())
 	}
 
 	for i, enclosure := range nativeEntry.Enclosures {
@@ -229,48 +242,52 @@ func (nativeEntry *rss2Entry) Marshal() (entry *Entry, err error) {
 			Type: enclosure.Type,
 		}
 
+		if media.Type == "" {
+			media.Type = inferMediaType(media.URL)
+		}
+
 		entry.Media[i] = media
 	}
 
 	return entry, err
 }
 
-func parseRSS2Time(timeSpec string) (time.Time, error) {
-	if timeSpec != "" {
-		if parsedTime, err := parseTime(supportedRSS2TimeFormats, timeSpec); err == nil {
-			return parsedTime, err
+// links returns the item's web address - the first link without a 
+// relation (or an alternate one) - and the address of its comments, 
+// if linked with a "replies" or "comments" relation
+func (nativeEntry *rss2Entry) links() (wwwURL string, repliesURL string) {
+	for _, link := range nativeEntry.Links {
+		// Plain RSS links have the address as content; Atom links
+		// have it as an attribute
+		linkURL := strings.TrimSpace(link.Content)
+		if linkURL == "" {
+			linkURL = strings.TrimSpace(link.Href)
 		}
-
-		// HACK territory
-		// GMT/UTC as TZ code are OK
-		if strings.HasSuffix(timeSpec, " GMT") || strings.HasSuffix(timeSpec, " UTC") {
-			if parsedTime, err := time.Parse("Mon, 2 Jan 2006 15:04:05 MST", timeSpec); err == nil {
-				return parsedTime.UTC(), nil
-			}
+		if linkURL == "" {
+			continue
 		}
 
-		// FIXME
-		// time.Parse doesn't deal with timezone codes predictably. 
-		// For that reason, we replace timezone codes with UTC offsets
-		// Note that this is not a proper long-term solution
-
-		tryAgain := false
-		for _, tz := range timezones {
-			if strings.Contains(timeSpec, tz.Code) {
-				timeSpec = strings.Replace(timeSpec, tz.Code, tz.Offset, 1)
-				tryAgain = true
-				break
+		for _, rel := range strings.Fields(link.Rel) {
+			if (rel == "replies" || rel == "comments") && repliesURL == "" {
+				repliesURL = linkURL
 			}
 		}
 
-		if tryAgain {
-			if parsedTime, err := parseTime(supportedRSS2TimeFormats, timeSpec); err == nil {
-				return parsedTime, err
-			}
+		if rel := strings.TrimSpace(link.Rel); (rel == "" || rel == "alternate") && wwwURL == "" {
+			wwwURL = linkURL
 		}
+	}
+
+	return wwwURL, repliesURL
+}
 
-		return time.Time {}, errors.New("Unrecognized time format: " + timeSpec)
+func parseRSS2Time(timeSpec string) (time.Time, error) {
+	parsedTime, err := rss2TimeFormat.parse(timeSpec)
+	if err != nil {
+		if localizedTime, ok := parseLocalizedTime(localizedRSS2TimeFormats, timeSpec); ok {
+			return localizedTime, nil
+		}
 	}
 
-	return time.Time {}, nil
+	return parsedTime, err
 }
@@ -0,0 +1,109 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+
+package rss
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestRSS2EntryLinks(t *testing.T) {
+	tests := []struct {
+		item string
+		wwwURL string
+		commentsURL string
+	}{
+		{
+			`<item xmlns:atom="http://www.w3.org/2005/Atom"><title>A</title>` +
+				`<atom:link rel="replies" href="http://example.com/1#comments"/>` +
+				`<link>http://example.com/1</link></item>`,
+			"http://example.com/1", "http://example.com/1#comments",
+		},
+		{
+			`<item xmlns:atom="http://www.w3.org/2005/Atom"><title>A</title>` +
+				`<atom:link rel="alternate" href="http://example.com/2"/>` +
+				`<atom:link rel="replies" href="http://example.com/2/replies"/></item>`,
+			"http://example.com/2", "http://example.com/2/replies",
+		},
+		{
+			// An explicit <comments> wins over a replies link
+			`<item xmlns:atom="http://www.w3.org/2005/Atom"><title>A</title>` +
+				`<link>http://example.com/3</link>` +
+				`<atom:link rel="replies" href="http://example.com/3/replies"/>` +
+				`<comments>http://example.com/3/comments</comments></item>`,
+			"http://example.com/3", "http://example.com/3/comments",
+		},
+	}
+
+	for _, test := range tests {
+		var nativeEntry rss2Entry
+		if err := xml.Unmarshal([]byte(test.item), &nativeEntry); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		entry, err := nativeEntry.Marshal()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if entry.WWWURL != test.wwwURL || entry.CommentsURL != test.commentsURL {
+			t.Errorf("expected links %q and %q, got %q and %q",
+				test.wwwURL, test.commentsURL, entry.WWWURL, entry.CommentsURL)
+		}
+	}
+}
+
+func TestRSS2UpdateFrequency(t *testing.T) {
+	tests := []struct {
+		period string
+		frequency string
+		hours float32
+	}{
+		{ "hourly", "2", 0.5 },
+		{ "daily", "4", 6 },
+		{ "daily", "", 0 },
+		{ "daily", "0", 0 },
+		{ "daily", "-3", 0 },
+		{ "daily", "often", 0 },
+		{ "", "2", 0 },
+	}
+
+	for _, test := range tests {
+		nativeFeed := rss2Feed {
+			Title: "Feed",
+			UpdatePeriod: test.period,
+			UpdateFrequency: test.frequency,
+		}
+
+		feed, err := nativeFeed.Marshal()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if feed.HourlyUpdateFrequency != test.hours {
+			t.Errorf("%s/%q: expected %v hours, got %v", test.period, test.frequency, test.hours, feed.HourlyUpdateFrequency)
+		}
+		if feed.DurationBetweenUpdates() < 0 {
+			t.Errorf("%s/%q: negative interval", test.period, test.frequency)
+		}
+	}
+}
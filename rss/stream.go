@@ -0,0 +1,302 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+
+package rss
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/paulrosania/go-charset/charset"
+	"crypto/md5"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Stream parsing. Entries are decoded one at a time as they arrive,
+// and only the rest of the document (the feed's own elements, minus
+// its entries) is kept, to be decoded once the stream ends. Since the
+// document can't be parsed a second time, the decoder isn't strict:
+// stray ampersands and mismatched tags are tolerated rather than
+// fixed up and retried, as Unmarshal does
+
+// Bytes read ahead to find the root element, and to drop whatever
+// precedes it
+const streamHeadSize = 64 * 1024
+
+// streamParser carries the state of a document being parsed from a
+// stream
+type streamParser struct {
+	decoder *xml.Decoder
+	newEntryMarshaler func() entryMarshaler
+	// Path to the element that holds the entries, by local name
+	entriesPath []string
+
+	// Names of the elements open outside of entries
+	open []xml.Name
+	skeleton bytes.Buffer
+	encoder *xml.Encoder
+
+	entries []*Entry
+	// Entries found outside of entriesPath; used if there are no others
+	strayEntries []*Entry
+	entryErr error
+}
+
+// UnmarshalStream parses a feed as it's read, without holding the
+// whole document in memory. Anything beyond MaxFeedSize is treated as
+// truncated
+func UnmarshalStream(url string, reader io.Reader) (*ParseResult, error) {
+	result := &ParseResult {
+		Encoding: "utf-8",
+	}
+
+	// The hash and length are of the document as sent
+	hasher := md5.New()
+	counter := &countingWriter{}
+	// If parsing fails, the rest of the stream is left unread; it's
+	// up to the caller to close it
+	buffered := bufio.NewReaderSize(io.TeeReader(io.LimitReader(reader, MaxFeedSize), io.MultiWriter(hasher, counter)), streamHeadSize)
+
+	head, err := buffered.Peek(streamHeadSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return result, err
+	}
+	// The buffer's contents move as it's read
+	head = append([]byte(nil), head...)
+
+	var document io.Reader = buffered
+	if rootOffset := prologueLength(head); rootOffset > 0 {
+		// Comments and such before the root are of no use, and may
+		// not be well-formed
+		stripped := withoutPrologue(head, rootOffset)
+		buffered.Discard(len(head))
+		document = io.MultiReader(bytes.NewReader(stripped), buffered)
+	}
+
+	decoder := xml.NewDecoder(document)
+	decoder.Strict = false
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		result.Encoding = strings.ToLower(label)
+		return charset.NewReader(label, input)
+	}
+
+	var root xml.StartElement
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			result.IsHTML = isHTMLDocument(head)
+			return result, err
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			root = start
+			break
+		}
+	}
+
+	parser := &streamParser {
+		decoder: decoder,
+	}
+
+	var newFeedMarshaler func() FeedMarshaler
+	if root.Name.Space == "http://www.w3.org/1999/02/22-rdf-syntax-ns#" && root.Name.Local == "RDF" {
+		newFeedMarshaler = func() FeedMarshaler { return &rss1Feed { } }
+		parser.newEntryMarshaler = func() entryMarshaler { return &rss1Entry { } }
+		parser.entriesPath = []string { "RDF" }
+		result.Format = "RSS1"
+	} else if root.Name.Local == "rss" {
+		newFeedMarshaler = func() FeedMarshaler { return &rss2Feed { } }
+		parser.newEntryMarshaler = func() entryMarshaler { return &rss2Entry { } }
+		parser.entriesPath = []string { "rss", "channel" }
+		result.Format = "RSS2"
+	} else if root.Name.Space == "http://www.w3.org/2005/Atom" && root.Name.Local == "feed" {
+		newFeedMarshaler = func() FeedMarshaler { return &atomFeed { } }
+		parser.newEntryMarshaler = func() entryMarshaler { return &atomEntry { } }
+		parser.entriesPath = []string { "feed" }
+		result.Format = "Atom"
+	} else {
+		result.IsHTML = strings.ToLower(root.Name.Local) == "html"
+		return result, errors.New("Unsupported type of feed (" +
+			root.Name.Space + ":" + root.Name.Local + ")")
+	}
+
+	if err := parser.parse(root); err != nil {
+		if !isTruncationError(err) || len(parser.entries) + len(parser.strayEntries) == 0 {
+			return result, err
+		}
+
+		// Keep the entries that arrived complete, as Unmarshal does
+		result.Truncated = true
+	}
+
+	xmlFeed := newFeedMarshaler()
+	if err := xml.Unmarshal(parser.skeleton.Bytes(), xmlFeed); err != nil {
+		return result, err
+	}
+
+	feed, err := xmlFeed.Marshal()
+	if feed == nil {
+		return result, err
+	}
+
+	feed.Entries = parser.entries
+	if len(feed.Entries) == 0 && len(parser.strayEntries) > 0 {
+		feed.Entries = parser.strayEntries
+		result.Lenient = true
+	}
+	if err == nil {
+		err = parser.entryErr
+	}
+
+	// Whatever follows the root (normally not much) still counts 
+	// toward the hash
+	io.Copy(ioutil.Discard, buffered)
+
+	feed.URL = url
+	feed.ContentHash = hasher.Sum(nil)
+	feed.ContentLength = counter.count
+	feed.Encoding = result.Encoding
+
+	result.Feed = feed
+	return result, err
+}
+
+// parse reads the document from the root element on, decoding entries
+// as they come and copying everything else to the skeleton. The
+// skeleton is complete (all elements closed) even if parsing fails
+func (parser *streamParser) parse(root xml.StartElement) error {
+	parser.encoder = xml.NewEncoder(&parser.skeleton)
+	defer parser.closeSkeleton()
+
+	if err := parser.startElement(root); err != nil {
+		return err
+	}
+
+	for len(parser.open) > 0 {
+		token, err := parser.decoder.Token()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		} else if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "item" || t.Name.Local == "entry" {
+				if err := parser.decodeEntry(t); err != nil {
+					return err
+				}
+			} else if err := parser.startElement(t); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			parser.open = parser.open[:len(parser.open) - 1]
+			if err := parser.encoder.EncodeToken(t); err != nil {
+				return err
+			}
+		case xml.CharData:
+			if err := parser.encoder.EncodeToken(t.Copy()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// startElement copies the start of an element to the skeleton. Only
+// unqualified attributes are kept, since the feeds' own elements only
+// use those; namespaces are declared by the encoder as needed
+func (parser *streamParser) startElement(start xml.StartElement) error {
+	copied := xml.StartElement {
+		Name: start.Name,
+	}
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "" && attr.Name.Local != "xmlns" {
+			copied.Attr = append(copied.Attr, attr)
+		}
+	}
+
+	parser.open = append(parser.open, start.Name)
+	return parser.encoder.EncodeToken(copied)
+}
+
+// decodeEntry decodes a single entry, noting whether it's where the
+// format expects entries to be
+func (parser *streamParser) decodeEntry(start xml.StartElement) error {
+	nativeEntry := parser.newEntryMarshaler()
+	if err := parser.decoder.DecodeElement(nativeEntry, &start); err != nil {
+		return err
+	}
+
+	entry, err := nativeEntry.Marshal()
+	if err != nil && parser.entryErr == nil {
+		parser.entryErr = err
+	}
+	if entry == nil {
+		return nil
+	}
+
+	if parser.isAtEntriesPath() {
+		parser.entries = append(parser.entries, entry)
+	} else {
+		parser.strayEntries = append(parser.strayEntries, entry)
+	}
+
+	return nil
+}
+
+func (parser *streamParser) isAtEntriesPath() bool {
+	if len(parser.open) != len(parser.entriesPath) {
+		return false
+	}
+
+	for i, name := range parser.open {
+		if name.Local != parser.entriesPath[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// closeSkeleton closes the elements left open (e.g. when the document
+// was truncated), so that the skeleton can be decoded
+func (parser *streamParser) closeSkeleton() {
+	for i := len(parser.open) - 1; i >= 0; i-- {
+		parser.encoder.EncodeToken(xml.EndElement { Name: parser.open[i] })
+	}
+	parser.encoder.Flush()
+}
+
+type countingWriter struct {
+	count int
+}
+
+func (writer *countingWriter) Write(p []byte) (int, error) {
+	writer.count += len(p)
+	return len(p), nil
+}
@@ -0,0 +1,161 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+
+package rss
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const streamTestRSS2 = `<?xml version="1.0" encoding="utf-8"?>
+<!-- generated -- by hand -->
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+<channel>
+<title>Example &amp; Co</title>
+<link>http://example.com/</link>
+<description>News</description>
+<item><title>First</title><link>http://example.com/1</link><guid>1</guid></item>
+<item><title>Second</title><link>http://example.com/2</link><guid>2</guid></item>
+</channel>
+</rss>`
+
+const streamTestAtom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+<title>Atom Example</title>
+<link rel="alternate" href="http://example.com/"/>
+<entry><id>tag:example.com,2017:1</id><title>First</title><link href="http://example.com/1"/></entry>
+</feed>`
+
+func TestUnmarshalStreamRSS2(t *testing.T) {
+	result, err := UnmarshalStream("http://example.com/feed", strings.NewReader(streamTestRSS2))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	feed := result.Feed
+	if result.Format != "RSS2" || feed.Title != "Example & Co" || feed.WWWURL != "http://example.com/" {
+		t.Errorf("unexpected feed: format %q, title %q, link %q", result.Format, feed.Title, feed.WWWURL)
+	}
+	if len(feed.Entries) != 2 || feed.Entries[0].GUID != "1" || feed.Entries[1].Title != "Second" {
+		t.Fatalf("unexpected entries: %+v", feed.Entries)
+	}
+	if result.Lenient || result.Truncated {
+		t.Errorf("unexpected flags: lenient %v, truncated %v", result.Lenient, result.Truncated)
+	}
+	if !bytes.Equal(feed.ContentHash, ContentHash([]byte(streamTestRSS2))) || feed.ContentLength != len(streamTestRSS2) {
+		t.Errorf("hash or length don't match the document")
+	}
+}
+
+func TestUnmarshalStreamAtom(t *testing.T) {
+	result, err := UnmarshalStream("http://example.com/feed", strings.NewReader(streamTestAtom))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	feed := result.Feed
+	if result.Format != "Atom" || feed.Title != "Atom Example" {
+		t.Errorf("unexpected feed: format %q, title %q", result.Format, feed.Title)
+	}
+	if len(feed.Entries) != 1 || feed.Entries[0].WWWURL != "http://example.com/1" {
+		t.Fatalf("unexpected entries: %+v", feed.Entries)
+	}
+}
+
+func TestUnmarshalStreamMatchesUnmarshal(t *testing.T) {
+	for _, document := range []string { streamTestRSS2, streamTestAtom } {
+		streamed, err := UnmarshalStream("http://example.com/feed", strings.NewReader(document))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		parsed, err := Unmarshal("http://example.com/feed", []byte(document))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if streamed.Feed.Title != parsed.Feed.Title || len(streamed.Feed.Entries) != len(parsed.Feed.Entries) {
+			t.Errorf("streamed feed differs: %q (%d entries) vs %q (%d entries)",
+				streamed.Feed.Title, len(streamed.Feed.Entries),
+				parsed.Feed.Title, len(parsed.Feed.Entries))
+		}
+	}
+}
+
+func TestUnmarshalStreamTruncated(t *testing.T) {
+	document := streamTestRSS2[:strings.Index(streamTestRSS2, "<item><title>Second")+20]
+
+	result, err := UnmarshalStream("http://example.com/feed", strings.NewReader(document))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !result.Truncated || len(result.Feed.Entries) != 1 || result.Feed.Title != "Example & Co" {
+		t.Errorf("expected one entry from a truncated feed, got %+v", result.Feed.Entries)
+	}
+}
+
+func TestUnmarshalStreamBadEntities(t *testing.T) {
+	document := strings.Replace(streamTestRSS2, "<title>First</title>", "<title>Fish & Chips</title>", 1)
+
+	result, err := UnmarshalStream("http://example.com/feed", strings.NewReader(document))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(result.Feed.Entries) != 2 || result.Feed.Entries[0].Title != "Fish & Chips" {
+		t.Errorf("unexpected entries: %+v", result.Feed.Entries)
+	}
+}
+
+func TestUnmarshalStreamLenient(t *testing.T) {
+	document := `<rss version="2.0"><channel><title>Wrapped</title>
+<items><item><title>Nested</title><guid>1</guid></item></items>
+</channel></rss>`
+
+	result, err := UnmarshalStream("http://example.com/feed", strings.NewReader(document))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !result.Lenient || len(result.Feed.Entries) != 1 {
+		t.Errorf("expected one lenient entry, got %+v", result.Feed.Entries)
+	}
+}
+
+func TestUnmarshalStreamHTML(t *testing.T) {
+	result, err := UnmarshalStream("http://example.com/", strings.NewReader(`<!DOCTYPE html><html><head><title>Page</title></head></html>`))
+	if err == nil || !result.IsHTML {
+		t.Errorf("expected an HTML error, got %v (IsHTML %v)", err, result.IsHTML)
+	}
+}
+
+func TestUnmarshalStreamLeavesRestOnError(t *testing.T) {
+	rest := strings.Repeat("<p>filler</p>", 100000)
+	reader := strings.NewReader(`<html><body>` + rest + `</body></html>`)
+
+	if _, err := UnmarshalStream("http://example.com/", reader); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if reader.Len() == 0 {
+		t.Errorf("expected the rest of a document that failed to be left unread")
+	}
+}
@@ -0,0 +1,138 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+ 
+package rss
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// A subscription, as exported by Feedly or Google Reader (Takeout). 
+// Feedly exports a bare array of these; Google Reader wraps them in an
+// object
+type jsonSubscription struct {
+	ID string      `json:"id"`
+	FeedID string  `json:"feedId"`
+	URL string     `json:"url"`
+	XMLURL string  `json:"xmlUrl"`
+	Title string   `json:"title"`
+	Website string `json:"website"`
+	HTMLURL string `json:"htmlUrl"`
+	Categories []struct {
+		ID string    `json:"id"`
+		Label string `json:"label"`
+	} `json:"categories"`
+}
+
+type jsonSubscriptionList struct {
+	Subscriptions []jsonSubscription `json:"subscriptions"`
+}
+
+var errNoJSONSubscriptions = errors.New("No subscriptions found")
+
+func (sub jsonSubscription)feedURL() string {
+	for _, id := range []string { sub.FeedID, sub.ID } {
+		if strings.HasPrefix(id, "feed/") {
+			return strings.TrimPrefix(id, "feed/")
+		}
+	}
+
+	if sub.XMLURL != "" {
+		return sub.XMLURL
+	}
+
+	return sub.URL
+}
+
+func (sub jsonSubscription)folderTitle() string {
+	for _, category := range sub.Categories {
+		if label := strings.TrimSpace(category.Label); label != "" {
+			return label
+		}
+	}
+
+	return ""
+}
+
+// ParseSubscriptionsJSON reads a subscription export in JSON (Feedly,
+// Google Reader), returning it as an equivalent OPML document. Each 
+// subscription is placed in the folder named by its first category;
+// subscriptions without a feed URL are skipped
+func ParseSubscriptionsJSON(reader io.Reader) (*OPML, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(reader).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	var subs []jsonSubscription
+	if trimmed := strings.TrimSpace(string(raw)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(raw, &subs); err != nil {
+			return nil, err
+		}
+	} else {
+		var list jsonSubscriptionList
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, err
+		}
+		subs = list.Subscriptions
+	}
+
+	opml := NewOPML()
+	folders := make(map[string]*Outline)
+	count := 0
+
+	for _, sub := range subs {
+		feedURL := strings.TrimSpace(sub.feedURL())
+		if !strings.HasPrefix(feedURL, "http://") && !strings.HasPrefix(feedURL, "https://") {
+			continue
+		}
+
+		webURL := sub.Website
+		if webURL == "" {
+			webURL = sub.HTMLURL
+		}
+
+		outline := NewSubscription(sub.Title, feedURL, webURL)
+		if folderTitle := sub.folderTitle(); folderTitle == "" {
+			opml.Add(outline)
+		} else {
+			folder := folders[folderTitle]
+			if folder == nil {
+				folder = NewFolder(folderTitle)
+				folders[folderTitle] = folder
+				opml.Add(folder)
+			}
+			folder.Add(outline)
+		}
+		count++
+	}
+
+	if count == 0 {
+		return nil, errNoJSONSubscriptions
+	}
+
+	return &opml, nil
+}
@@ -0,0 +1,205 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+ 
+package rss
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// timeFormat is an ordered list of layouts used to parse the 
+	// dates of a particular feed format
+	timeFormat struct {
+		Layouts []string
+		// If set, timezone codes (e.g. "PST") are replaced with 
+		// UTC offsets when none of the layouts match as-is
+		ResolveTimezoneCodes bool
+	}
+	timezone struct {
+		Code string
+		Offset string
+	}
+	timezoneList []timezone
+)
+
+var (
+	// Basic TZ map to improve Golang's understanding of timezone shorthands
+	tzMap = map[string]string {
+		"EEST": "+0300",
+		"AKST": "-0900",
+		"AKDT": "-0800",
+		"HAST": "-1000",
+		"HADT": "-0900",
+		"CHST": "+1000",
+		"EET":  "+0200",
+		"AST":  "-0400",
+		"EST":  "-0500",
+		"EDT":  "-0400",
+		"CST":  "-0600",
+		"CDT":  "-0500",
+		"MST":  "-0700",
+		"MDT":  "-0600",
+		"PST":  "-0800",
+		"PDT":  "-0700",
+		"SST":  "-1100",
+		"SDT":  "-1000",
+		"CET":  "+0100",
+	}
+	timezones timezoneList
+)
+
+func (s timezoneList) Len() int {
+	return len(s)
+}
+
+func (s timezoneList) Swap(i int, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s timezoneList) Less(i int, j int) bool {
+	// Longer codes before shorter ones
+	return len(s[i].Code) > len(s[j].Code)
+}
+
+func init() {
+	timezones = make(timezoneList, len(tzMap))
+
+	// Put timezones into an array
+	i := 0
+	for code, offset := range tzMap {
+		timezones[i] = timezone {
+			Code: code,
+			Offset: offset,
+		}
+		i++
+	}
+
+	// Sort the array (longer codes first)
+	sort.Sort(timezones)
+}
+
+func parseTime(supportedFormats []string, timeSpec string) (time.Time, error) {
+	if timeSpec != "" {
+		for _, format := range supportedFormats {
+			if parsedTime, err := time.Parse(format, timeSpec); err == nil {
+				return parsedTime.UTC(), nil
+			}
+		}
+
+		return time.Time {}, errors.New("Unrecognized time format: " + timeSpec)
+	}
+
+	return time.Time {}, nil
+}
+
+func (format timeFormat)parse(timeSpec string) (time.Time, error) {
+	if timeSpec != "" {
+		if parsedTime, err := parseTime(format.Layouts, timeSpec); err == nil {
+			return parsedTime, err
+		} else if !format.ResolveTimezoneCodes {
+			return time.Time {}, err
+		}
+
+		// HACK territory
+		// GMT/UTC as TZ code are OK
+		if strings.HasSuffix(timeSpec, " GMT") || strings.HasSuffix(timeSpec, " UTC") {
+			if parsedTime, err := time.Parse("Mon, 2 Jan 2006 15:04:05 MST", timeSpec); err == nil {
+				return parsedTime.UTC(), nil
+			}
+		}
+
+		// FIXME
+		// time.Parse doesn't deal with timezone codes predictably. 
+		// For that reason, we replace timezone codes with UTC offsets
+		// Note that this is not a proper long-term solution
+
+		tryAgain := false
+		for _, tz := range timezones {
+			if strings.Contains(timeSpec, tz.Code) {
+				timeSpec = strings.Replace(timeSpec, tz.Code, tz.Offset, 1)
+				tryAgain = true
+				break
+			}
+		}
+
+		if tryAgain {
+			if parsedTime, err := parseTime(format.Layouts, timeSpec); err == nil {
+				return parsedTime, err
+			}
+		}
+
+		return time.Time {}, errors.New("Unrecognized time format: " + timeSpec)
+	}
+
+	return time.Time {}, nil
+}
+
+// MonthNameLocales lists the languages (in order) whose month names 
+// are recognized in long-form dates, e.g. "2 février 2006"
+var MonthNameLocales = []string { "fr", "de", "es", "it", "pt", "nl" }
+
+var localizedMonthNames = map[string][]string {
+	"fr": { "janvier", "février", "mars", "avril", "mai", "juin", 
+		"juillet", "août", "septembre", "octobre", "novembre", "décembre" },
+	"de": { "januar", "februar", "märz", "april", "mai", "juni", 
+		"juli", "august", "september", "oktober", "november", "dezember" },
+	"es": { "enero", "febrero", "marzo", "abril", "mayo", "junio", 
+		"julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre" },
+	"it": { "gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", 
+		"luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre" },
+	"pt": { "janeiro", "fevereiro", "março", "abril", "maio", "junho", 
+		"julho", "agosto", "setembro", "outubro", "novembro", "dezembro" },
+	"nl": { "januari", "februari", "maart", "april", "mei", "juni", 
+		"juli", "augustus", "september", "oktober", "november", "december" },
+}
+
+// parseLocalizedTime replaces a month name in one of the configured 
+// languages with its English equivalent, and tries the layouts again
+func parseLocalizedTime(layouts []string, timeSpec string) (time.Time, bool) {
+	words := strings.Fields(strings.ToLower(timeSpec))
+
+	for _, locale := range MonthNameLocales {
+		for i, word := range words {
+			trimmed := strings.TrimRight(word, ".,")
+			for month, name := range localizedMonthNames[locale] {
+				if trimmed != name {
+					continue
+				}
+
+				translated := make([]string, len(words))
+				copy(translated, words)
+				translated[i] = time.Month(month + 1).String() + word[len(trimmed):]
+
+				if parsedTime, err := parseTime(layouts, strings.Join(translated, " ")); err == nil {
+					return parsedTime, true
+				}
+			}
+		}
+	}
+
+	return time.Time {}, false
+}
@@ -30,6 +30,10 @@ import (
 
 type BatchOp int
 
+// Number of times elements that failed as part of a batch are 
+// written again before giving up
+const maxBatchRetries = 2
+
 const (
 	BatchPut BatchOp = iota
 	BatchDelete
@@ -89,14 +93,37 @@ func (writer *BatchWriter)Enqueue(key *datastore.Key, object interface{}) error
 
 func (writer *BatchWriter)Flush() error {
 	if writer.pending > 0 {
-		if writer.op == BatchPut {
-			if _, err := datastore.PutMulti(writer.c, writer.keys[:writer.pending], writer.objects[:writer.pending]); err != nil {
-				return err
+		keys := writer.keys[:writer.pending]
+		var objects []interface{}
+		if writer.supportsObjects() {
+			objects = writer.objects[:writer.pending]
+		}
+
+		for attempt := 0; ; attempt++ {
+			err := writer.write(keys, objects)
+			if err == nil {
+				break
 			}
-		} else if writer.op == BatchDelete {
-			if err := datastore.DeleteMulti(writer.c, writer.keys[:writer.pending]); err != nil {
+
+			multiError, ok := err.(appengine.MultiError)
+			if !ok || attempt >= maxBatchRetries {
 				return err
 			}
+
+			// Retry only the elements that failed
+			var failedKeys []*datastore.Key
+			var failedObjects []interface{}
+			for i, err := range multiError {
+				if err != nil {
+					writer.c.Warningf("Batch write of %s failed (attempt %d): %s", keys[i], attempt + 1, err)
+					failedKeys = append(failedKeys, keys[i])
+					if objects != nil {
+						failedObjects = append(failedObjects, objects[i])
+					}
+				}
+			}
+
+			keys, objects = failedKeys, failedObjects
 		}
 
 		writer.written += writer.pending
@@ -106,6 +133,17 @@ func (writer *BatchWriter)Flush() error {
 	return nil
 }
 
+func (writer *BatchWriter)write(keys []*datastore.Key, objects []interface{}) error {
+	if writer.op == BatchPut {
+		_, err := datastore.PutMulti(writer.c, keys, objects)
+		return err
+	} else if writer.op == BatchDelete {
+		return datastore.DeleteMulti(writer.c, keys)
+	}
+
+	return nil
+}
+
 func (writer *BatchWriter)Close() error {
 	return writer.Flush()
 }
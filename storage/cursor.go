@@ -0,0 +1,152 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+ 
+package storage
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// ErrInvalidCursor is returned for continuation tokens that are 
+// malformed, have been tampered with, or were issued for a different 
+// filter than the one being paginated
+var ErrInvalidCursor = errors.New("Invalid continuation")
+
+// Cursor is a position within a paginated listing, tied to the filter
+// that listing uses. It's passed to clients as an opaque token (see 
+// Encode), signed so that they can't forge positions, or carry a 
+// position over to a listing with a different filter
+type Cursor struct {
+	Position string    `json:"c"`
+	Fingerprint string `json:"f"`
+	Signature []byte   `json:"s"`
+}
+
+// Signs cursors; created the first time a cursor is encoded
+type CursorSecret struct {
+	Secret []byte `datastore:",noindex"`
+}
+
+var cursorSecret struct {
+	sync.Mutex
+	secret []byte
+}
+
+func loadCursorSecret(c appengine.Context) ([]byte, error) {
+	cursorSecret.Lock()
+	defer cursorSecret.Unlock()
+
+	if cursorSecret.secret != nil {
+		return cursorSecret.secret, nil
+	}
+
+	secretKey := datastore.NewKey(c, "CursorSecret", "default", 0, nil)
+	secret := CursorSecret{}
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		if err := datastore.Get(c, secretKey, &secret); err == nil || IsFieldMismatch(err) {
+			return nil
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		secret.Secret = make([]byte, sha256.Size)
+		if _, err := rand.Read(secret.Secret); err != nil {
+			return err
+		}
+
+		_, err := datastore.Put(c, secretKey, &secret)
+		return err
+	}, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cursorSecret.secret = secret.Secret
+	return secret.Secret, nil
+}
+
+func (cursor Cursor)sign(secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(cursor.Fingerprint))
+	mac.Write([]byte{0})
+	mac.Write([]byte(cursor.Position))
+
+	return mac.Sum(nil)
+}
+
+// Encode returns the cursor as a token, or an empty string if there's 
+// no position to continue from
+func (cursor Cursor)Encode(c appengine.Context) (string, error) {
+	if cursor.Position == "" {
+		return "", nil
+	}
+
+	secret, err := loadCursorSecret(c)
+	if err != nil {
+		return "", err
+	}
+
+	cursor.Signature = cursor.sign(secret)
+	if cursorJSON, err := json.Marshal(cursor); err != nil {
+		return "", err
+	} else {
+		return base64.URLEncoding.EncodeToString(cursorJSON), nil
+	}
+}
+
+// DecodeCursor returns the cursor encoded in a token, after verifying 
+// its signature and that it was issued for a filter with the given 
+// fingerprint. An empty token decodes to an empty cursor, i.e. the 
+// start of the listing
+func DecodeCursor(c appengine.Context, token string, fingerprint string) (Cursor, error) {
+	cursor := Cursor{}
+	if token == "" {
+		return cursor, nil
+	}
+
+	if cursorJSON, err := base64.URLEncoding.DecodeString(token); err != nil {
+		return cursor, ErrInvalidCursor
+	} else if err := json.Unmarshal(cursorJSON, &cursor); err != nil {
+		return cursor, ErrInvalidCursor
+	}
+
+	secret, err := loadCursorSecret(c)
+	if err != nil {
+		return Cursor{}, err
+	}
+
+	if !hmac.Equal(cursor.Signature, cursor.sign(secret)) || cursor.Fingerprint != fingerprint {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
@@ -26,18 +26,77 @@ package storage
 import (
 	"appengine"
 	"appengine/datastore"
+	"appengine/memcache"
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html"
 	"math/rand"
 	"rss"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// StoreContentText determines whether a plain text rendition of each
+// entry is stored alongside its content. When not stored, it's
+// generated as needed
+var StoreContentText = false
+
+// NewArticleNotifier, if set, is called with the articles added to 
+// subscriptions that have a webhook. It's expected to queue delivery, 
+// rather than deliver the notifications itself
+var NewArticleNotifier func(c appengine.Context, notifications []WebhookNotification)
+
+// ErrVersionMismatch is returned when an article's properties have
+// changed since the version a client expected
+var ErrVersionMismatch = errors.New("Article properties have changed")
+
+// MaxEntriesPerUpdate is the most entries stored from a single fetch 
+// of a feed. Beyond it, the oldest entries are discarded. Zero means 
+// no limit
+var MaxEntriesPerUpdate = 250
+
+// MaxPublishedSkew is how far into the future an entry's publication 
+// date may be before it's clamped to the time it was fetched, so that
+// misdated entries don't stay at the top. Zero disables clamping
+var MaxPublishedSkew = 10 * time.Minute
+
+// Properties that are always set on an article when the other isn't,
+// so excluding one is the same as requiring the other
+var inverseProperties = map[string]string {
+	"read": "unread",
+	"unread": "read",
+}
+
 const (
 	articlePageSize = 40
+	maxArticleStates = 1000
 	defaultBatchSize = 400
+
+	// Bump to force feed information to be rewritten on the next
+	// update (e.g. to populate new fields)
+	feedInfoVersion = 2
+
+	maxFeedSearchResults = 10
+
+	// Articles counted as new since the user's last visit, at most
+	maxNewSinceVisit = 1000
+
+	// Most articles announced to a webhook per subscription update
+	maxWebhookNotifications = 10
+
+	instanceStatsCacheKey = "instanceStats"
+	unreadCountCacheKeyPrefix = "unreadCount:"
+	subscriptionsCacheKeyPrefix = "subscriptions:"
+	instanceStatsCacheDuration = 10 * time.Minute
+
+	// Refresh times and favicons change without the cached 
+	// subscriptions being discarded; the expiration bounds staleness
+	subscriptionsCacheDuration = 5 * time.Minute
 )
 
 func NewBatchWriter(c appengine.Context, op BatchOp) *BatchWriter {
@@ -127,19 +186,407 @@ func (user User)key(c appengine.Context) (*datastore.Key, error) {
 	return datastore.NewKey(c, "User", user.ID, 0, nil), nil
 }
 
+// NewArticlePage returns a page of articles within the filter's scope. 
+// For a folder, the ancestor query yields a single river across all 
+// of its subscriptions. The datastore cursor marks a position in the 
+// index, so articles arriving between pages don't shift the results.
+// The continuation is an encoded Cursor; passing it back with a 
+// different filter fails with ErrInvalidCursor
 func NewArticlePage(c appengine.Context, filter ArticleFilter, start string) (*ArticlePage, error) {
 	scopeKey, err := filter.key(c)
 	if err != nil {
 		return nil, err
 	}
 
-	q := datastore.NewQuery("Article").Ancestor(scopeKey).Order("-Fetched").Order("-Published")
-	if filter.Property != "" {
-		q = q.Filter("Properties = ", filter.Property)
+	fingerprint := filter.fingerprint()
+	cursor, err := DecodeCursor(c, start, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	property := filter.Property
+	excludeInMemory := filter.Exclude != ""
+	if inverse, ok := inverseProperties[filter.Exclude]; ok && property == "" && filter.Tag == "" {
+		property = inverse
+		excludeInMemory = false
+	}
+
+	var page *ArticlePage
+	if filter.UnreadFirst && property == "" && filter.Tag == "" {
+		page, err = newUnreadFirstArticlePage(c, scopeKey, filter, cursor.Position)
+	} else {
+		page, err = newArticlePageFromQuery(c, articleQuery(scopeKey, filter, property), cursor.Position, articlePageSize)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	next := Cursor {
+		Position: page.Continue,
+		Fingerprint: fingerprint,
+	}
+	if page.Continue, err = next.Encode(c); err != nil {
+		return nil, err
+	}
+
+	latest := filter.NewerThan
+	for _, article := range page.Articles {
+		if article.Stored.After(latest) {
+			latest = article.Stored
+		}
+	}
+
+	if !latest.IsZero() {
+		page.Latest = latest.Format(time.RFC3339Nano)
+	}
+
+	page.ViewID = filter.ViewID()
+
+	if excludeInMemory {
+		page.Articles = excludeArticlesByProperty(page.Articles, filter.Exclude)
+	}
+
+	if filter.Query != "" {
+		page.Articles = filterArticlesByText(page.Articles, filter.Query)
+	}
+
+	if filter.SummaryOnly {
+		for _, article := range page.Articles {
+			article.Details.Content = ""
+		}
+	} else if filter.SummaryByDisplayMode {
+		summarized, err := summarizedSubscriptions(c, scopeKey)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, article := range page.Articles {
+			if summarized[article.Source] {
+				article.Details.Content = ""
+			}
+		}
+	}
+
+	return page, nil
+}
+
+// summarizedSubscriptions returns the IDs of the subscriptions within
+// the scope that the user reads as summaries
+func summarizedSubscriptions(c appengine.Context, scopeKey *datastore.Key) (map[string]bool, error) {
+	var subscriptions []Subscription
+	q := datastore.NewQuery("Subscription").Ancestor(scopeKey).Limit(defaultBatchSize)
+	subscriptionKeys, err := q.GetAll(c, &subscriptions)
+	if err != nil && !IsFieldMismatch(err) {
+		return nil, err
+	}
+
+	summarized := make(map[string]bool)
+	for i, subscription := range subscriptions {
+		if subscription.DisplayMode == "summary" {
+			summarized[subscriptionKeys[i].StringID()] = true
+		}
+	}
+
+	return summarized, nil
+}
+
+// ArticleCountsByDay returns the number of articles fetched on each 
+// of the last few days (UTC, including today) within the filter's 
+// scope, keyed by date (YYYY-MM-DD). Only the scope, property and tag 
+// of the filter are considered. Since the datastore can't group, each
+// day is counted separately (and concurrently)
+func ArticleCountsByDay(c appengine.Context, filter ArticleFilter, days int) (map[string]int, error) {
+	scopeKey, err := filter.key(c)
+	if err != nil {
+		return nil, err
+	}
+
+	type dayCount struct {
+		date string
+		count int
+		err error
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	doneChannel := make(chan dayCount)
+
+	for i := 0; i < days; i++ {
+		go func(dayStart time.Time) {
+			q := datastore.NewQuery("Article").Ancestor(scopeKey)
+			if filter.Property != "" {
+				q = q.Filter("Properties =", filter.Property)
+			} else if filter.Tag != "" {
+				q = q.Filter("Tags =", filter.Tag)
+			}
+			q = q.Filter("Fetched >=", dayStart).Filter("Fetched <", dayStart.Add(24 * time.Hour)).KeysOnly()
+
+			count, err := q.Count(c)
+			doneChannel<- dayCount { dayStart.Format("2006-01-02"), count, err }
+		}(today.AddDate(0, 0, -i))
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < days; i++ {
+		result := <-doneChannel
+		if result.err != nil {
+			err = result.err
+		} else {
+			counts[result.date] = result.count
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// ResolveView returns the filter identified by a view ID (see 
+// ArticleFilter.ViewID), after checking that its folder or 
+// subscription still exists for the user
+func ResolveView(c appengine.Context, userID UserID, viewID string) (ArticleFilter, error) {
+	filter := ArticleFilter{}
+
+	view := articleView{}
+	if viewJSON, err := base64.URLEncoding.DecodeString(viewID); err != nil {
+		return filter, err
+	} else if err := json.Unmarshal(viewJSON, &view); err != nil {
+		return filter, err
+	}
+
+	filter.UserID = userID
+	filter.FolderID = view.FolderID
+	filter.SubscriptionID = view.SubscriptionID
+	filter.Tag = view.Tag
+	filter.UnreadFirst = view.UnreadFirst
+	filter.Author = view.Author
+	filter.Exclude = view.Exclude
+	if view.Property != nil {
+		filter.Property = *view.Property
+		filter.PropertySpecified = true
+	}
+
+	var exists bool
+	var err error
+	if filter.SubscriptionID != "" {
+		exists, err = SubscriptionExists(c, SubscriptionRef(filter.ArticleScope))
+	} else if filter.FolderID != "" {
+		exists, err = FolderExists(c, filter.FolderRef)
+	} else {
+		exists = true
+	}
+
+	if err != nil {
+		return filter, err
+	} else if !exists {
+		return filter, errors.New("View no longer available: " + viewID)
+	}
+
+	return filter, nil
+}
+
+func articleQuery(scopeKey *datastore.Key, filter ArticleFilter, property string) *datastore.Query {
+	q := datastore.NewQuery("Article").Ancestor(scopeKey)
+	if !filter.NewerThan.IsZero() {
+		// Incremental sync - most recently stored first. Inclusive;
+		// see ArticleFilter.NewerThan
+		q = q.Filter("Stored >=", filter.NewerThan).Order("-Stored")
+	}
+	q = q.Order("-Fetched").Order("-Published")
+
+	if property != "" {
+		q = q.Filter("Properties = ", property)
 	} else if filter.Tag != "" {
 		q = q.Filter("Tags = ", filter.Tag)
 	}
 
+	if filter.Author != "" {
+		q = q.Filter("Author =", normalizeAuthor(filter.Author))
+	}
+
+	return q
+}
+
+// filterArticlesByText keeps the articles with a title or content 
+// containing the query, ignoring case
+func filterArticlesByText(articles []Article, query string) []Article {
+	query = strings.ToLower(query)
+
+	matching := make([]Article, 0, len(articles))
+	for _, article := range articles {
+		if strings.Contains(strings.ToLower(article.Details.Title), query) ||
+			strings.Contains(strings.ToLower(article.Details.Content), query) {
+			matching = append(matching, article)
+		}
+	}
+
+	return matching
+}
+
+// excludeArticlesByProperty drops the articles that have the property
+func excludeArticlesByProperty(articles []Article, property string) []Article {
+	remaining := make([]Article, 0, len(articles))
+	for _, article := range articles {
+		if !article.HasProperty(property) {
+			remaining = append(remaining, article)
+		}
+	}
+
+	return remaining
+}
+
+// newUnreadFirstArticlePage lists unread articles, followed by read 
+// ones, as two consecutive queries. The continuation is the datastore 
+// cursor of the query in progress, prefixed with "u:" while listing 
+// unread articles, or "r:" once it's moved on to read articles
+func newUnreadFirstArticlePage(c appengine.Context, scopeKey *datastore.Key, filter ArticleFilter, start string) (*ArticlePage, error) {
+	phase, cursor := "u", ""
+	if start != "" {
+		if parts := strings.SplitN(start, ":", 2); len(parts) == 2 && (parts[0] == "u" || parts[0] == "r") {
+			phase, cursor = parts[0], parts[1]
+		} else {
+			return nil, errors.New("Invalid continuation: " + start)
+		}
+	}
+
+	page := &ArticlePage { Articles: make([]Article, 0) }
+	if phase == "u" {
+		unreadPage, err := newArticlePageFromQuery(c, articleQuery(scopeKey, filter, "unread"), cursor, articlePageSize)
+		if err != nil {
+			return nil, err
+		} else if unreadPage.Continue != "" {
+			unreadPage.Continue = "u:" + unreadPage.Continue
+			return unreadPage, nil
+		}
+
+		// Out of unread articles - fill the rest of the page with read ones
+		page.Articles = unreadPage.Articles
+		cursor = ""
+	}
+
+	if remaining := articlePageSize - len(page.Articles); remaining > 0 {
+		readPage, err := newArticlePageFromQuery(c, articleQuery(scopeKey, filter, "read"), cursor, remaining)
+		if err != nil {
+			return nil, err
+		}
+
+		page.Articles = append(page.Articles, readPage.Articles...)
+		if readPage.Continue != "" {
+			page.Continue = "r:" + readPage.Continue
+		}
+	}
+
+	return page, nil
+}
+
+// ArticleStatesSince returns the state of the user's articles whose 
+// properties changed after a time, in the order they changed. At most
+// maxArticleStates are returned at once; the rest are reached by 
+// passing the page's Continue token back as start. Changes that share
+// a timestamp aren't lost between pages, as they would be if the next
+// page began after the time of the last state
+func ArticleStatesSince(c appengine.Context, userID UserID, since time.Time, start string) (*ArticleStatePage, error) {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint := "states:" + string(userID) + ":" + since.Format(time.RFC3339Nano)
+	cursor, err := DecodeCursor(c, start, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	q := datastore.NewQuery("Article").Ancestor(userKey).Filter("StateUpdated >", since).Order("StateUpdated")
+	if cursor.Position != "" {
+		if position, err := datastore.DecodeCursor(cursor.Position); err == nil {
+			q = q.Start(position)
+		} else {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	page := &ArticleStatePage {
+		States: make([]ArticleState, 0, maxArticleStates),
+	}
+
+	t := q.Limit(maxArticleStates).Run(c)
+	for {
+		article := new(Article)
+		_, err := t.Next(article)
+
+		if err == datastore.Done {
+			break
+		} else if IsFieldMismatch(err) {
+			// Ignore - migration issue
+		} else if err != nil {
+			return nil, err
+		}
+
+		page.States = append(page.States, ArticleState {
+			ID: article.Entry.StringID(),
+			Source: article.Entry.Parent().StringID(),
+			Read: !article.IsUnread(),
+			Star: article.HasProperty("star"),
+			Like: article.IsLiked(),
+			UpdatedAt: article.StateUpdated,
+		})
+	}
+
+	if len(page.States) == maxArticleStates {
+		position, err := t.Cursor()
+		if err != nil {
+			return nil, err
+		}
+
+		next := Cursor {
+			Position: position.String(),
+			Fingerprint: fingerprint,
+		}
+		if page.Continue, err = next.Encode(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+// NewHistoryPage returns the articles a user has read, most recently 
+// read first
+func NewHistoryPage(c appengine.Context, userID UserID, start string) (*ArticlePage, error) {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint := "history:" + string(userID)
+	cursor, err := DecodeCursor(c, start, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	q := datastore.NewQuery("Article").Ancestor(userKey).Filter("Properties =", "read")
+	q = q.Filter("ReadAt >", time.Time {}).Order("-ReadAt")
+
+	page, err := newArticlePageFromQuery(c, q, cursor.Position, articlePageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	next := Cursor {
+		Position: page.Continue,
+		Fingerprint: fingerprint,
+	}
+	if page.Continue, err = next.Encode(c); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+func newArticlePageFromQuery(c appengine.Context, q *datastore.Query, start string, pageSize int) (*ArticlePage, error) {
 	if start != "" {
 		if cursor, err := datastore.DecodeCursor(start); err == nil {
 			q = q.Start(cursor)
@@ -150,11 +597,11 @@ func NewArticlePage(c appengine.Context, filter ArticleFilter, start string) (*A
 
 	t := q.Run(c)
 
-	articles := make([]Article, articlePageSize)
-	entryKeys := make([]*datastore.Key, articlePageSize)
+	articles := make([]Article, pageSize)
+	entryKeys := make([]*datastore.Key, pageSize)
 
 	var readCount int
-	for readCount = 0; readCount < articlePageSize; readCount++ {
+	for readCount = 0; readCount < pageSize; readCount++ {
 		article := &articles[readCount]
 
 		if _, err := t.Next(article); err != nil && err == datastore.Done {
@@ -174,7 +621,7 @@ func NewArticlePage(c appengine.Context, filter ArticleFilter, start string) (*A
 	}
 
 	continueFrom := ""
-	if readCount >= articlePageSize {
+	if readCount >= pageSize {
 		if cursor, err := t.Cursor(); err == nil {
 			continueFrom = cursor.String()
 		}
@@ -221,15 +668,43 @@ func NewArticlePage(c appengine.Context, filter ArticleFilter, start string) (*A
 	return &page, nil
 }
 
+// NewUserSubscriptions returns the user's subscriptions, folders and 
+// tags. The result is cached until any of them change
 func NewUserSubscriptions(c appengine.Context, userID UserID) (*UserSubscriptions, error) {
-	var subscriptions []Subscription
-	var subscriptionKeys []*datastore.Key
-
 	userKey, err := userID.key(c)
 	if err != nil {
 		return nil, err
 	}
 
+	cacheKey := subscriptionsCacheKey(userKey)
+	userSubscriptions := new(UserSubscriptions)
+	if _, err := memcache.Gob.Get(c, cacheKey, userSubscriptions); err == nil {
+		return userSubscriptions, nil
+	} else if err != memcache.ErrCacheMiss {
+		c.Warningf("Error reading cached subscriptions: %s", err)
+	}
+
+	userSubscriptions, err = loadUserSubscriptions(c, userKey)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &memcache.Item {
+		Key: cacheKey,
+		Object: userSubscriptions,
+		Expiration: subscriptionsCacheDuration,
+	}
+	if err := memcache.Gob.Set(c, item); err != nil {
+		c.Warningf("Error caching subscriptions: %s", err)
+	}
+
+	return userSubscriptions, nil
+}
+
+func loadUserSubscriptions(c appengine.Context, userKey *datastore.Key) (*UserSubscriptions, error) {
+	var subscriptions []Subscription
+	var subscriptionKeys []*datastore.Key
+
 	q := datastore.NewQuery("Subscription").Ancestor(userKey).Limit(defaultBatchSize)
 	if subKeys, err := q.GetAll(c, &subscriptions); err != nil {
 		return nil, err
@@ -241,8 +716,10 @@ func NewUserSubscriptions(c appengine.Context, userID UserID) (*UserSubscription
 
 	totalUnreadCount := 0
 	feedKeys := make([]*datastore.Key, len(subscriptions))
+	feedMetaKeys := make([]*datastore.Key, len(subscriptions))
 	for i, subscription := range subscriptions {
 		feedKeys[i] = subscription.Feed
+		feedMetaKeys[i] = datastore.NewKey(c, "FeedMeta", subscription.Feed.StringID(), 0, nil)
 		totalUnreadCount += subscription.UnreadCount
 	}
 
@@ -265,13 +742,27 @@ func NewUserSubscriptions(c appengine.Context, userID UserID) (*UserSubscription
 		}
 	}
 
+	// As with feeds, any errors just leave the schedule blank
+	feedMetas := make([]FeedMeta, len(subscriptions))
+	if err := datastore.GetMulti(c, feedMetaKeys, feedMetas); err != nil {
+		if _, ok := err.(appengine.MultiError); !ok {
+			return nil, err
+		}
+	}
+
 	for i, _ := range subscriptions {
 		subscriptionKey := subscriptionKeys[i]
 
 		subscription := &subscriptions[i]
 		subscription.ID = subscriptionKey.StringID()
 		subscription.Link = feeds[i].Link
+		if subscription.Link == "" {
+			subscription.Link = subscription.WWWURL
+		}
 		subscription.FavIconURL = feeds[i].FavIconURL
+		subscription.LastRefresh = feedMetas[i].Fetched
+		subscription.NextRefresh = feedMetas[i].NextFetch
+		subscription.RefreshFailures = feedMetas[i].FailureCount
 
 		if subscriptionKey.Parent().Kind() == "Folder" {
 			subscription.Parent = formatId("folder", subscriptionKey.Parent().IntID())
@@ -314,39 +805,113 @@ func NewUserSubscriptions(c appengine.Context, userID UserID) (*UserSubscription
 	return &userSubscriptions, nil
 }
 
-func IsFolderDuplicate(c appengine.Context, userID UserID, title string) (bool, error) {
+// TouchSubscription records that the user has just looked at the 
+// subscription, resetting its count of new articles
+func TouchSubscription(c appengine.Context, ref SubscriptionRef) (time.Time, error) {
+	subscriptionKey, err := ref.key(c)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	subscription := new(Subscription)
+	if err := datastore.Get(c, subscriptionKey, subscription); err != nil && !IsFieldMismatch(err) {
+		return time.Time{}, err
+	}
+
+	subscription.LastVisited = time.Now()
+	subscription.NewSinceVisit = 0
+	if _, err := datastore.Put(c, subscriptionKey, subscription); err != nil {
+		return time.Time{}, err
+	}
+
+	invalidateCachedSubscriptions(c, subscriptionKey)
+
+	return subscription.LastVisited, nil
+}
+
+// IsFolderDuplicate returns true if the user has a folder with the 
+// same title, ignoring case and surrounding space. The folder with the
+// ID specified, if any, is not considered (e.g. the one being renamed)
+func IsFolderDuplicate(c appengine.Context, userID UserID, title string, exceptFolderID string) (bool, error) {
 	userKey, err := userID.key(c)
 	if err != nil {
 		return false, err
 	}
 
-	var folders []*Folder
-	q := datastore.NewQuery("Folder").Ancestor(userKey).Filter("Title =", title).Limit(1)
-	if _, err := q.GetAll(c, &folders); err == nil && len(folders) > 0 {
-		return true, nil
-	} else if err != nil {
+	var exceptKey *datastore.Key
+	if exceptFolderID != "" {
+		if exceptKey, err = (FolderRef { UserID: userID, FolderID: exceptFolderID }).key(c); err != nil {
+			return false, err
+		}
+	}
+
+	folderKeys, err := foldersByTitle(c, userKey, title)
+	if err != nil {
 		return false, err
 	}
 
+	for _, folderKey := range folderKeys {
+		if exceptKey == nil || !folderKey.Equal(exceptKey) {
+			return true, nil
+		}
+	}
+
 	return false, nil
 }
 
-func IsSubscriptionDuplicate(c appengine.Context, userID UserID, subscriptionURL string) (bool, error) {
+// normalizeFolderTitle returns the form of a title that's compared 
+// when looking for duplicates
+func normalizeFolderTitle(title string) string {
+	return strings.ToLower(strings.TrimSpace(title))
+}
+
+// foldersByTitle returns the keys of the user's folders with the title,
+// ignoring case and surrounding space
+func foldersByTitle(c appengine.Context, userKey *datastore.Key, title string) ([]*datastore.Key, error) {
+	titleKey := normalizeFolderTitle(title)
+
+	q := datastore.NewQuery("Folder").Ancestor(userKey).Filter("TitleKey =", titleKey).KeysOnly()
+	folderKeys, err := q.GetAll(c, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Folders created before titles were normalized have no key to
+	// query, so they're compared one by one
+	var folders []Folder
+	q = datastore.NewQuery("Folder").Ancestor(userKey).Limit(defaultBatchSize)
+	allKeys, err := q.GetAll(c, &folders)
+	if err != nil && !IsFieldMismatch(err) {
+		return nil, err
+	}
+
+	for i, folder := range folders {
+		if folder.TitleKey == "" && normalizeFolderTitle(folder.Title) == titleKey {
+			folderKeys = append(folderKeys, allKeys[i])
+		}
+	}
+
+	return folderKeys, nil
+}
+
+// SubscriptionByFeedURL finds the user's subscription to a feed. The 
+// boolean is false if the user isn't subscribed
+func SubscriptionByFeedURL(c appengine.Context, userID UserID, feedURL string) (SubscriptionRef, bool, error) {
 	userKey, err := userID.key(c)
 	if err != nil {
-		return false, err
+		return SubscriptionRef{}, false, err
 	}
 
-	feedKey := datastore.NewKey(c, "Feed", subscriptionURL, 0, nil)
+	feedKey := datastore.NewKey(c, "Feed", feedURL, 0, nil)
 	q := datastore.NewQuery("Subscription").Ancestor(userKey).Filter("Feed =", feedKey).KeysOnly().Limit(1)
 
-	if subKeys, err := q.GetAll(c, nil); err != nil {
-		return false, err
-	} else if len(subKeys) > 0 {
-		return true, nil
+	if subscriptionKeys, err := q.GetAll(c, nil); err != nil {
+		return SubscriptionRef{}, false, err
+	} else if len(subscriptionKeys) > 0 {
+		return newSubscriptionRef(userID, subscriptionKeys[0]), true, nil
 	}
 
-	return false, nil
+	return SubscriptionRef{}, false, nil
 }
 
 func UserByID(c appengine.Context, userID UserID) (*User, error) {
@@ -381,8 +946,7 @@ func FolderByTitle(c appengine.Context, userID UserID, title string) (FolderRef,
 		return FolderRef{}, err
 	}
 	
-	q := datastore.NewQuery("Folder").Ancestor(userKey).Filter("Title =", title).KeysOnly().Limit(1)
-	if folderKeys, err := q.GetAll(c, nil); err != nil {
+	if folderKeys, err := foldersByTitle(c, userKey, title); err != nil {
 		return FolderRef{}, err
 	} else if len(folderKeys) > 0 {
 		return newFolderRef(userID, folderKeys[0]), nil
@@ -406,18 +970,37 @@ func FolderExists(c appengine.Context, ref FolderRef) (bool, error) {
 	return false, nil
 }
 
-func TagExists(c appengine.Context, userID UserID, tagID string) (bool, error) {
-	userKey, err := userID.key(c)
+// LoadFolder returns the folder, or nil if it doesn't exist
+func LoadFolder(c appengine.Context, ref FolderRef) (*Folder, error) {
+	folderKey, err := ref.key(c)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	tagKey := datastore.NewKey(c, "Tag", tagID, 0, userKey)
-	tag := new(Tag)
-	if err := datastore.Get(c, tagKey, tag); err == nil || IsFieldMismatch(err) {
-		return true, nil
-	} else if err != datastore.ErrNoSuchEntity {
-		return false, err
+	folder := new(Folder)
+	if err := datastore.Get(c, folderKey, folder); err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	} else if err != nil && !IsFieldMismatch(err) {
+		return nil, err
+	}
+
+	folder.ID = ref.FolderID
+
+	return folder, nil
+}
+
+func TagExists(c appengine.Context, userID UserID, tagID string) (bool, error) {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return false, err
+	}
+
+	tagKey := datastore.NewKey(c, "Tag", tagID, 0, userKey)
+	tag := new(Tag)
+	if err := datastore.Get(c, tagKey, tag); err == nil || IsFieldMismatch(err) {
+		return true, nil
+	} else if err != datastore.ErrNoSuchEntity {
+		return false, err
 	}
 
 	return false, nil
@@ -438,6 +1021,29 @@ func SubscriptionExists(c appengine.Context, ref SubscriptionRef) (bool, error)
 	return false, nil
 }
 
+func SubscriptionRefsWithinFolder(c appengine.Context, ref FolderRef) ([]SubscriptionRef, error) {
+	folderKey, err := ref.key(c)
+	if err != nil {
+		return nil, err
+	}
+
+	q := datastore.NewQuery("Subscription").Ancestor(folderKey).KeysOnly().Limit(defaultBatchSize)
+	subscriptionKeys, err := q.GetAll(c, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]SubscriptionRef, len(subscriptionKeys))
+	for i, subscriptionKey := range subscriptionKeys {
+		refs[i] = SubscriptionRef {
+			FolderRef: ref,
+			SubscriptionID: subscriptionKey.StringID(),
+		}
+	}
+
+	return refs, nil
+}
+
 func CreateFolder(c appengine.Context, userID UserID, title string) (FolderRef, error) {
 	userKey, err := userID.key(c)
 	if err != nil {
@@ -447,11 +1053,13 @@ func CreateFolder(c appengine.Context, userID UserID, title string) (FolderRef,
 	folderKey := datastore.NewIncompleteKey(c, "Folder", userKey)
 	folder := Folder {
 		Title: title,
+		TitleKey: normalizeFolderTitle(title),
 	}
 
 	if completeKey, err := datastore.Put(c, folderKey, &folder); err != nil {
 		return FolderRef{}, err
 	} else {
+		invalidateCachedSubscriptions(c, userKey)
 		return newFolderRef(userID, completeKey), nil
 	}
 }
@@ -468,10 +1076,13 @@ func RenameSubscription(c appengine.Context, ref SubscriptionRef, title string)
 	}
 
 	subscription.Title = title
+	subscription.TitleOverridden = true
 	if _, err := datastore.Put(c, subscriptionKey, subscription); err != nil {
 		return err
 	}
 
+	invalidateCachedSubscriptions(c, subscriptionKey)
+
 	return nil
 }
 
@@ -487,69 +1098,418 @@ func RenameFolder(c appengine.Context, ref FolderRef, title string) error {
 	}
 
 	folder.Title = title
+	folder.TitleKey = normalizeFolderTitle(title)
+	if _, err := datastore.Put(c, folderKey, folder); err != nil {
+		return err
+	}
+
+	invalidateCachedSubscriptions(c, folderKey)
+
+	return nil
+}
+
+func SetFolderDefaultFilter(c appengine.Context, ref FolderRef, property string) error {
+	folderKey, err := ref.key(c)
+	if err != nil {
+		return err
+	}
+
+	folder := new(Folder)
+	if err := datastore.Get(c, folderKey, folder); err != nil && !IsFieldMismatch(err) {
+		return err
+	}
+
+	folder.DefaultFilter = property
 	if _, err := datastore.Put(c, folderKey, folder); err != nil {
 		return err
 	}
 
+	invalidateCachedSubscriptions(c, folderKey)
+
 	return nil
 }
 
-func SetProperty(c appengine.Context, ref ArticleRef, propertyName string, propertyValue bool) ([]string, error) {
+// FolderDefaultFilter returns the property used to filter the articles 
+// of a folder when none is explicitly specified
+func FolderDefaultFilter(c appengine.Context, ref FolderRef) (string, error) {
+	folderKey, err := ref.key(c)
+	if err != nil {
+		return "", err
+	}
+
+	folder := new(Folder)
+	if err := datastore.Get(c, folderKey, folder); err != nil && !IsFieldMismatch(err) {
+		return "", err
+	}
+
+	return folder.DefaultFilter, nil
+}
+
+// SetProperty sets (or clears) a property of an article, returning 
+// the resulting state. Setting a property to its current value is a 
+// no-op, so it's safe to retry
+func SetProperty(c appengine.Context, ref ArticleRef, propertyName string, propertyValue bool, version int64) (*PropertyState, error) {
+	return updateProperty(c, ref, propertyName, func(current bool) bool {
+		return propertyValue
+	}, version)
+}
+
+// ToggleProperty flips a property of an article, returning the new 
+// state. Since it's not idempotent, retrying clients should pass the 
+// version they expect to change (or a negative version to skip the 
+// check)
+func ToggleProperty(c appengine.Context, ref ArticleRef, propertyName string, version int64) (*PropertyState, error) {
+	return updateProperty(c, ref, propertyName, func(current bool) bool {
+		return !current
+	}, version)
+}
+
+// updateProperty sets a property of an article to a value based on 
+// its current one, in a transaction. If version isn't negative, the 
+// article is only modified if its properties are at that version
+func updateProperty(c appengine.Context, ref ArticleRef, propertyName string, value func(bool) bool, version int64) (*PropertyState, error) {
 	articleKey, err := ref.key(c)
 	if err != nil {
 		return nil, err
 	}
 
 	article := new(Article)
-	if err := datastore.Get(c, articleKey, article); err != nil && !IsFieldMismatch(err) {
+	wasUnread, wasLiked, wasStarred := false, false, false
+
+	err = datastore.RunInTransaction(c, func(c appengine.Context) error {
+		if err := datastore.Get(c, articleKey, article); err != nil && !IsFieldMismatch(err) {
+			return err
+		}
+
+		wasUnread, wasLiked, wasStarred = article.IsUnread(), article.IsLiked(), article.IsStarred()
+
+		if version >= 0 && version != article.PropertyVersion {
+			return ErrVersionMismatch
+		}
+
+		current := article.HasProperty(propertyName)
+		if propertyValue := value(current); propertyValue != current {
+			article.SetProperty(propertyName, propertyValue)
+			if wasUnread && !article.IsUnread() {
+				article.ReadAt = time.Now()
+			}
+
+			if _, err := datastore.Put(c, articleKey, article); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, nil)
+
+	if err != nil {
 		return nil, err
 	}
 
-	if propertyValue != article.HasProperty(propertyName) {
-		wasUnread := article.IsUnread()
-		wasLiked := article.IsLiked()
-		unreadDelta := 0
+	if wasLiked != article.IsLiked() {
+		if wasLiked {
+			article.updateLikeCount(c, -1)
+		} else {
+			article.updateLikeCount(c, 1)
+		}
+	}
 
-		article.SetProperty(propertyName, propertyValue)
+	if wasStarred != article.IsStarred() {
+		if wasStarred {
+			article.updateStarCount(c, -1)
+		} else {
+			article.updateStarCount(c, 1)
+		}
+	}
 
-		// Update unread counts if necessary
-		if wasUnread != article.IsUnread() {
-			if wasUnread {
-				unreadDelta = -1
-			} else {
-				unreadDelta = 1
-			}
+	if wasLiked != article.IsLiked() || wasStarred != article.IsStarred() {
+		if err := consolidateEntryCounts(c, article.Entry); err != nil {
+			c.Warningf("Error totaling like and star counts: %s", err)
+		}
+	}
+
+	// Update unread counts if necessary
+	if wasUnread != article.IsUnread() {
+		if wasUnread {
+			adjustUnreadCount(c, articleKey.Parent(), -1)
+		} else {
+			adjustUnreadCount(c, articleKey.Parent(), 1)
 		}
+	}
 
-		if _, err := datastore.Put(c, articleKey, article); err != nil {
+	return &PropertyState {
+		Properties: article.Properties,
+		Version: article.PropertyVersion,
+	}, nil
+}
+
+// ArticleProperties returns the current properties of each of the 
+// articles, in the same order, read in a single batch. Articles that 
+// can't be found are nil
+func ArticleProperties(c appengine.Context, refs []ArticleRef) ([]*PropertyState, error) {
+	states := make([]*PropertyState, len(refs))
+
+	indices := make([]int, 0, len(refs))
+	articleKeys := make([]*datastore.Key, 0, len(refs))
+	for i, ref := range refs {
+		if ref.ArticleID == "" {
+			continue
+		} else if articleKey, err := ref.key(c); err == nil {
+			indices = append(indices, i)
+			articleKeys = append(articleKeys, articleKey)
+		}
+	}
+
+	articles := make([]Article, len(articleKeys))
+	articleErrors := make([]error, len(articleKeys))
+	if err := datastore.GetMulti(c, articleKeys, articles); err != nil {
+		if multiError, ok := err.(appengine.MultiError); ok {
+			articleErrors = multiError
+		} else {
+			return nil, err
+		}
+	}
+
+	for i, article := range articles {
+		if err := articleErrors[i]; err == datastore.ErrNoSuchEntity {
+			continue
+		} else if err != nil && !IsFieldMismatch(err) {
 			return nil, err
 		}
 
-		if wasLiked != article.IsLiked() {
-			if wasLiked {
-				article.updateLikeCount(c, -1)
-			} else {
-				article.updateLikeCount(c, 1)
-			}
+		states[indices[i]] = &PropertyState {
+			Properties: article.Properties,
+			Version: article.PropertyVersion,
 		}
+	}
+
+	return states, nil
+}
 
-		if unreadDelta != 0 {
-			// Update unread counts - not critical
-			subscriptionKey := articleKey.Parent()
-			subscription := new(Subscription)
+// adjustUnreadCount updates the unread count of a subscription.
+// Failures are logged, but otherwise not critical
+func adjustUnreadCount(c appengine.Context, subscriptionKey *datastore.Key, unreadDelta int) {
+	subscription := new(Subscription)
 
-			if err := datastore.Get(c, subscriptionKey, subscription); err != nil {
-				c.Warningf("Unread count update failed: subscription read error (%s)", err)
-			} else if subscription.UnreadCount + unreadDelta >= 0 {
-				subscription.UnreadCount += unreadDelta
-				if _, err := datastore.Put(c, subscriptionKey, subscription); err != nil {
-					c.Warningf("Unread count update failed: subscription write error (%s)", err)
-				}
+	if err := datastore.Get(c, subscriptionKey, subscription); err != nil {
+		c.Warningf("Unread count update failed: subscription read error (%s)", err)
+	} else if subscription.UnreadCount + unreadDelta >= 0 {
+		subscription.UnreadCount += unreadDelta
+		if _, err := datastore.Put(c, subscriptionKey, subscription); err != nil {
+			c.Warningf("Unread count update failed: subscription write error (%s)", err)
+		} else {
+			adjustCachedUnreadCount(c, subscriptionKey, unreadDelta)
+			invalidateCachedSubscriptions(c, subscriptionKey)
+		}
+	}
+}
+
+// unreadCountCacheKey returns the memcache key of the unread count 
+// for the user owning the entity
+func unreadCountCacheKey(key *datastore.Key) string {
+	for ; key.Parent() != nil; key = key.Parent() {
+	}
+
+	return unreadCountCacheKeyPrefix + key.StringID()
+}
+
+// subscriptionsCacheKey returns the memcache key of the subscriptions
+// of the user owning the entity
+func subscriptionsCacheKey(key *datastore.Key) string {
+	for ; key.Parent() != nil; key = key.Parent() {
+	}
+
+	return subscriptionsCacheKeyPrefix + key.StringID()
+}
+
+// invalidateCachedSubscriptions discards the user's cached 
+// subscriptions. Called after any change to subscriptions, folders or
+// tags, including unread counts
+func invalidateCachedSubscriptions(c appengine.Context, key *datastore.Key) {
+	if err := memcache.Delete(c, subscriptionsCacheKey(key)); err != nil && err != memcache.ErrCacheMiss {
+		c.Warningf("Error discarding cached subscriptions: %s", err)
+	}
+}
+
+// adjustCachedUnreadCount updates the user's cached unread count, if 
+// it's cached at all. Otherwise, it's recomputed when next requested
+func adjustCachedUnreadCount(c appengine.Context, key *datastore.Key, unreadDelta int) {
+	if _, err := memcache.IncrementExisting(c, unreadCountCacheKey(key), int64(unreadDelta)); err != nil && err != memcache.ErrCacheMiss {
+		c.Warningf("Error adjusting cached unread count: %s", err)
+	}
+}
+
+// invalidateCachedUnreadCount discards the user's cached unread count,
+// for changes too broad to track
+func invalidateCachedUnreadCount(c appengine.Context, key *datastore.Key) {
+	if err := memcache.Delete(c, unreadCountCacheKey(key)); err != nil && err != memcache.ErrCacheMiss {
+		c.Warningf("Error discarding cached unread count: %s", err)
+	}
+}
+
+// UnreadCount returns the total number of unread articles across a 
+// user's subscriptions. The total is cached, and kept up to date as 
+// articles are stored and read
+func UnreadCount(c appengine.Context, userID UserID) (int, error) {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return 0, err
+	}
+
+	cacheKey := unreadCountCacheKey(userKey)
+	if item, err := memcache.Get(c, cacheKey); err == nil {
+		if count, err := strconv.Atoi(string(item.Value)); err == nil {
+			return count, nil
+		}
+	} else if err != memcache.ErrCacheMiss {
+		c.Warningf("Error reading cached unread count: %s", err)
+	}
+
+	var subscriptions []Subscription
+	q := datastore.NewQuery("Subscription").Ancestor(userKey).Limit(defaultBatchSize)
+	if _, err := q.GetAll(c, &subscriptions); err != nil && !IsFieldMismatch(err) {
+		return 0, err
+	}
+
+	count := 0
+	for _, subscription := range subscriptions {
+		count += subscription.UnreadCount
+	}
+
+	// Add, rather than set, so as not to overwrite a concurrent update
+	item := &memcache.Item {
+		Key: cacheKey,
+		Value: []byte(strconv.Itoa(count)),
+	}
+	if err := memcache.Add(c, item); err != nil && err != memcache.ErrNotStored {
+		c.Warningf("Error caching unread count: %s", err)
+	}
+
+	return count, nil
+}
+
+// RecountUnread recomputes the unread counts of all of a user's 
+// subscriptions from their articles, then rebuilds the cached total
+func RecountUnread(c appengine.Context, userID UserID) (int, error) {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return 0, err
+	}
+
+	var subscriptions []Subscription
+	q := datastore.NewQuery("Subscription").Ancestor(userKey).Limit(defaultBatchSize)
+	subscriptionKeys, err := q.GetAll(c, &subscriptions)
+	if err != nil && !IsFieldMismatch(err) {
+		return 0, err
+	}
+
+	doneChannel := make(chan Subscription)
+	for i, subscription := range subscriptions {
+		go UpdateUnreadCounts(c, doneChannel, subscriptionKeys[i], subscription)
+	}
+
+	count := 0
+	for i := 0; i < len(subscriptions); i++ {
+		subscription := <-doneChannel
+		count += subscription.UnreadCount
+	}
+
+	item := &memcache.Item {
+		Key: unreadCountCacheKey(userKey),
+		Value: []byte(strconv.Itoa(count)),
+	}
+	if err := memcache.Set(c, item); err != nil {
+		c.Warningf("Error caching unread count: %s", err)
+	}
+
+	return count, nil
+}
+
+// LoadArticle returns a single article, along with its contents. If 
+// markRead is set, the article is marked as read in the same transaction
+// used to read it
+func LoadArticle(c appengine.Context, ref ArticleRef, markRead bool) (*Article, error) {
+	articleKey, err := ref.key(c)
+	if err != nil {
+		return nil, err
+	}
+
+	article := new(Article)
+	wasUnread := false
+
+	err = datastore.RunInTransaction(c, func(c appengine.Context) error {
+		if err := datastore.Get(c, articleKey, article); err != nil && !IsFieldMismatch(err) {
+			return err
+		}
+
+		wasUnread = article.IsUnread()
+		if markRead && wasUnread {
+			article.SetProperty("read", true)
+			article.ReadAt = time.Now()
+			if _, err := datastore.Put(c, articleKey, article); err != nil {
+				return err
 			}
 		}
+
+		return nil
+	}, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if markRead && wasUnread {
+		adjustUnreadCount(c, articleKey.Parent(), -1)
+	}
+
+	entryKey := article.Entry
+	article.ID = entryKey.StringID()
+	article.Source = entryKey.Parent().StringID()
+
+	entry := new(Entry)
+	if err := datastore.Get(c, entryKey, entry); err != nil && !IsFieldMismatch(err) {
+		return nil, err
+	}
+
+	if entry.HasMedia {
+		if media, err := MediaForEntry(c, entryKey); err != nil {
+			c.Warningf("Error loading media for entry: %s", err)
+		} else {
+			article.Media = media
+		}
+	}
+
+	article.Details = entry
+	if article.Tags == nil {
+		article.Tags = make([]string, 0)
+	}
+
+	return article, nil
+}
+
+// SetReadPosition stores how far into an article the user has read, as
+// a fraction of the article (0-1), and optionally, as a DOM anchor
+func SetReadPosition(c appengine.Context, ref ArticleRef, position float64, anchor string) error {
+	articleKey, err := ref.key(c)
+	if err != nil {
+		return err
 	}
 
-	return article.Properties, nil
+	return datastore.RunInTransaction(c, func(c appengine.Context) error {
+		article := new(Article)
+		if err := datastore.Get(c, articleKey, article); err != nil && !IsFieldMismatch(err) {
+			return err
+		}
+
+		article.ReadPosition = position
+		article.ReadAnchor = anchor
+
+		if _, err := datastore.Put(c, articleKey, article); err != nil {
+			return err
+		}
+
+		return nil
+	}, nil)
 }
 
 func SetTags(c appengine.Context, ref ArticleRef, tags []string) ([]string, error) {
@@ -603,16 +1563,40 @@ func SetTags(c appengine.Context, ref ArticleRef, tags []string) ([]string, erro
 		return nil, err
 	}
 
+	if batchWriter.Written() > 0 {
+		invalidateCachedSubscriptions(c, userKey)
+	}
+
 	return article.Tags, nil
 }
 
-func MarkAllAsRead(c appengine.Context, scope ArticleScope) (int, error) {
+// HasUnread reports whether there's at least one unread article
+// within the scope
+func HasUnread(c appengine.Context, scope ArticleScope) (bool, error) {
+	key, err := scope.key(c)
+	if err != nil {
+		return false, err
+	}
+
+	q := datastore.NewQuery("Article").Ancestor(key).Filter("Properties =", "unread").KeysOnly().Limit(1)
+	if articleKeys, err := q.GetAll(c, nil); err != nil {
+		return false, err
+	} else {
+		return len(articleKeys) > 0, nil
+	}
+}
+
+// MarkAllAsRead marks every unread article in the scope as read. If 
+// keepFlagged is set, starred and liked articles are left unread
+func MarkAllAsRead(c appengine.Context, scope ArticleScope, keepFlagged bool) (int, error) {
 	key, err := scope.key(c)
 	if err != nil {
 		return 0, err
 	}
 
 	batchWriter := NewBatchWriter(c, BatchPut)
+	// Unread articles left behind, by encoded subscription key
+	keptUnread := make(map[string]int)
 
 	q := datastore.NewQuery("Article").Ancestor(key).Filter("Properties =", "unread")
 	for t := q.Run(c); ; {
@@ -628,7 +1612,13 @@ func MarkAllAsRead(c appengine.Context, scope ArticleScope) (int, error) {
 			return 0, err
 		}
 
+		if keepFlagged && (article.HasProperty("star") || article.HasProperty("like")) {
+			keptUnread[articleKey.Parent().Encode()]++
+			continue
+		}
+
 		article.SetProperty("read", true)
+		article.ReadAt = time.Now()
 
 		if err := batchWriter.Enqueue(articleKey, article); err != nil {
 			c.Errorf("Error queueing article for batch write: %s", err)
@@ -649,8 +1639,8 @@ func MarkAllAsRead(c appengine.Context, scope ArticleScope) (int, error) {
 		if subscriptionKeys, err := q.GetAll(c, &subscriptions); err != nil {
 			return 0, err
 		} else {
-			for _, subscription := range subscriptions {
-				subscription.UnreadCount = 0
+			for i, subscription := range subscriptions {
+				subscription.UnreadCount = keptUnread[subscriptionKeys[i].Encode()]
 			}
 
 			if _, err := datastore.PutMulti(c, subscriptionKeys, subscriptions); err != nil {
@@ -663,15 +1653,299 @@ func MarkAllAsRead(c appengine.Context, scope ArticleScope) (int, error) {
 			return 0, err
 		}
 
-		subscription.UnreadCount = 0
+		subscription.UnreadCount = keptUnread[key.Encode()]
 		if _, err := datastore.Put(c, key, subscription); err != nil {
 			return 0, err
 		}
 	}
 
+	invalidateCachedUnreadCount(c, key)
+	invalidateCachedSubscriptions(c, key)
+
 	return batchWriter.Written(), nil
 }
 
+// UserPreferences returns the user's preferences document, or nil if
+// none has been saved
+func UserPreferences(c appengine.Context, userID UserID) ([]byte, error) {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return nil, err
+	}
+
+	preferences := new(Preferences)
+	preferencesKey := datastore.NewKey(c, "Preferences", "default", 0, userKey)
+	if err := datastore.Get(c, preferencesKey, preferences); err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	} else if err != nil && !IsFieldMismatch(err) {
+		return nil, err
+	}
+
+	return preferences.JSON, nil
+}
+
+// SetUserPreferences replaces the user's preferences document
+func SetUserPreferences(c appengine.Context, userID UserID, preferencesJSON []byte) error {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return err
+	}
+
+	preferences := Preferences {
+		JSON: preferencesJSON,
+		Updated: time.Now(),
+	}
+
+	preferencesKey := datastore.NewKey(c, "Preferences", "default", 0, userKey)
+	if _, err := datastore.Put(c, preferencesKey, &preferences); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetAutoReadAge sets the age (in days) after which a user's unread 
+// articles are marked as read. Zero disables it
+func SetAutoReadAge(c appengine.Context, userID UserID, days int) error {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return err
+	}
+
+	user := new(User)
+	if err := datastore.Get(c, userKey, user); err != nil && !IsFieldMismatch(err) {
+		return err
+	}
+
+	user.AutoReadAgeDays = days
+	if _, err := datastore.Put(c, userKey, user); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetMuteWords replaces the words (or phrases) that mute new articles
+func SetMuteWords(c appengine.Context, userID UserID, words []string) error {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return err
+	}
+
+	user := new(User)
+	if err := datastore.Get(c, userKey, user); err != nil && !IsFieldMismatch(err) {
+		return err
+	}
+
+	user.MuteWords = words
+	if _, err := datastore.Put(c, userKey, user); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetFeedToken sets (or, if empty, clears) the token that grants 
+// access to the user's folders as feeds
+func SetFeedToken(c appengine.Context, userID UserID, token string) error {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return err
+	}
+
+	user := new(User)
+	if err := datastore.Get(c, userKey, user); err != nil && !IsFieldMismatch(err) {
+		return err
+	}
+
+	user.FeedToken = token
+	if _, err := datastore.Put(c, userKey, user); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UserByFeedToken returns the user with the feed token, or nil if 
+// there isn't one
+func UserByFeedToken(c appengine.Context, token string) (*User, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	var users []User
+	q := datastore.NewQuery("User").Filter("FeedToken =", token).Limit(1)
+	if _, err := q.GetAll(c, &users); err != nil && !IsFieldMismatch(err) {
+		return nil, err
+	} else if len(users) == 0 {
+		return nil, nil
+	}
+
+	return &users[0], nil
+}
+
+// AutoMarkAsRead marks a user's unread articles fetched before a 
+// certain time as read, other than those starred or liked. It stops 
+// once the deadline is reached, returning the position to resume from
+// (empty once done), along with the number marked
+func AutoMarkAsRead(c appengine.Context, userID UserID, olderThan time.Time, start string, deadline time.Time) (int, string, error) {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return 0, "", err
+	}
+
+	q := datastore.NewQuery("Article").Ancestor(userKey).Filter("Properties =", "unread").Filter("Fetched <", olderThan).Order("-Fetched").Order("-Published")
+	return markQueryAsRead(c, q, true, start, deadline)
+}
+
+// MarkAsReadBefore marks the unread articles within scope fetched at 
+// or before a certain time as read, leaving alone anything that 
+// arrived later. As with AutoMarkAsRead, it returns the position to 
+// resume from if the deadline is reached
+func MarkAsReadBefore(c appengine.Context, scope ArticleScope, before time.Time, start string, deadline time.Time) (int, string, error) {
+	key, err := scope.key(c)
+	if err != nil {
+		return 0, "", err
+	}
+
+	q := datastore.NewQuery("Article").Ancestor(key).Filter("Properties =", "unread").Filter("Fetched <=", before).Order("-Fetched").Order("-Published")
+	return markQueryAsRead(c, q, false, start, deadline)
+}
+
+// markQueryAsRead marks the articles returned by the query as read, 
+// optionally skipping those starred or liked, and adjusts the unread 
+// counts of their subscriptions
+func markQueryAsRead(c appengine.Context, q *datastore.Query, keepFlagged bool, start string, deadline time.Time) (int, string, error) {
+	if start != "" {
+		if cursor, err := datastore.DecodeCursor(start); err != nil {
+			return 0, "", err
+		} else {
+			q = q.Start(cursor)
+		}
+	}
+
+	batchWriter := NewBatchWriter(c, BatchPut)
+	subscriptionKeys := make(map[string]*datastore.Key)
+	unreadDeltas := make(map[string]int)
+	continueFrom := ""
+
+	t := q.Run(c)
+	for {
+		if time.Now().After(deadline) {
+			if cursor, err := t.Cursor(); err != nil {
+				return 0, "", err
+			} else {
+				continueFrom = cursor.String()
+			}
+			break
+		}
+
+		article := new(Article)
+		articleKey, err := t.Next(article)
+
+		if err == datastore.Done {
+			break
+		} else if IsFieldMismatch(err) {
+			// Ignore - migration issue
+		} else if err != nil {
+			c.Errorf("Error reading Article: %s", err)
+			return 0, "", err
+		}
+
+		if keepFlagged && (article.HasProperty("star") || article.HasProperty("like")) {
+			continue
+		}
+
+		article.SetProperty("read", true)
+		article.ReadAt = time.Now()
+
+		if err := batchWriter.Enqueue(articleKey, article); err != nil {
+			c.Errorf("Error queueing article for batch write: %s", err)
+			return 0, "", err
+		}
+
+		subscriptionKey := articleKey.Parent()
+		subscriptionKeys[subscriptionKey.String()] = subscriptionKey
+		unreadDeltas[subscriptionKey.String()]--
+	}
+
+	if err := batchWriter.Flush(); err != nil {
+		c.Errorf("Error flushing batch queue: %s", err)
+		return 0, "", err
+	}
+
+	for id, subscriptionKey := range subscriptionKeys {
+		adjustUnreadCount(c, subscriptionKey, unreadDeltas[id])
+	}
+
+	return batchWriter.Written(), continueFrom, nil
+}
+
+// MarkNewerAsUnread marks all articles within scope fetched at or after
+// a certain time as unread
+func MarkNewerAsUnread(c appengine.Context, scope ArticleScope, since time.Time) (int, error) {
+	key, err := scope.key(c)
+	if err != nil {
+		return 0, err
+	}
+
+	batchWriter := NewBatchWriter(c, BatchPut)
+	subscriptionKeys := make(map[string]*datastore.Key)
+	unreadDeltas := make(map[string]int)
+
+	q := datastore.NewQuery("Article").Ancestor(key).Filter("Properties =", "read").Filter("Fetched >=", since).Order("-Fetched").Order("-Published")
+	for t := q.Run(c); ; {
+		article := new(Article)
+		articleKey, err := t.Next(article)
+
+		if err == datastore.Done {
+			break
+		} else if IsFieldMismatch(err) {
+			// Ignore - migration issue
+		} else if err != nil {
+			c.Errorf("Error reading Article: %s", err)
+			return 0, err
+		}
+
+		article.SetProperty("unread", true)
+
+		if err := batchWriter.Enqueue(articleKey, article); err != nil {
+			c.Errorf("Error queueing article for batch write: %s", err)
+			return 0, err
+		}
+
+		subscriptionKey := articleKey.Parent()
+		subscriptionKeys[subscriptionKey.String()] = subscriptionKey
+		unreadDeltas[subscriptionKey.String()]++
+	}
+
+	if err := batchWriter.Flush(); err != nil {
+		c.Errorf("Error flushing batch queue: %s", err)
+		return 0, err
+	}
+
+	for id, subscriptionKey := range subscriptionKeys {
+		adjustUnreadCount(c, subscriptionKey, unreadDeltas[id])
+	}
+
+	return batchWriter.Written(), nil
+}
+
+// ArticleFetchTime returns the time the article was fetched
+func ArticleFetchTime(c appengine.Context, ref ArticleRef) (time.Time, error) {
+	articleKey, err := ref.key(c)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	article := new(Article)
+	if err := datastore.Get(c, articleKey, article); err != nil && !IsFieldMismatch(err) {
+		return time.Time{}, err
+	}
+
+	return article.Fetched, nil
+}
+
 func MoveSubscription(c appengine.Context, subRef SubscriptionRef, destRef FolderRef) error {
 	currentSubscriptionKey, err := subRef.key(c)
 	if err != nil {
@@ -704,6 +1978,8 @@ func MoveSubscription(c appengine.Context, subRef SubscriptionRef, destRef Folde
 		return err
 	}
 
+	invalidateCachedSubscriptions(c, newSubscriptionKey)
+
 	return nil
 }
 
@@ -784,6 +2060,8 @@ func DeleteFolder(c appengine.Context, ref FolderRef) error {
 		return err
 	}
 
+	invalidateCachedSubscriptions(c, folderKey)
+
 	if subscriptionKeys == nil {
 		// No subscriptions; nothing more to do
 		return nil
@@ -794,6 +2072,8 @@ func DeleteFolder(c appengine.Context, ref FolderRef) error {
 		return err
 	}
 
+	invalidateCachedUnreadCount(c, folderKey)
+
 	return nil
 }
 
@@ -809,6 +2089,8 @@ func DeleteTag(c appengine.Context, userID UserID, tagID string) error {
 		return err
 	}
 
+	invalidateCachedSubscriptions(c, userKey)
+
 	return nil
 }
 
@@ -841,6 +2123,77 @@ func IsFeedAvailable(c appengine.Context, url string) (bool, error) {
 	return false, nil
 }
 
+// SetRawFeed records the blob holding the latest document of a feed,
+// returning the key of the blob it replaces, if any
+func SetRawFeed(c appengine.Context, url string, rawFeed RawFeed) (appengine.BlobKey, error) {
+	rawFeedKey := datastore.NewKey(c, "RawFeed", url, 0, nil)
+	var previous appengine.BlobKey
+
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		existing := new(RawFeed)
+		if err := datastore.Get(c, rawFeedKey, existing); err == nil || IsFieldMismatch(err) {
+			previous = existing.BlobKey
+		} else if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		_, err := datastore.Put(c, rawFeedKey, &rawFeed)
+		return err
+	}, nil)
+
+	return previous, err
+}
+
+// RawFeedByURL returns the latest document kept for a feed, or nil if
+// there isn't one
+func RawFeedByURL(c appengine.Context, url string) (*RawFeed, error) {
+	rawFeed := new(RawFeed)
+	rawFeedKey := datastore.NewKey(c, "RawFeed", url, 0, nil)
+
+	if err := datastore.Get(c, rawFeedKey, rawFeed); err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	} else if err != nil && !IsFieldMismatch(err) {
+		return nil, err
+	}
+
+	return rawFeed, nil
+}
+
+// DeleteRawFeed forgets the document kept for a feed. The blob itself
+// is left to the caller
+func DeleteRawFeed(c appengine.Context, url string) error {
+	rawFeedKey := datastore.NewKey(c, "RawFeed", url, 0, nil)
+	if err := datastore.Delete(c, rawFeedKey); err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+
+	return nil
+}
+
+// SearchFeeds finds known feeds with titles or site addresses that 
+// contain words beginning with each of the words in the query
+func SearchFeeds(c appengine.Context, query string) ([]Feed, error) {
+	terms := searchWords(query)
+	if len(terms) == 0 {
+		return []Feed{}, nil
+	}
+
+	q := datastore.NewQuery("Feed").Limit(maxFeedSearchResults)
+	for _, term := range terms {
+		if runes := []rune(term); len(runes) > maxSearchTermLength {
+			term = string(runes[:maxSearchTermLength])
+		}
+		q = q.Filter("SearchTerms =", term)
+	}
+
+	feeds := make([]Feed, 0, maxFeedSearchResults)
+	if _, err := q.GetAll(c, &feeds); err != nil && !IsFieldMismatch(err) {
+		return nil, err
+	}
+
+	return feeds, nil
+}
+
 func WebToFeedURL(c appengine.Context, url string, title *string) (string, error) {
 	q := datastore.NewQuery("Feed").Filter("Link =", url)
 	t := q.Run(c)
@@ -860,7 +2213,87 @@ func WebToFeedURL(c appengine.Context, url string, title *string) (string, error
 	return "", nil
 }
 
-func Subscribe(c appengine.Context, ref FolderRef, url string, title string) (SubscriptionRef, error) {
+// CanonicalToFeedURL returns the URL of a known feed that declares the
+// specified URL as its own canonical address, if any. Only canonical 
+// addresses that were verified (see SetCanonicalURL) are considered -
+// any feed can claim to be another
+func CanonicalToFeedURL(c appengine.Context, url string, title *string) (string, error) {
+	q := datastore.NewQuery("Feed").Filter("CanonicalURL =", url).Limit(10)
+	for t := q.Run(c); ; {
+		feed := new(Feed)
+		if _, err := t.Next(feed); err == datastore.Done {
+			break
+		} else if err != nil && !IsFieldMismatch(err) {
+			return "", err
+		}
+
+		feedMeta := new(FeedMeta)
+		feedMetaKey := datastore.NewKey(c, "FeedMeta", feed.URL, 0, nil)
+		if err := datastore.Get(c, feedMetaKey, feedMeta); err == datastore.ErrNoSuchEntity {
+			continue
+		} else if err != nil && !IsFieldMismatch(err) {
+			return "", err
+		}
+
+		if feedMeta.CanonicalURL == url && !feedMeta.CanonicalVerified.IsZero() {
+			if title != nil {
+				*title = feed.Title
+			}
+			return feed.URL, nil
+		}
+	}
+
+	return "", nil
+}
+
+// SetCanonicalURL records the canonical address of a feed, once it's
+// been confirmed to serve the same feed. An empty canonicalURL clears
+// the address
+func SetCanonicalURL(c appengine.Context, url string, canonicalURL string, verified time.Time) error {
+	feedMetaKey := datastore.NewKey(c, "FeedMeta", url, 0, nil)
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		feedMeta := new(FeedMeta)
+		if err := datastore.Get(c, feedMetaKey, feedMeta); err != nil && !IsFieldMismatch(err) {
+			return err
+		}
+
+		feedMeta.CanonicalURL = canonicalURL
+		if canonicalURL == "" {
+			feedMeta.CanonicalVerified = time.Time{}
+		} else {
+			feedMeta.CanonicalVerified = verified
+		}
+
+		_, err := datastore.Put(c, feedMetaKey, feedMeta)
+		return err
+	}, nil)
+
+	if err != nil {
+		return err
+	}
+
+	feedKey := datastore.NewKey(c, "Feed", url, 0, nil)
+	feed := new(Feed)
+	if err := datastore.Get(c, feedKey, feed); err == datastore.ErrNoSuchEntity {
+		return nil
+	} else if err != nil && !IsFieldMismatch(err) {
+		return err
+	}
+
+	if feed.CanonicalURL != canonicalURL {
+		feed.CanonicalURL = canonicalURL
+		if _, err := datastore.Put(c, feedKey, feed); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Subscribe subscribes to the feed at url. webURL is the address of 
+// the feed's website, if known in advance (e.g. from OPML), and is only
+// used until the feed itself is fetched
+func Subscribe(c appengine.Context, ref FolderRef, url string, title string, webURL string) (SubscriptionRef, error) {
 	folderKey, err := ref.key(c)
 	if err != nil {
 		return SubscriptionRef{}, err
@@ -878,141 +2311,463 @@ func Subscribe(c appengine.Context, ref FolderRef, url string, title string) (Su
 		subscription.Updated = time.Time {}
 		subscription.Subscribed = time.Now()
 		subscription.Title = title
+		subscription.WWWURL = webURL
+		subscription.Feed = datastore.NewKey(c, "Feed", url, 0, nil)
+
+		// A title other than the feed's own (e.g. from OPML) was 
+		// chosen by the user, and isn't replaced by the feed's
+		feed := new(Feed)
+		if err := datastore.Get(c, subscription.Feed, feed); err == nil || IsFieldMismatch(err) {
+			subscription.TitleOverridden = title != "" && feed.Title != "" && title != feed.Title
+		} else if err != datastore.ErrNoSuchEntity {
+			return SubscriptionRef{}, err
+		}
 		subscription.UnreadCount = 0
 		subscription.MaxUpdateIndex = -1
-		subscription.Feed = datastore.NewKey(c, "Feed", url, 0, nil)
 	} else {
 		return SubscriptionRef{}, err
 	}
 
-	if _, err := datastore.Put(c, subscriptionKey, subscription); err != nil {
-		return SubscriptionRef{}, err
+	if _, err := datastore.Put(c, subscriptionKey, subscription); err != nil {
+		return SubscriptionRef{}, err
+	}
+
+	invalidateCachedSubscriptions(c, subscriptionKey)
+
+	if err := updateSubscriberCount(c, url, 1); err != nil {
+		c.Warningf("Error incrementing subscriber count: %s", err)
+	}
+
+	return SubscriptionRef{
+		FolderRef: ref,
+		SubscriptionID: url,
+	}, nil
+}
+
+func SubscriptionsAsOPML(c appengine.Context, userID UserID) (*rss.OPML, error) {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return nil, err
+	}
+
+	opml := rss.NewOPML()
+	folderMap := map[string]*rss.Outline{}
+	q := datastore.NewQuery("Folder").Ancestor(userKey).Limit(defaultBatchSize)
+
+	var folders []Folder
+	if folderKeys, err := q.GetAll(c, &folders); err != nil {
+		return nil, err
+	} else if folders != nil {
+		for i, folder := range folders {
+			opmlFolder := rss.NewFolder(folder.Title)
+			folderMap[folderKeys[i].String()] = opmlFolder
+			opml.Add(opmlFolder)
+		}
+	}
+
+	q = datastore.NewQuery("Subscription").Ancestor(userKey).Limit(defaultBatchSize)
+
+	var subscriptions []Subscription
+	if subscriptionKeys, err := q.GetAll(c, &subscriptions); err != nil {
+		return nil, err
+	} else {
+		webURLs, err := subscriptionWebURLs(c, subscriptions)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, subscription := range subscriptions {
+			subscriptionKey := subscriptionKeys[i]
+			parentKey := subscriptionKey.Parent()
+
+			opmlSub := rss.NewSubscription(subscription.Title, subscriptionKey.StringID(), webURLs[i])
+			if parentKey.Kind() != "Folder" {
+				opml.Add(opmlSub)
+			} else {
+				if folder := folderMap[parentKey.String()]; folder != nil {
+					folder.Add(opmlSub)
+				} else {
+					opml.Add(opmlSub) // Orphaned folder
+				}
+			}
+		}
+	}
+
+	return &opml, nil
+}
+
+// FolderSubscriptionsAsOPML returns an OPML document containing a single
+// folder, along with the subscriptions it contains
+func FolderSubscriptionsAsOPML(c appengine.Context, ref FolderRef) (*rss.OPML, error) {
+	folderKey, err := ref.key(c)
+	if err != nil {
+		return nil, err
+	}
+
+	folder := new(Folder)
+	if err := datastore.Get(c, folderKey, folder); err != nil {
+		return nil, err
+	}
+
+	opml := rss.NewOPML()
+	opmlFolder := rss.NewFolder(folder.Title)
+	opml.Add(opmlFolder)
+
+	var subscriptions []Subscription
+	q := datastore.NewQuery("Subscription").Ancestor(folderKey).Limit(defaultBatchSize)
+
+	if subscriptionKeys, err := q.GetAll(c, &subscriptions); err != nil {
+		return nil, err
+	} else {
+		webURLs, err := subscriptionWebURLs(c, subscriptions)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, subscription := range subscriptions {
+			opmlFolder.Add(rss.NewSubscription(subscription.Title, 
+				subscriptionKeys[i].StringID(), webURLs[i]))
+		}
+	}
+
+	return &opml, nil
+}
+
+// subscriptionWebURLs returns the web (non-feed) URL of each subscription.
+// URLs of feeds that can't be loaded are left empty
+func subscriptionWebURLs(c appengine.Context, subscriptions []Subscription) ([]string, error) {
+	feedKeys := make([]*datastore.Key, len(subscriptions))
+	for i, subscription := range subscriptions {
+		feedKeys[i] = subscription.Feed
+	}
+
+	var multiError appengine.MultiError
+	feeds := make([]Feed, len(subscriptions))
+
+	if err := datastore.GetMulti(c, feedKeys, feeds); err != nil {
+		if me, ok := err.(appengine.MultiError); ok {
+			multiError = me
+		} else {
+			return nil, err
+		}
+	}
+
+	webURLs := make([]string, len(subscriptions))
+	for i, _ := range subscriptions {
+		if multiError == nil || multiError[i] == nil || IsFieldMismatch(multiError[i]) {
+			webURLs[i] = feeds[i].Link
+		}
+		if webURLs[i] == "" {
+			webURLs[i] = subscriptions[i].WWWURL
+		}
+	}
+
+	return webURLs, nil
+}
+
+func Unsubscribe(c appengine.Context, ref SubscriptionRef) error {
+	subscriptionKey, err := ref.key(c)
+	if err != nil {
+		return err
+	}
+
+	if err := datastore.Delete(c, subscriptionKey); err != nil {
+		return err
+	}
+
+	invalidateCachedUnreadCount(c, subscriptionKey)
+	invalidateCachedSubscriptions(c, subscriptionKey)
+
+	if err := updateSubscriberCount(c, ref.SubscriptionID, -1); err != nil {
+		c.Warningf("Error decrementing subscriber count: %s", err)
+	}
+
+	feedKey := datastore.NewKey(c, "Feed", ref.SubscriptionID, 0, nil)
+	if err := updateRefreshIntervalOverride(c, feedKey); err != nil {
+		c.Warningf("Error updating refresh interval: %s", err)
+	}
+
+	return nil
+}
+
+// SetPaused suspends (or resumes) updates to a subscription. Articles
+// already stored are left alone
+func SetPaused(c appengine.Context, ref SubscriptionRef, paused bool) error {
+	subscriptionKey, err := ref.key(c)
+	if err != nil {
+		return err
+	}
+
+	subscription := new(Subscription)
+	if err := datastore.Get(c, subscriptionKey, subscription); err != nil && !IsFieldMismatch(err) {
+		return err
+	}
+
+	subscription.Paused = paused
+	if _, err := datastore.Put(c, subscriptionKey, subscription); err != nil {
+		return err
+	}
+
+	invalidateCachedSubscriptions(c, subscriptionKey)
+
+	return nil
+}
+
+// SetResurfaceUpdates determines whether read articles whose content
+// is later changed are marked as unread again
+func SetResurfaceUpdates(c appengine.Context, ref SubscriptionRef, resurface bool) error {
+	subscriptionKey, err := ref.key(c)
+	if err != nil {
+		return err
+	}
+
+	subscription := new(Subscription)
+	if err := datastore.Get(c, subscriptionKey, subscription); err != nil && !IsFieldMismatch(err) {
+		return err
+	}
+
+	subscription.ResurfaceUpdates = resurface
+	if _, err := datastore.Put(c, subscriptionKey, subscription); err != nil {
+		return err
+	}
+
+	invalidateCachedSubscriptions(c, subscriptionKey)
+
+	return nil
+}
+
+// SetDedupByLink sets whether new entries of a subscription are matched
+// against existing articles on link and title, rather than GUID
+func SetDedupByLink(c appengine.Context, ref SubscriptionRef, dedup bool) error {
+	subscriptionKey, err := ref.key(c)
+	if err != nil {
+		return err
+	}
+
+	subscription := new(Subscription)
+	if err := datastore.Get(c, subscriptionKey, subscription); err != nil && !IsFieldMismatch(err) {
+		return err
+	}
+
+	subscription.DedupByLink = dedup
+	if _, err := datastore.Put(c, subscriptionKey, subscription); err != nil {
+		return err
+	}
+
+	invalidateCachedSubscriptions(c, subscriptionKey)
+
+	return nil
+}
+
+// SetDisplayMode records how the user prefers to read a subscription
+// ("summary" or "full"). An empty mode removes the preference
+func SetDisplayMode(c appengine.Context, ref SubscriptionRef, mode string) error {
+	subscriptionKey, err := ref.key(c)
+	if err != nil {
+		return err
+	}
+
+	subscription := new(Subscription)
+	if err := datastore.Get(c, subscriptionKey, subscription); err != nil && !IsFieldMismatch(err) {
+		return err
+	}
+
+	subscription.DisplayMode = mode
+	if _, err := datastore.Put(c, subscriptionKey, subscription); err != nil {
+		return err
+	}
+
+	invalidateCachedSubscriptions(c, subscriptionKey)
+
+	return nil
+}
+
+// SetWebhook sets the URL notified of new articles in a subscription,
+// along with the secret used to sign the notifications. An empty URL
+// removes the webhook
+func SetWebhook(c appengine.Context, ref SubscriptionRef, url string, secret string) error {
+	subscriptionKey, err := ref.key(c)
+	if err != nil {
+		return err
+	}
+
+	subscription := new(Subscription)
+	if err := datastore.Get(c, subscriptionKey, subscription); err != nil && !IsFieldMismatch(err) {
+		return err
+	}
+
+	subscription.WebhookURL = url
+	subscription.WebhookSecret = secret
+	if url == "" {
+		subscription.WebhookSecret = ""
 	}
 
-	if err := updateSubscriberCount(c, url, 1); err != nil {
-		c.Warningf("Error incrementing subscriber count: %s", err)
+	if _, err := datastore.Put(c, subscriptionKey, subscription); err != nil {
+		return err
 	}
 
-	return SubscriptionRef{
-		FolderRef: ref,
-		SubscriptionID: url,
-	}, nil
+	invalidateCachedSubscriptions(c, subscriptionKey)
+
+	return nil
 }
 
-func SubscriptionsAsOPML(c appengine.Context, userID UserID) (*rss.OPML, error) {
-	userKey, err := userID.key(c)
+// SetRefreshInterval sets the number of minutes between refreshes for
+// a subscription. Zero reverts to the feed's own schedule
+func SetRefreshInterval(c appengine.Context, ref SubscriptionRef, minutes int) error {
+	subscriptionKey, err := ref.key(c)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	opml := rss.NewOPML()
-	folderMap := map[string]*rss.Outline{}
-	q := datastore.NewQuery("Folder").Ancestor(userKey).Limit(defaultBatchSize)
+	subscription := new(Subscription)
+	if err := datastore.Get(c, subscriptionKey, subscription); err != nil && !IsFieldMismatch(err) {
+		return err
+	}
 
-	var folders []Folder
-	if folderKeys, err := q.GetAll(c, &folders); err != nil {
-		return nil, err
-	} else if folders != nil {
-		for i, folder := range folders {
-			opmlFolder := rss.NewFolder(folder.Title)
-			folderMap[folderKeys[i].String()] = opmlFolder
-			opml.Add(opmlFolder)
-		}
+	subscription.RefreshIntervalOverride = minutes
+	if _, err := datastore.Put(c, subscriptionKey, subscription); err != nil {
+		return err
 	}
 
-	q = datastore.NewQuery("Subscription").Ancestor(userKey).Limit(defaultBatchSize)
+	invalidateCachedSubscriptions(c, subscriptionKey)
 
-	var subscriptions []Subscription
-	if subscriptionKeys, err := q.GetAll(c, &subscriptions); err != nil {
-		return nil, err
-	} else {
-		feedKeys := make([]*datastore.Key, len(subscriptions))
-		for i, subscription := range subscriptions {
-			feedKeys[i] = subscription.Feed
-		}
+	feedKey := datastore.NewKey(c, "Feed", ref.SubscriptionID, 0, nil)
+	return updateRefreshIntervalOverride(c, feedKey)
+}
 
-		var multiError appengine.MultiError
-		feeds := make([]Feed, len(subscriptions))
+// updateRefreshIntervalOverride copies the shortest refresh interval 
+// requested by any of the subscribers to the feed's metadata
+func updateRefreshIntervalOverride(c appengine.Context, feedKey *datastore.Key) error {
+	override := 0
 
-		if err := datastore.GetMulti(c, feedKeys, feeds); err != nil {
-			if me, ok := err.(appengine.MultiError); ok {
-				multiError = me
-			} else {
-				return nil, err
-			}
+	var subscriptions []*Subscription
+	q := datastore.NewQuery("Subscription").Filter("Feed =", feedKey).Filter("RefreshIntervalOverride >", 0).Order("RefreshIntervalOverride").Limit(1)
+	if _, err := q.GetAll(c, &subscriptions); err != nil && !IsFieldMismatch(err) {
+		return err
+	} else if len(subscriptions) > 0 {
+		override = subscriptions[0].RefreshIntervalOverride
+	}
+
+	feedMetaKey := datastore.NewKey(c, "FeedMeta", feedKey.StringID(), 0, nil)
+	return datastore.RunInTransaction(c, func(c appengine.Context) error {
+		feedMeta := new(FeedMeta)
+		if err := datastore.Get(c, feedMetaKey, feedMeta); err == datastore.ErrNoSuchEntity {
+			return nil // Not yet fetched
+		} else if err != nil && !IsFieldMismatch(err) {
+			return err
 		}
 
-		for i, subscription := range subscriptions {
-			subscriptionKey := subscriptionKeys[i]
-			parentKey := subscriptionKey.Parent()
+		if feedMeta.RefreshIntervalOverride == override {
+			return nil
+		}
 
-			opmlSub := rss.NewSubscription(subscription.Title, subscriptionKey.StringID(), "")
-			if parentKey.Kind() != "Folder" {
-				opml.Add(opmlSub)
-			} else {
-				if folder := folderMap[parentKey.String()]; folder != nil {
-					folder.Add(opmlSub)
-				} else {
-					opml.Add(opmlSub) // Orphaned folder
-				}
-			}
+		computed := time.Duration(float64(feedMeta.HourlyUpdateFrequency) * float64(time.Hour))
 
-			if multiError == nil || multiError[i] == nil {
-				opmlSub.WebURL = feeds[i].Link
-			}
+		feedMeta.RefreshIntervalOverride = override
+		feedMeta.NextFetch = feedMeta.nextFetch(feedMeta.Fetched, computed)
+
+		if _, err := datastore.Put(c, feedMetaKey, feedMeta); err != nil {
+			return err
 		}
-	}
 
-	return &opml, nil
+		return nil
+	}, nil)
 }
 
-func Unsubscribe(c appengine.Context, ref SubscriptionRef) error {
-	subscriptionKey, err := ref.key(c)
+func DeleteArticlesWithinScope(c appengine.Context, scope ArticleScope) error {
+	ancestorKey, err := scope.key(c)
 	if err != nil {
 		return err
 	}
 
-	if err := datastore.Delete(c, subscriptionKey); err != nil {
-		return err
+	batchWriter := NewBatchWriter(c, BatchDelete)
+
+	q := datastore.NewQuery("Article").Ancestor(ancestorKey).KeysOnly()
+	for t := q.Run(c); ; {
+		articleKey, err := t.Next(nil)
+
+		if err == datastore.Done {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if err := batchWriter.EnqueueKey(articleKey); err != nil {
+			c.Errorf("Error queueing article for batch delete: %s", err)
+			return err
+		}
 	}
 
-	if err := updateSubscriberCount(c, ref.SubscriptionID, -1); err != nil {
-		c.Warningf("Error decrementing subscriber count: %s", err)
+	if err := batchWriter.Flush(); err != nil {
+		c.Errorf("Error flushing batch queue: %s", err)
+		return err
 	}
 
 	return nil
 }
 
-func DeleteArticlesWithinScope(c appengine.Context, scope ArticleScope) error {
+// PurgeReadArticles deletes read articles within scope, other than 
+// those starred, liked or tagged. Deletion stops once the deadline is 
+// reached, returning the position to resume from (in a later request)
+// along with the number deleted so far. The position is empty once 
+// all articles have been considered
+func PurgeReadArticles(c appengine.Context, scope ArticleScope, start string, deadline time.Time) (int, string, error) {
 	ancestorKey, err := scope.key(c)
 	if err != nil {
-		return err
+		return 0, "", err
+	}
+
+	q := datastore.NewQuery("Article").Ancestor(ancestorKey).Filter("Properties =", "read")
+	if start != "" {
+		if cursor, err := datastore.DecodeCursor(start); err != nil {
+			return 0, "", err
+		} else {
+			q = q.Start(cursor)
+		}
 	}
 
 	batchWriter := NewBatchWriter(c, BatchDelete)
+	continueFrom := ""
 
-	q := datastore.NewQuery("Article").Ancestor(ancestorKey).KeysOnly()
-	for t := q.Run(c); ; {
-		articleKey, err := t.Next(nil)
+	t := q.Run(c)
+	for {
+		if time.Now().After(deadline) {
+			if cursor, err := t.Cursor(); err != nil {
+				return 0, "", err
+			} else {
+				continueFrom = cursor.String()
+			}
+			break
+		}
+
+		article := new(Article)
+		articleKey, err := t.Next(article)
 
 		if err == datastore.Done {
 			break
+		} else if IsFieldMismatch(err) {
+			// Ignore - migration issue
 		} else if err != nil {
-			return err
+			c.Errorf("Error reading Article: %s", err)
+			return 0, "", err
+		}
+
+		if article.HasProperty("star") || article.HasProperty("like") || len(article.Tags) > 0 {
+			continue
 		}
 
 		if err := batchWriter.EnqueueKey(articleKey); err != nil {
 			c.Errorf("Error queueing article for batch delete: %s", err)
-			return err
+			return 0, "", err
 		}
 	}
 
 	if err := batchWriter.Flush(); err != nil {
 		c.Errorf("Error flushing batch queue: %s", err)
-		return err
+		return 0, "", err
 	}
 
-	return nil
+	return batchWriter.Written(), continueFrom, nil
 }
 
 func RemoveTag(c appengine.Context, userID UserID, tag string) error {
@@ -1054,11 +2809,52 @@ func RemoveTag(c appengine.Context, userID UserID, tag string) error {
 	return nil
 }
 
+// renameSubscriptions follows a change in the title of a feed, other 
+// than in subscriptions the user has named. Subscriptions from before 
+// names were told apart (see Subscription.TitleOverridden) are taken to
+// be named if their title isn't the feed's previous one
+func renameSubscriptions(c appengine.Context, feedKey *datastore.Key, previousTitle string, title string) error {
+	batchWriter := NewBatchWriter(c, BatchPut)
+
+	q := datastore.NewQuery("Subscription").Filter("Feed =", feedKey)
+	for t := q.Run(c); ; {
+		subscription := new(Subscription)
+		subscriptionKey, err := t.Next(subscription)
+
+		if err == datastore.Done {
+			break
+		} else if err != nil && !IsFieldMismatch(err) {
+			return err
+		}
+
+		if subscription.TitleOverridden {
+			continue
+		} else if subscription.Title == previousTitle {
+			subscription.Title = title
+		} else {
+			subscription.TitleOverridden = true
+		}
+
+		if err := batchWriter.Enqueue(subscriptionKey, subscription); err != nil {
+			return err
+		}
+
+		invalidateCachedSubscriptions(c, subscriptionKey)
+	}
+
+	return batchWriter.Flush()
+}
+
 func UpdateFeed(c appengine.Context, parsedFeed *rss.Feed, favIconURL string, fetched time.Time) error {
 	var updateCounter int64
 	var lastFetched time.Time
 
-	feedDigest := parsedFeed.Digest()
+	parsedEntries := newestEntries(parsedFeed.Entries, fetched, MaxEntriesPerUpdate)
+	if dropped := len(parsedFeed.Entries) - len(parsedEntries); dropped > 0 {
+		c.Infof("Discarding %d oldest entries of %s", dropped, parsedFeed.URL)
+	}
+
+	feedDigest := append(parsedFeed.Digest(), feedInfoVersion)
 	feedMeta := new(FeedMeta)
 	feedMetaKey := datastore.NewKey(c, "FeedMeta", parsedFeed.URL, 0, nil)
 	feedKey := datastore.NewKey(c, "Feed", parsedFeed.URL, 0, nil)
@@ -1087,9 +2883,13 @@ func UpdateFeed(c appengine.Context, parsedFeed *rss.Feed, favIconURL string, fe
 		lastFetched = feedMeta.Fetched
 
 		feedMeta.Fetched = fetched
-		feedMeta.NextFetch = fetched.Add(durationBetweenUpdates)
+		feedMeta.NextFetch = feedMeta.nextFetch(fetched, durationBetweenUpdates)
 		feedMeta.HourlyUpdateFrequency = float32(durationBetweenUpdates.Hours())
-		feedMeta.UpdateCounter += int64(len(parsedFeed.Entries))
+		feedMeta.UpdateCounter += int64(len(parsedEntries))
+		feedMeta.LastContentHash = parsedFeed.ContentHash
+		feedMeta.LastContentLength = parsedFeed.ContentLength
+		feedMeta.LastBuildDate = parsedFeed.Updated
+		feedMeta.FailureCount = 0
 
 		updateCounter = feedMeta.UpdateCounter
 
@@ -1149,6 +2949,12 @@ func UpdateFeed(c appengine.Context, parsedFeed *rss.Feed, favIconURL string, fe
 			feed.FavIconURL = favIconURL
 		}
 
+		if feed.Title != "" && parsedFeed.Title != "" && feed.Title != parsedFeed.Title {
+			if err := renameSubscriptions(c, feedKey, feed.Title, parsedFeed.Title); err != nil {
+				c.Warningf("Error renaming subscriptions to %s: %s", parsedFeed.URL, err)
+			}
+		}
+
 		feed.Title = parsedFeed.Title
 		feed.Description = parsedFeed.Description
 		feed.Updated = parsedFeed.Updated
@@ -1156,133 +2962,342 @@ func UpdateFeed(c appengine.Context, parsedFeed *rss.Feed, favIconURL string, fe
 		feed.Format = parsedFeed.Format
 		feed.HubURL = parsedFeed.HubURL
 		feed.Topic = parsedFeed.Topic
+		feed.SearchTerms = feedSearchTerms(feed.Title, feed.Link)
 
 		if _, err := datastore.Put(c, feedKey, feed); err != nil {
 			return err
 		}
 	}
 
-	batchSize := defaultBatchSize
-	elements := len(parsedFeed.Entries)
+	started := time.Now()
+	nuovo, unchanged, changed := 0, 0, 0
+
+	_, err = writeEntries(c, feedMeta.Feed, parsedEntries, func(parsedEntry *rss.Entry, entryMeta *EntryMeta, err error) (bool, error) {
+		entryDigest := parsedEntry.Digest()
+		if err == datastore.ErrNoSuchEntity {
+			// New; set defaults
+			entryMeta.InfoDigest = entryDigest
+			nuovo++
+		} else if err == nil || IsFieldMismatch(err) {
+			if !bytes.Equal(entryMeta.InfoDigest, entryDigest) {
+				entryMeta.InfoDigest = entryDigest
+				changed++
+			} else {
+				// No updates - skip
+				unchanged++
+				return false, nil
+			}
+		} else {
+			// Some other error
+			c.Warningf("Error getting entry meta (GUID '%s'): %s", parsedEntry.UniqueID(), err)
+			return false, nil
+		}
+
+		entryMeta.Published, entryMeta.OriginalPublished = clampPublished(*entryMeta, parsedEntry.Published, fetched)
+		entryMeta.Author = normalizeAuthor(parsedEntry.Author)
+		entryMeta.Fingerprint = entryFingerprint(parsedEntry.WWWURL, parsedEntry.Title)
+		entryMeta.ContentDigest = parsedEntry.ContentDigest()
+		entryMeta.Fetched = fetched
+		entryMeta.UpdateIndex = updateCounter
+		updateCounter++
+
+		// At this point, metadata tells us the record needs updating, 
+		// so everything in the entry is overwritten
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
 
-	entryKeys := make([]*datastore.Key, batchSize)
-	entries := make([]*Entry, batchSize)
+	c.Debugf("Completed %s: %d,%d,%d (n,c,u) (took %s, last fetch: %s ago)", 
+		parsedFeed.URL, nuovo, changed, unchanged, time.Since(started), time.Since(lastFetched))
 
-	entryMetaKeys := make([]*datastore.Key, batchSize)
-	entryMetas := make([]*EntryMeta, batchSize)
+	return nil
+}
 
-	pending := 0
-	written := 0
+// clampPublished returns the publication date to store for an entry,
+// along with the date the feed claimed, if it was too far in the 
+// future to use. An entry that was clamped before keeps its original
+// clamped date, so it doesn't move up with every fetch
+func clampPublished(entryMeta EntryMeta, published time.Time, fetched time.Time) (time.Time, time.Time) {
+	if MaxPublishedSkew <= 0 || !published.After(fetched.Add(MaxPublishedSkew)) {
+		return published, time.Time{}
+	} else if entryMeta.OriginalPublished.Equal(published) && !entryMeta.Published.IsZero() {
+		return entryMeta.Published, published
+	}
 
-	started := time.Now()
-	nuovo, unchanged, changed := 0, 0, 0
+	return fetched, published
+}
 
-	for i := 0; ; i++ {
-		if i >= elements || pending + 1 >= batchSize {
-			if pending > 0 {
-				if _, err := datastore.PutMulti(c, entryKeys[:pending], entries[:pending]); err != nil {
-					if multiError, ok := err.(appengine.MultiError); ok {
-						for i, err := range multiError {
-							if err != nil {
-								c.Errorf("entry[%d]: key [%s] failed: %s", i, 
-									entryKeys[i].StringID(), err)
-							}
-						}
-					}
-					// FIXME: don't stop the entire write simply because a few entries failed
-					return err
-				}
-				if _, err := datastore.PutMulti(c, entryMetaKeys[:pending], entryMetas[:pending]); err != nil {
-					if multiError, ok := err.(appengine.MultiError); ok {
-						for i, err := range multiError {
-							if err != nil {
-								c.Errorf("entryMeta[%d]: [%s] failed: %s", i, 
-									entryMetaKeys[i].StringID(), err)
-							}
-						}
-					}
-					// FIXME: don't stop the entire write simply because a few entries failed
-					return err
+// newEntry copies the displayed fields of a parsed entry. Media are 
+// stored separately
+func newEntry(parsedEntry *rss.Entry) Entry {
+	entry := Entry {
+		Author: html.UnescapeString(parsedEntry.Author),
+		Title: parsedEntry.Title,
+		Link: parsedEntry.WWWURL,
+		Summary: parsedEntry.Summary(),
+		Content: parsedEntry.Content,
+		Updated: parsedEntry.Updated,
+		PublishedRaw: parsedEntry.PublishedRaw,
+		CommentsURL: parsedEntry.CommentsURL,
+		CommentCount: parsedEntry.CommentCount,
+		SourceTitle: parsedEntry.SourceTitle,
+		SourceURL: parsedEntry.SourceURL,
+		ReadingTimeSeconds: int(parsedEntry.ReadingTime().Seconds()),
+	}
+
+	if StoreContentText {
+		entry.ContentText = rss.PlainText(parsedEntry.Content)
+	}
+
+	return entry
+}
+
+// ReparseEntries rewrites the stored entries of a feed from a fresh
+// parse, without touching the feed's update counter. Since the update
+// index and fetch time of each entry are kept, subscribers see the
+// corrected content but articles don't resurface as new, and read
+// and star state is left alone. Entries not already stored are 
+// skipped. Returns the number of entries rewritten
+func ReparseEntries(c appengine.Context, parsedFeed *rss.Feed) (int, error) {
+	feedKey := datastore.NewKey(c, "Feed", parsedFeed.URL, 0, nil)
+
+	return writeEntries(c, feedKey, parsedFeed.Entries, func(parsedEntry *rss.Entry, entryMeta *EntryMeta, err error) (bool, error) {
+		if err == datastore.ErrNoSuchEntity {
+			return false, nil // Never stored; left for the next update
+		} else if err != nil && !IsFieldMismatch(err) {
+			return false, err
+		}
+
+		// Digests are refreshed so that the next update doesn't see
+		// the corrected entry as changed
+		entryMeta.InfoDigest = parsedEntry.Digest()
+		entryMeta.ContentDigest = parsedEntry.ContentDigest()
+		entryMeta.Author = normalizeAuthor(parsedEntry.Author)
+		entryMeta.Fingerprint = entryFingerprint(parsedEntry.WWWURL, parsedEntry.Title)
+
+		return true, nil
+	})
+}
+
+// writeEntries writes parsed entries of a feed, along with their 
+// metadata, reading the metadata of each batch of entries at once. 
+// update is handed the stored metadata of each entry (err being the
+// result of reading it) to bring up to date, and decides whether the 
+// entry is written; an error stops the writing. Entries are written 
+// before their metadata, so that an entry that fails to write is 
+// rewritten on the next update. Returns the number of entries written
+func writeEntries(c appengine.Context, feedKey *datastore.Key, parsedEntries []*rss.Entry, update func(*rss.Entry, *EntryMeta, error) (bool, error)) (int, error) {
+	entryWriter := NewBatchWriter(c, BatchPut)
+	entryMetaWriter := NewBatchWriter(c, BatchPut)
+
+	for batchStart := 0; batchStart < len(parsedEntries); batchStart += defaultBatchSize {
+		batchEnd := batchStart + defaultBatchSize
+		if batchEnd > len(parsedEntries) {
+			batchEnd = len(parsedEntries)
+		}
+
+		batch := make([]*rss.Entry, 0, batchEnd - batchStart)
+		entryMetaKeys := make([]*datastore.Key, 0, batchEnd - batchStart)
+		for _, parsedEntry := range parsedEntries[batchStart:batchEnd] {
+			if entryGUID := parsedEntry.UniqueID(); entryGUID == "" {
+				c.Warningf("Missing GUID for an entry titled '%s'", parsedEntry.Title)
+			} else {
+				batch = append(batch, parsedEntry)
+				entryMetaKeys = append(entryMetaKeys, datastore.NewKey(c, "EntryMeta", entryGUID, 0, feedKey))
+			}
+		}
+
+		entryMetas := make([]EntryMeta, len(batch))
+		entryMetaErrors := make([]error, len(batch))
+		if err := datastore.GetMulti(c, entryMetaKeys, entryMetas); err != nil {
+			if multiError, ok := err.(appengine.MultiError); ok {
+				entryMetaErrors = multiError
+			} else {
+				return entryWriter.Written(), err
+			}
+		}
+
+		for i, parsedEntry := range batch {
+			entryMetaKey := entryMetaKeys[i]
+			entryKey := datastore.NewKey(c, "Entry", entryMetaKey.StringID(), 0, feedKey)
+			entryMeta := &entryMetas[i]
+
+			if write, err := update(parsedEntry, entryMeta, entryMetaErrors[i]); err != nil {
+				return entryWriter.Written(), err
+			} else if !write {
+				continue
+			}
+			entryMeta.Entry = entryKey
+
+			entry := newEntry(parsedEntry)
+			if len(parsedEntry.Media) > 0 {
+				if err := UpdateMedia(c, entryKey, parsedEntry); err != nil {
+					c.Warningf("Error writing media for entry: %s", err)
+				} else {
+					entry.HasMedia = true
 				}
 			}
 
-			written += pending
-			pending = 0
+			if err := entryWriter.Enqueue(entryKey, &entry); err != nil {
+				c.Errorf("Error writing entries: %s", err)
+				return entryWriter.Written(), err
+			}
+			if err := entryMetaWriter.Enqueue(entryMetaKey, entryMeta); err != nil {
+				c.Errorf("Error writing entry metadata: %s", err)
+				return entryWriter.Written(), err
+			}
+		}
+	}
+
+	if err := entryWriter.Flush(); err != nil {
+		c.Errorf("Error writing entries: %s", err)
+		return entryWriter.Written(), err
+	}
+	if err := entryMetaWriter.Flush(); err != nil {
+		c.Errorf("Error writing entry metadata: %s", err)
+		return entryWriter.Written(), err
+	}
+
+	return entryWriter.Written(), nil
+}
+
+// RescheduleFeed records a fetch of a feed whose entries haven't 
+// changed since the last update, and schedules the next fetch without
+// touching any of them. The content fetched is recorded too (unless 
+// parsedFeed is nil), so that it's recognized the next time
+func RescheduleFeed(c appengine.Context, url string, parsedFeed *rss.Feed, fetched time.Time) error {
+	feedMetaKey := datastore.NewKey(c, "FeedMeta", url, 0, nil)
+
+	return datastore.RunInTransaction(c, func(c appengine.Context) error {
+		feedMeta := new(FeedMeta)
+		if err := datastore.Get(c, feedMetaKey, feedMeta); err != nil && !IsFieldMismatch(err) {
+			return err
+		}
+
+		durationBetweenUpdates := time.Duration(float64(feedMeta.HourlyUpdateFrequency) * float64(time.Hour))
+
+		feedMeta.Fetched = fetched
+		feedMeta.NextFetch = feedMeta.nextFetch(fetched, durationBetweenUpdates)
+		feedMeta.FailureCount = 0
+		if parsedFeed != nil {
+			feedMeta.LastContentHash = parsedFeed.ContentHash
+			feedMeta.LastContentLength = parsedFeed.ContentLength
+		}
+
+		if _, err := datastore.Put(c, feedMetaKey, feedMeta); err != nil {
+			return err
+		}
+
+		return nil
+	}, nil)
+}
+
+// RecordFeedFailure notes an unsuccessful fetch of a feed. The failure 
+// count is reset on the next successful update
+func RecordFeedFailure(c appengine.Context, url string, failed time.Time) error {
+	feedMetaKey := datastore.NewKey(c, "FeedMeta", url, 0, nil)
+
+	return datastore.RunInTransaction(c, func(c appengine.Context) error {
+		feedMeta := new(FeedMeta)
+		if err := datastore.Get(c, feedMetaKey, feedMeta); err == datastore.ErrNoSuchEntity {
+			return nil // Never successfully fetched; nothing to track
+		} else if err != nil && !IsFieldMismatch(err) {
+			return err
+		}
+
+		feedMeta.FailureCount++
+		feedMeta.LastFailure = failed
+
+		if _, err := datastore.Put(c, feedMetaKey, feedMeta); err != nil {
+			return err
+		}
+
+		return nil
+	}, nil)
+}
+
+// DeferFeed records a fetch refused by the publisher for rate-limiting
+// reasons, and puts off the next fetch until the time they specified
+func DeferFeed(c appengine.Context, url string, failed time.Time, retryAfter time.Time) error {
+	feedMetaKey := datastore.NewKey(c, "FeedMeta", url, 0, nil)
 
-			if i >= elements {
-				break
-			}
+	return datastore.RunInTransaction(c, func(c appengine.Context) error {
+		feedMeta := new(FeedMeta)
+		if err := datastore.Get(c, feedMetaKey, feedMeta); err == datastore.ErrNoSuchEntity {
+			return nil // Never successfully fetched; nothing to track
+		} else if err != nil && !IsFieldMismatch(err) {
+			return err
 		}
 
-		parsedEntry := parsedFeed.Entries[i]
-		entryGUID := parsedEntry.UniqueID()
-		if entryGUID == "" {
-			c.Warningf("Missing GUID for an entry titled '%s'", parsedEntry.Title)
-			continue
+		feedMeta.FailureCount++
+		feedMeta.LastFailure = failed
+		feedMeta.RetryAfter = retryAfter
+		if feedMeta.NextFetch.Before(retryAfter) {
+			feedMeta.NextFetch = retryAfter
 		}
 
-		entryMetaKey := datastore.NewKey(c, "EntryMeta", entryGUID, 0, feedMeta.Feed)
-		entryKey := datastore.NewKey(c, "Entry", entryGUID, 0, feedMeta.Feed)
-		entryDigest := parsedEntry.Digest()
-		var entryMeta EntryMeta
-
-		if err := datastore.Get(c, entryMetaKey, &entryMeta); err == datastore.ErrNoSuchEntity {
-			// New; set defaults
-			entryMeta.Entry = entryKey
-			entryMeta.InfoDigest = entryDigest
-			nuovo++
-		} else if err == nil || IsFieldMismatch(err) {
-			if !bytes.Equal(entryMeta.InfoDigest, entryDigest) {
-				entryMeta.InfoDigest = entryDigest
-				changed++
-			} else {
-				// No updates - skip
-				unchanged++
-				continue
-			}
-		} else {
-			// Some other error
-			c.Warningf("Error getting entry meta (GUID '%s'): %s", entryGUID, err)
-			continue
+		if _, err := datastore.Put(c, feedMetaKey, feedMeta); err != nil {
+			return err
 		}
 
-		entryMeta.Published = parsedEntry.Published
-		entryMeta.Fetched = fetched
-		entryMeta.UpdateIndex = updateCounter
-
-		// At this point, metadata tells us the record needs updating, so we 
-		// just overwrite everything in the entry
+		return nil
+	}, nil)
+}
 
-		entry := Entry {
-			Author: html.UnescapeString(parsedEntry.Author),
-			Title: html.UnescapeString(parsedEntry.Title),
-			Link: parsedEntry.WWWURL,
-			Summary: parsedEntry.Summary(),
-			Content: parsedEntry.Content,
-			Updated: parsedEntry.Updated,
-		}
+// NewInstanceStats aggregates instance-wide counts. Since these require 
+// full scans, the result is cached for instanceStatsCacheDuration
+func NewInstanceStats(c appengine.Context) (*InstanceStats, error) {
+	stats := new(InstanceStats)
+	if _, err := memcache.JSON.Get(c, instanceStatsCacheKey, stats); err == nil {
+		return stats, nil
+	} else if err != memcache.ErrCacheMiss {
+		c.Warningf("Error reading cached stats: %s", err)
+	}
 
-		if len(parsedEntry.Media) > 0 {
-			if err := UpdateMedia(c, entryKey, parsedEntry); err != nil {
-				c.Warningf("Error writing media for entry: %s")
-			} else {
-				entry.HasMedia = true
-			}
-		}
+	var err error
+	if stats.Users, err = datastore.NewQuery("User").KeysOnly().Count(c); err != nil {
+		return nil, err
+	}
+	if stats.Feeds, err = datastore.NewQuery("Feed").KeysOnly().Count(c); err != nil {
+		return nil, err
+	}
+	if stats.Subscriptions, err = datastore.NewQuery("Subscription").KeysOnly().Count(c); err != nil {
+		return nil, err
+	}
+	if stats.FailingFeeds, err = datastore.NewQuery("FeedMeta").Filter("FailureCount >", 0).KeysOnly().Count(c); err != nil {
+		return nil, err
+	}
 
-		entryMetas[pending] = &entryMeta
-		entryMetaKeys[pending] = entryMetaKey
-		entries[pending] = &entry
-		entryKeys[pending] = entryKey
+	feedMeta := new(FeedMeta)
+	q := datastore.NewQuery("FeedMeta").Order("-Fetched").Limit(1)
+	if _, err := q.Run(c).Next(feedMeta); err == nil || IsFieldMismatch(err) {
+		stats.LastFeedUpdate = feedMeta.Fetched
+	} else if err != datastore.Done {
+		return nil, err
+	}
 
-		updateCounter++
-		pending++
+	user := new(User)
+	q = datastore.NewQuery("User").Order("-LastSubscriptionUpdate").Limit(1)
+	if _, err := q.Run(c).Next(user); err == nil || IsFieldMismatch(err) {
+		stats.LastSubscriptionUpdate = user.LastSubscriptionUpdate
+	} else if err != datastore.Done {
+		return nil, err
 	}
 
-	c.Debugf("Completed %s: %d,%d,%d (n,c,u) (took %s, last fetch: %s ago)", 
-		parsedFeed.URL, nuovo, changed, unchanged, time.Since(started), time.Since(lastFetched))
+	stats.Generated = time.Now()
 
-	return nil
+	item := &memcache.Item {
+		Key: instanceStatsCacheKey,
+		Object: stats,
+		Expiration: instanceStatsCacheDuration,
+	}
+	if err := memcache.JSON.Set(c, item); err != nil {
+		c.Warningf("Error caching stats: %s", err)
+	}
+
+	return stats, nil
 }
 
 func MediaForEntry(c appengine.Context, entryKey *datastore.Key) ([]*EntryMedia, error) {
@@ -1342,7 +3357,11 @@ func UpdateMedia(c appengine.Context, entryKey *datastore.Key, entry *rss.Entry)
 	return nil
 }
 
-func UpdateSubscription(c appengine.Context, url string, ref SubscriptionRef) (int, error) {
+// UpdateSubscription adds the new entries of a feed to a subscription.
+// muteWords are the owner's (see User.MuteWords), passed in since the 
+// caller has usually loaded the user already. With markRead, the 
+// entries are added as read
+func UpdateSubscription(c appengine.Context, url string, ref SubscriptionRef, muteWords []string, markRead bool) (int, error) {
 	subscriptionKey, err := ref.key(c)
 	if err != nil {
 		return 0, err
@@ -1354,10 +3373,10 @@ func UpdateSubscription(c appengine.Context, url string, ref SubscriptionRef) (i
 		return 0, err
 	}
 
-	return updateSubscriptionByKey(c, subscriptionKey, subscription)
+	return updateSubscriptionByKey(c, subscriptionKey, subscription, muteWords, markRead)
 }
 
-func UpdateAllSubscriptions(c appengine.Context, userID UserID) error {
+func UpdateAllSubscriptions(c appengine.Context, userID UserID, muteWords []string) error {
 	userKey, err := userID.key(c)
 	if err != nil {
 		return err
@@ -1373,10 +3392,13 @@ func UpdateAllSubscriptions(c appengine.Context, userID UserID) error {
 
 	started := time.Now()
 	doneChannel := make(chan Subscription)
-	subscriptionCount := len(subscriptions)
+	subscriptionCount := 0
 
-	for i := 0; i < subscriptionCount; i++ {
-		go updateSubscriptionAsync(c, subscriptionKeys[i], subscriptions[i], doneChannel)
+	for i, subscription := range subscriptions {
+		if !subscription.Paused {
+			go updateSubscriptionAsync(c, subscriptionKeys[i], subscription, muteWords, doneChannel)
+			subscriptionCount++
+		}
 	}
 
 	for i := 0; i < subscriptionCount; i++ {
@@ -1388,8 +3410,129 @@ func UpdateAllSubscriptions(c appengine.Context, userID UserID) error {
 	return nil
 }
 
+// UpdateFeedSubscriptions adds the feed's new entries to every 
+// (unpaused) subscription to it, across users. Each subscription is
+// matched against its owner's mute words. Returns the number of 
+// subscriptions updated
+func UpdateFeedSubscriptions(c appengine.Context, feedURL string) (int, error) {
+	feedKey := datastore.NewKey(c, "Feed", feedURL, 0, nil)
+	muteWordsByUser := make(map[string][]string)
+	updated := 0
+
+	q := datastore.NewQuery("Subscription").Filter("Feed =", feedKey)
+	for t := q.Run(c); ; {
+		subscription := Subscription{}
+		subscriptionKey, err := t.Next(&subscription)
+
+		if err == datastore.Done {
+			break
+		} else if err != nil && !IsFieldMismatch(err) {
+			return updated, err
+		} else if subscription.Paused {
+			continue
+		}
+
+		userKey := subscriptionKey
+		for userKey.Parent() != nil {
+			userKey = userKey.Parent()
+		}
+
+		muteWords, ok := muteWordsByUser[userKey.StringID()]
+		if !ok {
+			if user, err := UserByID(c, UserID(userKey.StringID())); err != nil {
+				return updated, err
+			} else if user != nil {
+				muteWords = user.MuteWords
+			}
+			muteWordsByUser[userKey.StringID()] = muteWords
+		}
+
+		if _, err := updateSubscriptionByKey(c, subscriptionKey, subscription, muteWords, false); err != nil {
+			c.Errorf("Error updating subscription %s: %s", subscription.Title, err)
+			continue
+		}
+		updated++
+	}
+
+	return updated, nil
+}
+
+// FeedsToRefresh returns the URLs of the feeds behind the user's 
+// (unpaused) subscriptions, each only once. Feeds fetched after 
+// fetchedSince, or whose publisher has asked us to wait, are skipped
+func FeedsToRefresh(c appengine.Context, userID UserID, fetchedSince time.Time) ([]string, error) {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var subscriptions []Subscription
+	q := datastore.NewQuery("Subscription").Ancestor(userKey).Limit(defaultBatchSize)
+	if _, err := q.GetAll(c, &subscriptions); err != nil && !IsFieldMismatch(err) {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	feedMetaKeys := make([]*datastore.Key, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		if subscription.Paused || subscription.Feed == nil {
+			continue
+		}
+
+		url := subscription.Feed.StringID()
+		if !seen[url] {
+			seen[url] = true
+			feedMetaKeys = append(feedMetaKeys, datastore.NewKey(c, "FeedMeta", url, 0, nil))
+		}
+	}
+
+	feedMetas := make([]FeedMeta, len(feedMetaKeys))
+	if err := datastore.GetMulti(c, feedMetaKeys, feedMetas); err != nil {
+		if multiError, ok := err.(appengine.MultiError); ok {
+			for _, err := range multiError {
+				if err != nil && err != datastore.ErrNoSuchEntity && !IsFieldMismatch(err) {
+					return nil, err
+				}
+			}
+		} else {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	urls := make([]string, 0, len(feedMetaKeys))
+	for i, feedMeta := range feedMetas {
+		if feedMeta.Fetched.After(fetchedSince) || feedMeta.RetryAfter.After(now) {
+			continue
+		}
+
+		urls = append(urls, feedMetaKeys[i].StringID())
+	}
+
+	return urls, nil
+}
+
+// FeedMetaByURL returns the fetch schedule of a feed, or nil if the 
+// feed is unknown
+func FeedMetaByURL(c appengine.Context, url string) (*FeedMeta, error) {
+	feedMetaKey := datastore.NewKey(c, "FeedMeta", url, 0, nil)
+
+	feedMeta := new(FeedMeta)
+	if err := datastore.Get(c, feedMetaKey, feedMeta); err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	} else if err != nil && !IsFieldMismatch(err) {
+		return nil, err
+	}
+
+	return feedMeta, nil
+}
+
 func AreNewEntriesAvailable(c appengine.Context, subscriptions []Subscription) (bool, error) {
 	for _, subscription := range subscriptions {
+		if subscription.Paused {
+			continue
+		}
+
 		q := datastore.NewQuery("EntryMeta").Ancestor(subscription.Feed).Filter("UpdateIndex >", subscription.MaxUpdateIndex).KeysOnly().Limit(1)
 		if entryMetaKeys, err := q.GetAll(c, nil); err != nil {
 			return false, err
@@ -1414,6 +3557,9 @@ func UpdateUnreadCounts(c appengine.Context, ch chan<- Subscription, subscriptio
 			c.Errorf("Error writing unread count: %s", err)
 			goto done
 		}
+
+		adjustCachedUnreadCount(c, subscriptionKey, count - originalSubscriptionCount)
+		invalidateCachedSubscriptions(c, subscriptionKey)
 	}
 
 	if originalSubscriptionCount != subscription.UnreadCount {
@@ -1469,6 +3615,253 @@ func (article Article) updateLikeCount(c appengine.Context, delta int) error {
 	return nil
 }
 
+func (article Article) updateStarCount(c appengine.Context, delta int) error {
+	err := datastore.RunInTransaction(c, func(c appengine.Context) error {
+		shardName := fmt.Sprintf("%s#%d", 
+			article.Entry.StringID(), rand.Intn(starCountShards))
+		key := datastore.NewKey(c, "StarCountShard", shardName, 0, nil)
+
+		var shard starCountShard
+		if err := datastore.Get(c, key, &shard); err == datastore.ErrNoSuchEntity {
+			shard.Entry = article.Entry
+		} else if err != nil {
+			return err
+		}
+
+		shard.StarCount += delta
+		_, err := datastore.Put(c, key, &shard)
+
+		return err
+	}, nil)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// countShardKeys returns the keys of all of the counter shards of an 
+// entry. Reading the shards by key, rather than by query, includes 
+// the one just written
+func countShardKeys(c appengine.Context, kind string, entryKey *datastore.Key, shards int) []*datastore.Key {
+	keys := make([]*datastore.Key, shards)
+	for i := range keys {
+		shardName := fmt.Sprintf("%s#%d", entryKey.StringID(), i)
+		keys[i] = datastore.NewKey(c, kind, shardName, 0, nil)
+	}
+
+	return keys
+}
+
+// consolidateEntryCounts totals the like and star counter shards of an
+// entry, recording the totals with the entry's metadata so entries can
+// be ordered by them. The shards remain the authoritative counts
+func consolidateEntryCounts(c appengine.Context, entryKey *datastore.Key) error {
+	getShards := func(keys []*datastore.Key, shards interface{}) error {
+		if err := datastore.GetMulti(c, keys, shards); err != nil {
+			if multiError, ok := err.(appengine.MultiError); ok {
+				for _, err := range multiError {
+					if err != nil && err != datastore.ErrNoSuchEntity {
+						return err
+					}
+				}
+			} else {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	likeCount := 0
+	likeShards := make([]likeCountShard, likeCountShards)
+	if err := getShards(countShardKeys(c, "LikeCountShard", entryKey, likeCountShards), likeShards); err != nil {
+		return err
+	}
+	for _, shard := range likeShards {
+		// Shards are named by GUID, which other feeds may share
+		if shard.Entry != nil && shard.Entry.Equal(entryKey) {
+			likeCount += shard.LikeCount
+		}
+	}
+
+	starCount := 0
+	starShards := make([]starCountShard, starCountShards)
+	if err := getShards(countShardKeys(c, "StarCountShard", entryKey, starCountShards), starShards); err != nil {
+		return err
+	}
+	for _, shard := range starShards {
+		if shard.Entry != nil && shard.Entry.Equal(entryKey) {
+			starCount += shard.StarCount
+		}
+	}
+
+	entryMetaKey := datastore.NewKey(c, "EntryMeta", entryKey.StringID(), 0, entryKey.Parent())
+
+	return datastore.RunInTransaction(c, func(c appengine.Context) error {
+		entryMeta := new(EntryMeta)
+		if err := datastore.Get(c, entryMetaKey, entryMeta); err == datastore.ErrNoSuchEntity {
+			return nil
+		} else if err != nil && !IsFieldMismatch(err) {
+			return err
+		}
+
+		if entryMeta.LikeCount == likeCount && entryMeta.StarCount == starCount {
+			return nil
+		}
+
+		entryMeta.LikeCount = likeCount
+		entryMeta.StarCount = starCount
+		_, err := datastore.Put(c, entryMetaKey, entryMeta)
+
+		return err
+	}, nil)
+}
+
+// popularEntry is an entry liked by subscribers to its feed, as seen
+// through one of the user's subscriptions
+type popularEntry struct {
+	subscriptionKey *datastore.Key
+	entryMeta EntryMeta
+}
+
+// byPopularity orders entries by likes, then stars, most first
+type byPopularity []popularEntry
+
+func (p byPopularity) Len() int {
+	return len(p)
+}
+
+func (p byPopularity) Less(i, j int) bool {
+	if p[i].entryMeta.LikeCount != p[j].entryMeta.LikeCount {
+		return p[i].entryMeta.LikeCount > p[j].entryMeta.LikeCount
+	}
+
+	return p[i].entryMeta.StarCount > p[j].entryMeta.StarCount
+}
+
+func (p byPopularity) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+}
+
+// PopularArticles returns the user's articles within the folder (or 
+// all folders) that are most liked by all subscribers to their feeds,
+// most liked first
+func PopularArticles(c appengine.Context, ref FolderRef) (*ArticlePage, error) {
+	scopeKey, err := ref.key(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var subscriptions []Subscription
+	q := datastore.NewQuery("Subscription").Ancestor(scopeKey).Limit(defaultBatchSize)
+	subscriptionKeys, err := q.GetAll(c, &subscriptions)
+	if err != nil && !IsFieldMismatch(err) {
+		return nil, err
+	}
+
+	type feedResult struct {
+		entries []popularEntry
+		err error
+	}
+
+	// Each feed's most liked entries, read concurrently
+	doneChannel := make(chan feedResult)
+	for i, subscription := range subscriptions {
+		go func(subscriptionKey *datastore.Key, feedKey *datastore.Key) {
+			var entryMetas []EntryMeta
+			q := datastore.NewQuery("EntryMeta").Ancestor(feedKey).Filter("LikeCount >", 0).Order("-LikeCount").Limit(articlePageSize)
+			if _, err := q.GetAll(c, &entryMetas); err != nil && !IsFieldMismatch(err) {
+				doneChannel<- feedResult { err: err }
+				return
+			}
+
+			entries := make([]popularEntry, len(entryMetas))
+			for i, entryMeta := range entryMetas {
+				entries[i] = popularEntry { subscriptionKey, entryMeta }
+			}
+			doneChannel<- feedResult { entries: entries }
+		}(subscriptionKeys[i], subscription.Feed)
+	}
+
+	var popular []popularEntry
+	var feedErr error
+	for i := 0; i < len(subscriptions); i++ {
+		result := <-doneChannel
+		if result.err != nil {
+			feedErr = result.err
+		}
+		popular = append(popular, result.entries...)
+	}
+
+	if feedErr != nil {
+		return nil, feedErr
+	}
+
+	sort.Stable(byPopularity(popular))
+	if len(popular) > articlePageSize {
+		popular = popular[:articlePageSize]
+	}
+
+	articleKeys := make([]*datastore.Key, len(popular))
+	for i, entry := range popular {
+		articleKeys[i] = datastore.NewKey(c, "Article", entry.entryMeta.Entry.StringID(), 0, entry.subscriptionKey)
+	}
+
+	articles := make([]Article, len(articleKeys))
+	articleErrors := make([]error, len(articleKeys))
+	if err := datastore.GetMulti(c, articleKeys, articles); err != nil {
+		if multiError, ok := err.(appengine.MultiError); ok {
+			articleErrors = multiError
+		} else {
+			return nil, err
+		}
+	}
+
+	page := &ArticlePage {
+		Articles: make([]Article, 0, len(articles)),
+	}
+	var entryKeys []*datastore.Key
+	for i, article := range articles {
+		if err := articleErrors[i]; err == datastore.ErrNoSuchEntity {
+			continue // Purged, or stored before the subscription
+		} else if err != nil && !IsFieldMismatch(err) {
+			return nil, err
+		}
+
+		article.ID = article.Entry.StringID()
+		article.Source = article.Entry.Parent().StringID()
+		article.TotalLikes = popular[i].entryMeta.LikeCount
+		article.TotalStars = popular[i].entryMeta.StarCount
+		if article.Tags == nil {
+			article.Tags = make([]string, 0)
+		}
+
+		page.Articles = append(page.Articles, article)
+		entryKeys = append(entryKeys, article.Entry)
+	}
+
+	entries := make([]Entry, len(entryKeys))
+	if err := datastore.GetMulti(c, entryKeys, entries); err != nil {
+		if multiError, ok := err.(appengine.MultiError); ok {
+			for _, singleError := range multiError {
+				if singleError != nil && !IsFieldMismatch(singleError) {
+					return nil, err
+				}
+			}
+		} else {
+			return nil, err
+		}
+	}
+
+	for i := range page.Articles {
+		page.Articles[i].Details = &entries[i]
+	}
+
+	return page, nil
+}
+
 func consolidatedSubscriberCount(c appengine.Context, feedKey *datastore.Key) (int, error) {
 	count := 0
 	q := datastore.NewQuery("SubscriberCountShard").Filter("Feed =", feedKey)
@@ -1511,6 +3904,29 @@ func updateSubscriberCount(c appengine.Context, feedURL string, delta int) error
 	return nil
 }
 
+// SetFullContent caches the body of an article, as extracted from its
+// web page. The content from the feed is kept as it is
+func SetFullContent(c appengine.Context, ref ArticleRef, fullContent string) error {
+	articleKey, err := ref.key(c)
+	if err != nil {
+		return err
+	}
+
+	return datastore.RunInTransaction(c, func(c appengine.Context) error {
+		article := new(Article)
+		if err := datastore.Get(c, articleKey, article); err != nil && !IsFieldMismatch(err) {
+			return err
+		}
+
+		article.FullContent = fullContent
+		if _, err := datastore.Put(c, articleKey, article); err != nil {
+			return err
+		}
+
+		return nil
+	}, nil)
+}
+
 func LoadArticleExtras(c appengine.Context, ref ArticleRef) (ArticleExtras, error) {
 	articleKey, err := ref.key(c)
 	if err != nil {
@@ -0,0 +1,71 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormalizeFolderTitle(t *testing.T) {
+	if normalizeFolderTitle("News") != normalizeFolderTitle("  news ") {
+		t.Errorf("expected titles differing in case and space to match")
+	}
+	if normalizeFolderTitle("News") == normalizeFolderTitle("New s") {
+		t.Errorf("expected titles differing in inner space not to match")
+	}
+	if title := normalizeFolderTitle("\tÉcole\n"); title != "école" {
+		t.Errorf("unexpected normalized title %q", title)
+	}
+}
+
+func TestClampPublished(t *testing.T) {
+	fetched := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	future := fetched.AddDate(1, 0, 0)
+
+	// Reasonable dates are kept
+	if published, original := clampPublished(EntryMeta{}, fetched.Add(-time.Hour), fetched); !published.Equal(fetched.Add(-time.Hour)) || !original.IsZero() {
+		t.Errorf("expected a past date to be kept, got %s (original %s)", published, original)
+	}
+
+	// A year ahead is clamped to the fetch time
+	published, original := clampPublished(EntryMeta{}, future, fetched)
+	if !published.Equal(fetched) || !original.Equal(future) {
+		t.Errorf("expected %s clamped to %s, got %s (original %s)", future, fetched, published, original)
+	}
+
+	// Already clamped - the earlier clamped date stays put
+	entryMeta := EntryMeta {
+		Published: published,
+		OriginalPublished: original,
+	}
+	if published, original := clampPublished(entryMeta, future, fetched.Add(time.Hour)); !published.Equal(fetched) || !original.Equal(future) {
+		t.Errorf("expected the clamped date %s to stay, got %s (original %s)", fetched, published, original)
+	}
+
+	// A different future date is clamped anew
+	if published, _ := clampPublished(entryMeta, future.Add(time.Hour), fetched.Add(time.Hour)); !published.Equal(fetched.Add(time.Hour)) {
+		t.Errorf("expected a changed date to be clamped anew, got %s", published)
+	}
+}
@@ -0,0 +1,403 @@
+/*****************************************************************************
+ **
+ ** Gofr
+ ** https://github.com/pokebyte/Gofr
+ ** Copyright (C) 2013-2017 Akop Karapetyan
+ **
+ ** This program is free software; you can redistribute it and/or modify
+ ** it under the terms of the GNU General Public License as published by
+ ** the Free Software Foundation; either version 2 of the License, or
+ ** (at your option) any later version.
+ **
+ ** This program is distributed in the hope that it will be useful,
+ ** but WITHOUT ANY WARRANTY; without even the implied warranty of
+ ** MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ ** GNU General Public License for more details.
+ **
+ ** You should have received a copy of the GNU General Public License
+ ** along with this program; if not, write to the Free Software
+ ** Foundation, Inc., 675 Mass Ave, Cambridge, MA 02139, USA.
+ **
+ ******************************************************************************
+ */
+ 
+
+package storage
+
+import (
+	"appengine"
+	"appengine/datastore"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// Fever identifies items by integer, and clients expect the IDs to 
+// grow as items arrive (new items are requested by the largest ID 
+// seen). Articles are keyed by GUID, so they're numbered as they're 
+// first listed through the Fever API, in the order they were stored. 
+// Numbering is kept with the user, along with a reverse mapping for 
+// looking up the number of an article
+
+const (
+	// Articles numbered at once. Each takes two writes, all within a 
+	// single transaction
+	maxFeverItemsNumbered = 200
+	// Most IDs returned in a list of unread or saved items
+	maxFeverItemIDs = 5000
+	// Items returned per request, as set by the Fever API
+	feverItemsPerPage = 50
+)
+
+// feverItem maps the Fever ID (its key) to an article
+type feverItem struct {
+	Article *datastore.Key `datastore:",noindex"`
+}
+
+// feverItemRef maps an article (by encoded key) to its Fever ID
+type feverItemRef struct {
+	ID int64 `datastore:",noindex"`
+}
+
+// feverSync records how far numbering has progressed
+type feverSync struct {
+	Cursor string `datastore:",noindex"`
+	LastID int64  `datastore:",noindex"`
+}
+
+// FeverItem is an article, as numbered for the Fever API
+type FeverItem struct {
+	ID int64
+	Ref ArticleRef
+	Article Article
+}
+
+// feverKeyDigest returns the digest of a Fever API key, as stored. 
+// The key itself is as good as a password, and is sent with every 
+// request, so it isn't kept
+func feverKeyDigest(apiKey string) string {
+	digest := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(digest[:])
+}
+
+// SetFeverAPIKey sets (or, if empty, clears) the key Fever clients 
+// authenticate with
+func SetFeverAPIKey(c appengine.Context, userID UserID, apiKey string) error {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return err
+	}
+
+	user := new(User)
+	if err := datastore.Get(c, userKey, user); err != nil && !IsFieldMismatch(err) {
+		return err
+	}
+
+	user.FeverKeyDigest = ""
+	if apiKey != "" {
+		user.FeverKeyDigest = feverKeyDigest(apiKey)
+	}
+	user.FeverAPIKey = ""
+
+	if _, err := datastore.Put(c, userKey, user); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UserByFeverAPIKey returns the user with the Fever API key, or nil if
+// there isn't one. Keys stored as-is, before they were digested, are
+// replaced by their digest once used
+func UserByFeverAPIKey(c appengine.Context, apiKey string) (*User, error) {
+	if apiKey == "" {
+		return nil, nil
+	}
+
+	var users []User
+	q := datastore.NewQuery("User").Filter("FeverKeyDigest =", feverKeyDigest(apiKey)).Limit(1)
+	if _, err := q.GetAll(c, &users); err != nil && !IsFieldMismatch(err) {
+		return nil, err
+	} else if len(users) > 0 {
+		return &users[0], nil
+	}
+
+	q = datastore.NewQuery("User").Filter("FeverAPIKey =", apiKey).Limit(1)
+	if _, err := q.GetAll(c, &users); err != nil && !IsFieldMismatch(err) {
+		return nil, err
+	} else if len(users) == 0 {
+		return nil, nil
+	}
+
+	user := &users[0]
+	if err := SetFeverAPIKey(c, UserID(user.ID), apiKey); err != nil {
+		c.Warningf("Error replacing Fever API key with its digest: %s", err)
+	} else {
+		user.FeverKeyDigest = feverKeyDigest(apiKey)
+		user.FeverAPIKey = ""
+	}
+
+	return user, nil
+}
+
+// NumberFeverItems numbers the articles stored since the last call, 
+// returning the largest ID assigned so far. Articles updated since 
+// they were numbered are numbered again, and so resurface in clients 
+// as new items
+func NumberFeverItems(c appengine.Context, userID UserID) (int64, error) {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return 0, err
+	}
+
+	syncKey := datastore.NewKey(c, "FeverSync", "default", 0, userKey)
+	var lastID int64
+
+	err = datastore.RunInTransaction(c, func(c appengine.Context) error {
+		sync := new(feverSync)
+		if err := datastore.Get(c, syncKey, sync); err != nil && err != datastore.ErrNoSuchEntity && !IsFieldMismatch(err) {
+			return err
+		}
+
+		q := datastore.NewQuery("Article").Ancestor(userKey).Order("Stored").KeysOnly().Limit(maxFeverItemsNumbered)
+		if sync.Cursor != "" {
+			if cursor, err := datastore.DecodeCursor(sync.Cursor); err != nil {
+				return err
+			} else {
+				q = q.Start(cursor)
+			}
+		}
+
+		var itemKeys, refKeys []*datastore.Key
+		var items []feverItem
+		var refs []feverItemRef
+
+		t := q.Run(c)
+		for {
+			articleKey, err := t.Next(nil)
+			if err == datastore.Done {
+				break
+			} else if err != nil {
+				return err
+			}
+
+			sync.LastID++
+			itemKeys = append(itemKeys, datastore.NewKey(c, "FeverItem", "", sync.LastID, userKey))
+			items = append(items, feverItem { Article: articleKey })
+			refKeys = append(refKeys, datastore.NewKey(c, "FeverItemRef", articleKey.Encode(), 0, userKey))
+			refs = append(refs, feverItemRef { ID: sync.LastID })
+		}
+
+		lastID = sync.LastID
+		if len(itemKeys) == 0 {
+			return nil
+		}
+
+		if cursor, err := t.Cursor(); err != nil {
+			return err
+		} else {
+			sync.Cursor = cursor.String()
+		}
+
+		if _, err := datastore.PutMulti(c, itemKeys, items); err != nil {
+			return err
+		}
+		if _, err := datastore.PutMulti(c, refKeys, refs); err != nil {
+			return err
+		}
+		if _, err := datastore.Put(c, syncKey, sync); err != nil {
+			return err
+		}
+
+		return nil
+	}, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return lastID, nil
+}
+
+// FeverItems returns a page of numbered articles: those with the IDs 
+// specified, if any; otherwise those following sinceID or, if set,
+// those preceding maxID (newest first)
+func FeverItems(c appengine.Context, userID UserID, sinceID int64, maxID int64, withIDs []int64) ([]FeverItem, error) {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var itemKeys []*datastore.Key
+	if len(withIDs) > 0 {
+		if len(withIDs) > feverItemsPerPage {
+			withIDs = withIDs[:feverItemsPerPage]
+		}
+		for _, id := range withIDs {
+			if id > 0 {
+				itemKeys = append(itemKeys, datastore.NewKey(c, "FeverItem", "", id, userKey))
+			}
+		}
+	} else {
+		q := datastore.NewQuery("FeverItem").Ancestor(userKey).KeysOnly().Limit(feverItemsPerPage)
+		if maxID > 0 {
+			q = q.Filter("__key__ <", datastore.NewKey(c, "FeverItem", "", maxID, userKey)).Order("-__key__")
+		} else {
+			q = q.Filter("__key__ >", datastore.NewKey(c, "FeverItem", "", sinceID, userKey)).Order("__key__")
+		}
+
+		if itemKeys, err = q.GetAll(c, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	items := make([]feverItem, len(itemKeys))
+	itemErrors := make([]error, len(itemKeys))
+	if err := datastore.GetMulti(c, itemKeys, items); err != nil {
+		if multiError, ok := err.(appengine.MultiError); ok {
+			itemErrors = multiError
+		} else {
+			return nil, err
+		}
+	}
+
+	var articleKeys []*datastore.Key
+	var ids []int64
+	for i, item := range items {
+		if err := itemErrors[i]; err == datastore.ErrNoSuchEntity {
+			continue
+		} else if err != nil && !IsFieldMismatch(err) {
+			return nil, err
+		}
+
+		articleKeys = append(articleKeys, item.Article)
+		ids = append(ids, itemKeys[i].IntID())
+	}
+
+	articles := make([]Article, len(articleKeys))
+	articleErrors := make([]error, len(articleKeys))
+	if err := datastore.GetMulti(c, articleKeys, articles); err != nil {
+		if multiError, ok := err.(appengine.MultiError); ok {
+			articleErrors = multiError
+		} else {
+			return nil, err
+		}
+	}
+
+	feverItems := make([]FeverItem, 0, len(articles))
+	var entryKeys []*datastore.Key
+	for i, article := range articles {
+		if err := articleErrors[i]; err == datastore.ErrNoSuchEntity {
+			continue // Unsubscribed since
+		} else if err != nil && !IsFieldMismatch(err) {
+			return nil, err
+		}
+
+		article.ID = article.Entry.StringID()
+		article.Source = article.Entry.Parent().StringID()
+
+		feverItems = append(feverItems, FeverItem {
+			ID: ids[i],
+			Ref: newArticleRef(userID, articleKeys[i]),
+			Article: article,
+		})
+		entryKeys = append(entryKeys, article.Entry)
+	}
+
+	entries := make([]Entry, len(entryKeys))
+	if err := datastore.GetMulti(c, entryKeys, entries); err != nil {
+		if multiError, ok := err.(appengine.MultiError); ok {
+			for _, singleError := range multiError {
+				if singleError != nil && !IsFieldMismatch(singleError) {
+					return nil, err
+				}
+			}
+		} else {
+			return nil, err
+		}
+	}
+
+	for i := range feverItems {
+		feverItems[i].Article.Details = &entries[i]
+	}
+
+	return feverItems, nil
+}
+
+// FeverItemIDs returns the IDs of numbered articles with a property 
+// (e.g. "unread"). Articles not yet numbered are left out
+func FeverItemIDs(c appengine.Context, userID UserID, property string) ([]int64, error) {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return nil, err
+	}
+
+	q := datastore.NewQuery("Article").Ancestor(userKey).Filter("Properties =", property).KeysOnly().Limit(maxFeverItemIDs)
+	articleKeys, err := q.GetAll(c, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(articleKeys))
+	for batchStart := 0; batchStart < len(articleKeys); batchStart += defaultBatchSize {
+		batchEnd := batchStart + defaultBatchSize
+		if batchEnd > len(articleKeys) {
+			batchEnd = len(articleKeys)
+		}
+
+		refKeys := make([]*datastore.Key, batchEnd - batchStart)
+		for i, articleKey := range articleKeys[batchStart:batchEnd] {
+			refKeys[i] = datastore.NewKey(c, "FeverItemRef", articleKey.Encode(), 0, userKey)
+		}
+
+		refs := make([]feverItemRef, len(refKeys))
+		refErrors := make([]error, len(refKeys))
+		if err := datastore.GetMulti(c, refKeys, refs); err != nil {
+			if multiError, ok := err.(appengine.MultiError); ok {
+				refErrors = multiError
+			} else {
+				return nil, err
+			}
+		}
+
+		for i, ref := range refs {
+			if err := refErrors[i]; err == nil || IsFieldMismatch(err) {
+				ids = append(ids, ref.ID)
+			} else if err != datastore.ErrNoSuchEntity {
+				return nil, err
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+// ErrUnknownFeverItem is returned for Fever item IDs that aren't the 
+// user's (or whose articles are gone)
+var ErrUnknownFeverItem = errors.New("Unknown item")
+
+// FeverItemRef resolves a Fever ID to the article it was assigned to
+func FeverItemRef(c appengine.Context, userID UserID, id int64) (ArticleRef, error) {
+	userKey, err := userID.key(c)
+	if err != nil {
+		return ArticleRef{}, err
+	}
+
+	item := new(feverItem)
+	itemKey := datastore.NewKey(c, "FeverItem", "", id, userKey)
+	if err := datastore.Get(c, itemKey, item); err == datastore.ErrNoSuchEntity {
+		return ArticleRef{}, ErrUnknownFeverItem
+	} else if err != nil && !IsFieldMismatch(err) {
+		return ArticleRef{}, err
+	}
+
+	return newArticleRef(userID, item.Article), nil
+}
+
+// newArticleRef returns a reference to the article with the key
+func newArticleRef(userID UserID, articleKey *datastore.Key) ArticleRef {
+	return ArticleRef {
+		SubscriptionRef: newSubscriptionRef(userID, articleKey.Parent()),
+		ArticleID: articleKey.StringID(),
+	}
+}
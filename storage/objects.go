@@ -24,13 +24,18 @@
 package storage
 
 import (
+	"appengine"
 	"appengine/datastore"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"time"
 )
 
 const (
 	likeCountShards = 40
+	starCountShards = 40
 	subscriberCountShards = 40
 )
 
@@ -38,6 +43,31 @@ type User struct {
 	ID string
 	EmailAddress string
 	LastSubscriptionUpdate time.Time
+	// Last time the user asked for all feeds to be refreshed
+	LastRefreshAll time.Time `datastore:",noindex"`
+	// Unread articles older than this are marked as read 
+	// automatically; zero to keep them unread
+	AutoReadAgeDays int
+	// SHA-256 of the Fever API key (see SetFeverAPIKey); empty if not
+	// enabled
+	FeverKeyDigest string
+	// Fever API key as stored before FeverKeyDigest; cleared once 
+	// the user next signs in through a Fever client
+	FeverAPIKey string
+	// New articles mentioning any of these words (or phrases) are 
+	// stored as read
+	MuteWords []string `datastore:",noindex"`
+	// Grants access to the user's folders as feeds; empty if not 
+	// enabled
+	FeedToken string
+}
+
+// Preferences are the client's settings for a user (themes, layout, 
+// etc.), kept as an opaque JSON document so they follow the user 
+// across devices
+type Preferences struct {
+	JSON []byte `datastore:",noindex"`
+	Updated time.Time
 }
 
 type FeedMeta struct {
@@ -47,6 +77,29 @@ type FeedMeta struct {
 	NextFetch time.Time
 	UpdateCounter int64
 	HourlyUpdateFrequency float32
+	LastContentHash []byte `datastore:",noindex"`
+	LastContentLength int `datastore:",noindex"`
+	LastBuildDate time.Time `datastore:",noindex"`
+	FailureCount int
+	LastFailure time.Time `datastore:",noindex"`
+	RefreshIntervalOverride int `datastore:",noindex"`
+	CanonicalURL string `datastore:",noindex"`
+	// When CanonicalURL was last confirmed to serve the same feed. 
+	// Addresses recorded before verification was added have none
+	CanonicalVerified time.Time `datastore:",noindex"`
+	// Earliest time the publisher allows the feed to be fetched again,
+	// after rate-limiting us
+	RetryAfter time.Time `datastore:",noindex"`
+}
+
+// RawFeed refers to the most recently fetched document of a feed, as
+// kept for debugging
+type RawFeed struct {
+	BlobKey appengine.BlobKey
+	Fetched time.Time
+	// Length of the original document, which may be longer than the
+	// stored copy
+	Length int
 }
 
 type FeedSubscriber struct {
@@ -62,8 +115,11 @@ type Feed struct {
 	Format string      `datastore:",noindex"`
 	Topic string
 	HubURL string
+	// Self-declared address, if different from URL and verified
+	CanonicalURL string
 	FavIconURL string  `datastore:",noindex"`
 	Updated time.Time
+	SearchTerms []string
 }
 
 type FeedUsage struct {
@@ -75,9 +131,19 @@ type FeedUsage struct {
 type EntryMeta struct {
 	Fetched time.Time
 	Published time.Time
+	// Publication date given by the feed, when too far in the future
+	// to be used as Published
+	OriginalPublished time.Time `datastore:",noindex"`
 	InfoDigest []byte
+	ContentDigest []byte `datastore:",noindex"`
+	Author string        `datastore:",noindex"`
+	Fingerprint string   `datastore:",noindex"`
 	UpdateIndex int64
 	Entry *datastore.Key
+	// Totals of the like and star counters, across all subscribers, 
+	// for ordering by popularity
+	LikeCount int
+	StarCount int
 }
 
 type Entry struct {
@@ -85,10 +151,24 @@ type Entry struct {
 	Title string        `json:"title"`
 	Link string         `json:"link"`
 	HasMedia bool       `json:"-"`
-	Updated time.Time   `json:"-"`
+	Updated time.Time   `json:"updated"`
+	// Publication date as given by the feed, for when it couldn't be
+	// parsed (or was parsed wrong)
+	PublishedRaw string `json:"publishedRaw,omitempty" datastore:",noindex"`
 
 	Content string      `json:"content" datastore:",noindex"`
 	Summary string      `json:"summary" datastore:",noindex"`
+
+	ContentText string  `json:"-" datastore:",noindex"`
+	// Estimated; zero for entries without text
+	ReadingTimeSeconds int `json:"readingTimeSeconds,omitempty" datastore:",noindex"`
+
+	CommentsURL string  `json:"commentsUrl,omitempty" datastore:",noindex"`
+	CommentCount int    `json:"commentCount,omitempty" datastore:",noindex"`
+
+	// Original feed, for reposted items
+	SourceTitle string  `json:"sourceTitle,omitempty" datastore:",noindex"`
+	SourceURL string    `json:"sourceUrl,omitempty" datastore:",noindex"`
 }
 
 type EntryMedia struct {
@@ -103,11 +183,26 @@ type likeCountShard struct {
 	LikeCount int
 }
 
+type starCountShard struct {
+	Entry *datastore.Key
+	StarCount int
+}
+
 type subscriberCountShard struct {
 	Feed *datastore.Key
 	SubscriberCount int
 }
 
+type InstanceStats struct {
+	Users int                        `json:"users"`
+	Feeds int                        `json:"feeds"`
+	Subscriptions int                `json:"subscriptions"`
+	FailingFeeds int                 `json:"failingFeeds"`
+	LastFeedUpdate time.Time         `json:"lastFeedUpdate"`
+	LastSubscriptionUpdate time.Time `json:"lastSubscriptionUpdate"`
+	Generated time.Time              `json:"generated"`
+}
+
 type UserSubscriptions struct {
 	Subscriptions  []Subscription  `json:"subscriptions"`
 	Folders        []Folder        `json:"folders"`
@@ -153,10 +248,71 @@ func ArticleFilterFromJSON(userID UserID, filterAsJSON string) (ArticleFilter, e
 		return filter, err
 	}
 
+	// Determine whether the property was specified at all
+	// (an empty property is an explicit request for all articles)
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(filterAsJSON), &fields); err == nil {
+		_, filter.PropertySpecified = fields["p"]
+	}
+
 	filter.UserID = userID
 	return filter, nil
 }
 
+// articleView is the part of a filter encoded in a view ID. The user 
+// isn't part of it - views are resolved on behalf of a user
+type articleView struct {
+	FolderID string       `json:"f,omitempty"`
+	SubscriptionID string `json:"s,omitempty"`
+	// Only set if the property was specified
+	Property *string      `json:"p,omitempty"`
+	Tag string            `json:"t,omitempty"`
+	UnreadFirst bool      `json:"u,omitempty"`
+	Author string         `json:"a,omitempty"`
+	Exclude string        `json:"x,omitempty"`
+}
+
+// ViewID returns an opaque, stable identifier for the filter, which 
+// can be resolved back to it with ResolveView
+func (filter ArticleFilter)ViewID() string {
+	view := articleView {
+		FolderID: filter.FolderID,
+		SubscriptionID: filter.SubscriptionID,
+		Tag: filter.Tag,
+		UnreadFirst: filter.UnreadFirst,
+		Author: filter.Author,
+		Exclude: filter.Exclude,
+	}
+	if filter.PropertySpecified {
+		view.Property = &filter.Property
+	}
+
+	if viewJSON, err := json.Marshal(view); err != nil {
+		return ""
+	} else {
+		return base64.URLEncoding.EncodeToString(viewJSON)
+	}
+}
+
+// fingerprint identifies everything about the filter that affects 
+// the articles it yields, and so the validity of a cursor
+func (filter ArticleFilter)fingerprint() string {
+	var newerThan string
+	if !filter.NewerThan.IsZero() {
+		newerThan = filter.NewerThan.Format(time.RFC3339Nano)
+	}
+
+	fingerprintJSON, _ := json.Marshal([]interface{} {
+		filter.UserID, 
+		filter.ViewID(), 
+		newerThan,
+		filter.Query,
+	})
+
+	hash := sha256.Sum256(fingerprintJSON)
+	return hex.EncodeToString(hash[:16])
+}
+
 func (ref SubscriptionRef)IsSubscriptionExplicit() bool {
 	return ref.SubscriptionID != ""
 }
@@ -165,11 +321,41 @@ type ArticleFilter struct {
 	ArticleScope
 	Property string `json:"p,omitempty"`
 	Tag string      `json:"t,omitempty"`
+
+	// If set, only articles stored at or after this time are returned.
+	// The bound is inclusive because articles are written in batches 
+	// that share a time, so a client that syncs between two batches 
+	// still gets the rest; clients drop the articles they already have
+	// (by ID)
+	NewerThan time.Time `json:"-"`
+	PropertySpecified bool `json:"-"`
+	UnreadFirst bool `json:"-"`
+	// If set, article content is left out of the page; it can be 
+	// loaded separately, one article at a time
+	SummaryOnly bool `json:"-"`
+	// If set, content is left out only for articles of subscriptions
+	// displayed as summaries
+	SummaryByDisplayMode bool `json:"-"`
+	// If set, only articles whose title or content contain the text 
+	// are returned. Unlike the search index, this is a linear scan of 
+	// each page as it's read, so pages may come back short (or empty)
+	// while a continuation remains
+	Query string `json:"-"`
+	// If set, only articles by the author are returned. Names are 
+	// matched ignoring case and spacing, so "Jane  Doe" matches
+	// "jane doe". Articles stored before authors were recorded 
+	// don't match any author
+	Author string `json:"-"`
+	// If set, articles with this property are left out. Read and unread
+	// are excluded by querying for the other; since the datastore can't
+	// exclude a value of a list, other properties are filtered from 
+	// each page as it's read, so pages may come back short
+	Exclude string `json:"-"`
 }
 
 type ArticleRef struct {
 	SubscriptionRef
-	ArticleID string
+	ArticleID string `json:"a,omitempty"`
 }
 
 type Subscription struct {
@@ -178,18 +364,87 @@ type Subscription struct {
 	FavIconURL string `datastore:"-" json:"favIconUrl"`
 	Parent string     `datastore:"-" json:"parent,omitempty"`
 
+	// Derived from the shared feed's schedule
+	LastRefresh time.Time `datastore:"-" json:"lastRefresh"`
+	NextRefresh time.Time `datastore:"-" json:"nextRefresh"`
+	RefreshFailures int   `datastore:"-" json:"refreshFailures,omitempty"`
+
+	// Articles fetched since the user last opened the subscription,
+	// whether read or not. Kept up to date as articles are added, up 
+	// to maxNewSinceVisit
+	NewSinceVisit int     `datastore:",noindex" json:"newSinceVisit"`
+	LastVisited time.Time `json:"-" datastore:",noindex"`
+
 	Updated time.Time    `json:"-"`
 	Subscribed time.Time `json:"-"`
 	Feed *datastore.Key  `json:"-"`
 	MaxUpdateIndex int64 `json:"-"`
 
 	Title string         `json:"title"`
+	// Set if the user chose the title (by renaming the subscription,
+	// or importing it under a name of their own), so that it isn't 
+	// replaced when the feed's title changes
+	TitleOverridden bool `json:"titleOverridden,omitempty"`
 	UnreadCount int      `json:"unread"`
+	Paused bool          `json:"paused,omitempty"`
+	ResurfaceUpdates bool `json:"resurfaceUpdates,omitempty"`
+	// Match new entries on link and title, rather than GUID, for feeds 
+	// that regenerate their GUIDs. Changes to an entry's content then
+	// go unnoticed
+	DedupByLink bool     `json:"dedupByLink,omitempty"`
+	// How the user prefers to read the feed's articles ("summary" or 
+	// "full"); empty to leave it to the client
+	DisplayMode string   `json:"displayMode,omitempty" datastore:",noindex"`
+
+	// Website of the feed, as imported from OPML. Superseded by the 
+	// feed's own link, once it's fetched
+	WWWURL string        `json:"-" datastore:",noindex"`
+
+	// Minutes between refreshes, if set by the user
+	RefreshIntervalOverride int `json:"refreshIntervalOverride,omitempty"`
+
+	// Receives a POST for each new article
+	WebhookURL string    `json:"webhookUrl,omitempty" datastore:",noindex"`
+	WebhookSecret string `json:"-" datastore:",noindex"`
+}
+
+// PropertyState is the complete set of properties of an article, 
+// along with their version
+type PropertyState struct {
+	Properties []string `json:"properties"`
+	Version int64       `json:"version"`
+}
+
+type WebhookNotification struct {
+	URL string       `json:"-"`
+	Secret string    `json:"-"`
+
+	FeedTitle string `json:"feedTitle"`
+	Title string     `json:"title"`
+	Link string      `json:"url"`
+}
+
+// ArticleState is the read, star and like state of an article, 
+// without its content
+type ArticleState struct {
+	ID string          `json:"id"`
+	Source string      `json:"source"`
+	Read bool          `json:"read"`
+	Star bool          `json:"star"`
+	Like bool          `json:"like"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type ArticleStatePage struct {
+	States []ArticleState `json:"states"`
+	Continue string      `json:"continue,omitempty"`
 }
 
 type ArticlePage struct {
 	Articles []Article `json:"articles"`
 	Continue string    `json:"continue,omitempty"`
+	Latest string      `json:"latest,omitempty"`
+	ViewID string      `json:"viewId,omitempty"`
 }
 
 type Article struct {
@@ -197,15 +452,40 @@ type Article struct {
 	Source string         `datastore:"-" json:"source"`
 
 	Details *Entry        `datastore:"-" json:"details"`
+	ContentText string    `datastore:"-" json:"contentText,omitempty"`
 	Media []*EntryMedia   `datastore:"-" json:"media,omitempty"`
 
 	UpdateIndex int64     `json:"-"`
+	Stored time.Time      `json:"-"`
 	Fetched time.Time     `json:"time"`
 	Published time.Time   `json:"published"`
 	Entry *datastore.Key  `json:"-"`
+	// Normalized, for filtering
+	Author string         `json:"-"`
+	// Link and title digest, for matching entries with changing GUIDs
+	Fingerprint string    `json:"-"`
 
 	Properties []string   `json:"properties"`
+	// Incremented whenever the properties change
+	PropertyVersion int64 `json:"version" datastore:",noindex"`
+	// When the properties last changed, for syncing state alone
+	StateUpdated time.Time `json:"-"`
 	Tags []string         `json:"tags"`
+
+	ReadAt time.Time      `json:"readAt"`
+	ContentDigest []byte  `json:"-" datastore:",noindex"`
+	ReadPosition float64  `json:"readPosition,omitempty" datastore:",noindex"`
+	ReadAnchor string     `json:"readAnchor,omitempty" datastore:",noindex"`
+	// The mute word that had the article stored as read, if any
+	MutedBy string        `json:"mutedBy,omitempty" datastore:",noindex"`
+	// Body of the article as extracted from its web page, for feeds 
+	// with excerpts. Loaded on request, rather than with each page
+	FullContent string    `json:"-" datastore:",noindex"`
+
+	// Likes and stars across all subscribers, when ranking by 
+	// popularity
+	TotalLikes int        `datastore:"-" json:"totalLikes,omitempty"`
+	TotalStars int        `datastore:"-" json:"totalStars,omitempty"`
 }
 
 type Tag struct {
@@ -220,6 +500,33 @@ type StorageInfo struct {
 type Folder struct {
 	ID string    `json:"id" datastore:"-"`
 	Title string `json:"title"`
+	// Title as compared for duplicates (see normalizeFolderTitle). 
+	// Folders created before it was recorded have none
+	TitleKey string `json:"-"`
+	DefaultFilter string `json:"defaultFilter,omitempty" datastore:",noindex"`
+}
+
+// nextFetch returns the time of the fetch following one made at the
+// specified time, respecting any rate limit imposed by the publisher
+func (feedMeta FeedMeta)nextFetch(fetched time.Time, computed time.Duration) time.Time {
+	next := fetched.Add(feedMeta.refreshInterval(computed))
+	if next.Before(feedMeta.RetryAfter) {
+		return feedMeta.RetryAfter
+	}
+
+	return next
+}
+
+// refreshInterval returns the time between fetches of the feed. Since 
+// feeds are shared, a subscriber's override can only shorten it
+func (feedMeta FeedMeta)refreshInterval(computed time.Duration) time.Duration {
+	if feedMeta.RefreshIntervalOverride > 0 {
+		if override := time.Duration(feedMeta.RefreshIntervalOverride) * time.Minute; override < computed {
+			return override
+		}
+	}
+
+	return computed
 }
 
 func (article Article)IsUnread() bool {
@@ -230,6 +537,10 @@ func (article Article)IsLiked() bool {
 	return article.HasProperty("like")
 }
 
+func (article Article)IsStarred() bool {
+	return article.HasProperty("star")
+}
+
 func (article Article)HasProperty(propName string) bool {
 	for _, property := range article.Properties {
 		if property == propName {
@@ -246,14 +557,21 @@ func (article *Article)SetProperty(propName string, set bool) {
 		propMap[property] = true
 	}
 
-	if set && !propMap[propName] {
+	if set == propMap[propName] {
+		return // No change
+	}
+
+	article.PropertyVersion++
+	article.StateUpdated = time.Now()
+
+	if set {
 		propMap[propName] = true
 		if propName == "read" {
 			delete(propMap, "unread")
 		} else if propName == "unread" {
 			delete(propMap, "read")
 		}
-	} else if !set && propMap[propName] {
+	} else {
 		delete(propMap, propName)
 		if propName == "read" {
 			propMap["unread"] = true
@@ -26,10 +26,18 @@ package storage
 import (
 	"appengine"
 	"appengine/datastore"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
+	"io"
+	"net/url"
+	"rss"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
 func formatId(kind string, intId int64) string {
@@ -60,12 +68,220 @@ func newFolderRef(userID UserID, key *datastore.Key) (FolderRef) {
 	return ref
 }
 
-func updateSubscriptionByKey(c appengine.Context, subscriptionKey *datastore.Key, subscription Subscription) (int, error) {
+// newSubscriptionRef returns a reference to the subscription with the
+// key, which is either in a folder or at the user's top level
+func newSubscriptionRef(userID UserID, subscriptionKey *datastore.Key) SubscriptionRef {
+	var folderKey *datastore.Key
+	if parentKey := subscriptionKey.Parent(); parentKey != nil && parentKey.Kind() == "Folder" {
+		folderKey = parentKey
+	}
+
+	return SubscriptionRef {
+		FolderRef: newFolderRef(userID, folderKey),
+		SubscriptionID: subscriptionKey.StringID(),
+	}
+}
+
+// normalizeWords lowercases text and reduces it to its words, 
+// separated and surrounded by single spaces, so that words and 
+// phrases can be matched whole with a substring search
+func normalizeWords(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	return " " + strings.Join(words, " ") + " "
+}
+
+// mutingWord returns the first of the mute words found in an entry's 
+// title or text, or an empty string if there are none
+func mutingWord(muteWords []string, entry *Entry) string {
+	text := normalizeWords(entry.Title + " " + rss.PlainText(entry.Content))
+	for _, muteWord := range muteWords {
+		if normalized := normalizeWords(muteWord); normalized != "  " && strings.Contains(text, normalized) {
+			return muteWord
+		}
+	}
+
+	return ""
+}
+
+// mutingWords reads the entries at once, returning the word muting 
+// each one (see mutingWord). Errors are logged, and entries that 
+// can't be read aren't muted
+func mutingWords(c appengine.Context, muteWords []string, entryKeys []*datastore.Key) []string {
+	words := make([]string, len(entryKeys))
+	if len(entryKeys) == 0 {
+		return words
+	}
+
+	entries := make([]Entry, len(entryKeys))
+	var entryErrors appengine.MultiError
+	if err := datastore.GetMulti(c, entryKeys, entries); err != nil {
+		if multiError, ok := err.(appengine.MultiError); ok {
+			entryErrors = multiError
+		} else {
+			c.Warningf("Error reading entries to match mute words: %s", err)
+			return words
+		}
+	}
+
+	for i := range entries {
+		if entryErrors != nil && entryErrors[i] != nil && !IsFieldMismatch(entryErrors[i]) {
+			c.Warningf("Error matching mute words (%s): %s", entryKeys[i].StringID(), entryErrors[i])
+			continue
+		}
+
+		words[i] = mutingWord(muteWords, &entries[i])
+	}
+
+	return words
+}
+
+// updateSubscriptionByKey adds the feed's new entries to the 
+// subscription. muteWords are those of the user owning it. With 
+// markRead, new articles are stored as read (e.g. the backlog of a new
+// subscription)
+func updateSubscriptionByKey(c appengine.Context, subscriptionKey *datastore.Key, subscription Subscription, muteWords []string, markRead bool) (int, error) {
 	feedKey := subscription.Feed
 	largestUpdateIndexWritten := int64(-1)
 	unreadDelta := 0
+	newArticles := 0
+	// Shared by every article written by this update, across batches.
+	// Incremental sync includes articles stored at the time it was 
+	// last given, so it doesn't miss batches written after it ran
+	stored := time.Now()
+
+	// Whether a read article's content has changed enough to mark it 
+	// as new again
+	isResurfaced := func(article *Article, entryMeta *EntryMeta) bool {
+		return subscription.ResurfaceUpdates && !article.IsUnread() &&
+			article.ContentDigest != nil && entryMeta.ContentDigest != nil &&
+			!bytes.Equal(article.ContentDigest, entryMeta.ContentDigest)
+	}
 
 	batchWriter := NewBatchWriter(c, BatchPut)
+	var newEntryKeys []*datastore.Key
+	seenFingerprints := make(map[string]bool)
+
+	// The articles for each batch of entries are read at once
+	writeArticles := func(entryMetas []*EntryMeta) error {
+		if len(entryMetas) == 0 {
+			return nil
+		}
+
+		articleKeys := make([]*datastore.Key, len(entryMetas))
+		for i, entryMeta := range entryMetas {
+			articleKeys[i] = datastore.NewKey(c, "Article", entryMeta.Entry.StringID(), 0, subscriptionKey)
+		}
+
+		articles := make([]Article, len(entryMetas))
+		articleErrors := make([]error, len(entryMetas))
+		if err := datastore.GetMulti(c, articleKeys, articles); err != nil {
+			if multiError, ok := err.(appengine.MultiError); ok {
+				articleErrors = multiError
+			} else {
+				return err
+			}
+		}
+
+		// The entries that may be muted (new, or resurfaced) are read
+		// at once too, but only if there are mute words to match
+		muteWordsByEntry := make([]string, len(entryMetas))
+		if len(muteWords) > 0 && !markRead {
+			var entryKeys []*datastore.Key
+			var positions []int
+			for i, entryMeta := range entryMetas {
+				if articleErrors[i] == datastore.ErrNoSuchEntity || isResurfaced(&articles[i], entryMeta) {
+					entryKeys = append(entryKeys, entryMeta.Entry)
+					positions = append(positions, i)
+				}
+			}
+
+			for j, muteWord := range mutingWords(c, muteWords, entryKeys) {
+				muteWordsByEntry[positions[j]] = muteWord
+			}
+		}
+
+		for i, entryMeta := range entryMetas {
+			articleKey := articleKeys[i]
+			article := &articles[i]
+
+			if err := articleErrors[i]; err == datastore.ErrNoSuchEntity {
+				if subscription.DedupByLink && isDuplicateArticle(c, subscriptionKey, entryMeta.Fingerprint, seenFingerprints) {
+					// Same link and title as an existing article, under a 
+					// new GUID - skip it
+					if entryMeta.UpdateIndex > largestUpdateIndexWritten {
+						largestUpdateIndexWritten = entryMeta.UpdateIndex
+					}
+					continue
+				}
+
+				// New article
+				article.Entry = entryMeta.Entry
+				if !markRead {
+					newArticles++
+				}
+
+				if markRead {
+					// Part of the backlog - not new to the user
+					article.Properties = []string { "read" }
+				} else if muteWord := muteWordsByEntry[i]; muteWord != "" {
+					// Muted - stored as read, noting why
+					article.Properties = []string { "read" }
+					article.MutedBy = muteWord
+				} else {
+					article.Properties = []string { "unread" }
+					unreadDelta++
+
+					if subscription.WebhookURL != "" && len(newEntryKeys) < maxWebhookNotifications {
+						newEntryKeys = append(newEntryKeys, entryMeta.Entry)
+					}
+				}
+			} else if err != nil && !IsFieldMismatch(err) {
+				c.Warningf("Error reading article %s: %s", entryMeta.Entry.StringID(), err)
+				continue
+			} else if isResurfaced(article, entryMeta) {
+				// Content has changed since it was read - mark it as new,
+				// unless it mentions a mute word. Starred articles are 
+				// never muted
+				muteWord := ""
+				if !article.HasProperty("star") {
+					muteWord = muteWordsByEntry[i]
+				}
+
+				if muteWord != "" {
+					article.MutedBy = muteWord
+				} else {
+					article.SetProperty("unread", true)
+					unreadDelta++
+				}
+			}
+
+			article.ContentDigest = entryMeta.ContentDigest
+			article.Author = entryMeta.Author
+			article.Fingerprint = entryMeta.Fingerprint
+			seenFingerprints[entryMeta.Fingerprint] = true
+
+			article.UpdateIndex = entryMeta.UpdateIndex
+			article.Stored = stored
+			article.Fetched = entryMeta.Fetched
+			article.Published = entryMeta.Published
+
+			if entryMeta.UpdateIndex > largestUpdateIndexWritten {
+				largestUpdateIndexWritten = entryMeta.UpdateIndex
+			}
+
+			if err := batchWriter.Enqueue(articleKey, article); err != nil {
+				c.Errorf("Error queueing article for batch write: %s", err)
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	entryMetas := make([]*EntryMeta, 0, defaultBatchSize)
 
 	q := datastore.NewQuery("EntryMeta").Ancestor(feedKey).Filter("UpdateIndex >", subscription.MaxUpdateIndex)
 	for t := q.Run(c); ; {
@@ -81,33 +297,16 @@ func updateSubscriptionByKey(c appengine.Context, subscriptionKey *datastore.Key
 			return batchWriter.Written(), err
 		}
 
-		articleKey := datastore.NewKey(c, "Article", entryMeta.Entry.StringID(), 0, subscriptionKey)
-		article := Article{}
-
-		if err := datastore.Get(c, articleKey, &article); err == datastore.ErrNoSuchEntity {
-			// New article
-			article.Entry = entryMeta.Entry
-			article.Properties = []string { "unread" }
-			unreadDelta++
-		} else if IsFieldMismatch(err) {
-			// Ignore - migration
-		} else if err != nil {
-			c.Warningf("Error reading article %s: %s", entryMeta.Entry.StringID(), err)
-			continue
-		}
-
-		article.UpdateIndex = entryMeta.UpdateIndex
-		article.Fetched = entryMeta.Fetched
-		article.Published = entryMeta.Published
-
-		if entryMeta.UpdateIndex > largestUpdateIndexWritten {
-			largestUpdateIndexWritten = entryMeta.UpdateIndex
+		if entryMetas = append(entryMetas, entryMeta); len(entryMetas) >= defaultBatchSize {
+			if err := writeArticles(entryMetas); err != nil {
+				return batchWriter.Written(), err
+			}
+			entryMetas = entryMetas[:0]
 		}
+	}
 
-		if err := batchWriter.Enqueue(articleKey, &article); err != nil {
-			c.Errorf("Error queueing article for batch write: %s", err)
-			return batchWriter.Written(), err
-		}
+	if err := writeArticles(entryMetas); err != nil {
+		return batchWriter.Written(), err
 	}
 
 	if err := batchWriter.Flush(); err != nil {
@@ -115,7 +314,7 @@ func updateSubscriptionByKey(c appengine.Context, subscriptionKey *datastore.Key
 		return batchWriter.Written(), err
 	}
 
-	if batchWriter.Written() > 0 {
+	if batchWriter.Written() > 0 || largestUpdateIndexWritten > subscription.MaxUpdateIndex {
 		if appengine.IsDevAppServer() {
 			c.Debugf("Completed %s: %d records", subscriptionKey.StringID(), batchWriter.Written())
 		}
@@ -128,11 +327,28 @@ func updateSubscriptionByKey(c appengine.Context, subscriptionKey *datastore.Key
 			subscription.UnreadCount += unreadDelta
 		}
 
+		// Only counted once the user has opened the subscription
+		if !subscription.LastVisited.IsZero() {
+			subscription.NewSinceVisit += newArticles
+			if subscription.NewSinceVisit > maxNewSinceVisit {
+				subscription.NewSinceVisit = maxNewSinceVisit
+			}
+		}
+
 		if _, err := datastore.Put(c, subscriptionKey, &subscription); err != nil {
 			c.Errorf("Error writing subscription: %s", err)
 			return batchWriter.Written(), err
 		}
 
+		invalidateCachedSubscriptions(c, subscriptionKey)
+		if unreadDelta != 0 {
+			adjustCachedUnreadCount(c, subscriptionKey, unreadDelta)
+		}
+
+		if len(newEntryKeys) > 0 && NewArticleNotifier != nil {
+			notifyNewArticles(c, subscription, newEntryKeys)
+		}
+
 		// Update usage index (rough way to track feed popularity)
 		// No sharding, no transactions - complete accuracy is unimportant for now
 
@@ -157,10 +373,169 @@ func updateSubscriptionByKey(c appengine.Context, subscriptionKey *datastore.Key
 	return batchWriter.Written(), nil
 }
 
-func updateSubscriptionAsync(c appengine.Context, subscriptionKey *datastore.Key, subscription Subscription, ch chan<- Subscription) {
-	if _, err := updateSubscriptionByKey(c, subscriptionKey, subscription); err != nil {
+// notifyNewArticles hands the new entries of a subscription over to
+// the notifier, for delivery to the subscription's webhook
+func notifyNewArticles(c appengine.Context, subscription Subscription, entryKeys []*datastore.Key) {
+	entries := make([]Entry, len(entryKeys))
+	if err := datastore.GetMulti(c, entryKeys, entries); err != nil {
+		if multiError, ok := err.(appengine.MultiError); ok {
+			for _, singleError := range multiError {
+				if singleError != nil && !IsFieldMismatch(singleError) {
+					c.Warningf("Error reading entries for webhook: %s", err)
+					return
+				}
+			}
+		} else {
+			c.Warningf("Error reading entries for webhook: %s", err)
+			return
+		}
+	}
+
+	notifications := make([]WebhookNotification, len(entries))
+	for i, entry := range entries {
+		notifications[i] = WebhookNotification {
+			URL: subscription.WebhookURL,
+			Secret: subscription.WebhookSecret,
+			FeedTitle: subscription.Title,
+			Title: entry.Title,
+			Link: entry.Link,
+		}
+	}
+
+	NewArticleNotifier(c, notifications)
+}
+
+func updateSubscriptionAsync(c appengine.Context, subscriptionKey *datastore.Key, subscription Subscription, muteWords []string, ch chan<- Subscription) {
+	if _, err := updateSubscriptionByKey(c, subscriptionKey, subscription, muteWords, false); err != nil {
 		c.Errorf("Error updating subscription %s: %s", subscription.Title, err)
 	}
 
 	ch <- subscription
 }
+
+const (
+	minSearchTermLength = 2
+	maxSearchTermLength = 16
+	maxSearchTerms = 200
+)
+
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// feedSearchTerms returns the prefixes of each of the words in the 
+// title and the site's host name, so that feeds can be found by
+// partially typed words
+func feedSearchTerms(title string, link string) []string {
+	words := searchWords(title)
+	if linkURL, err := url.Parse(link); err == nil {
+		for _, label := range searchWords(linkURL.Host) {
+			if label != "www" {
+				words = append(words, label)
+			}
+		}
+	}
+
+	termMap := make(map[string]bool)
+	terms := make([]string, 0, len(words) * 4)
+
+	for _, word := range words {
+		runes := []rune(word)
+		for n := minSearchTermLength; n <= len(runes) && n <= maxSearchTermLength; n++ {
+			if term := string(runes[:n]); !termMap[term] {
+				termMap[term] = true
+				terms = append(terms, term)
+			}
+		}
+	}
+
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+
+	return terms
+}
+
+// entryFingerprint identifies an entry by its link and title, ignoring
+// case and spacing, for feeds that change the GUIDs of their entries
+func entryFingerprint(link string, title string) string {
+	if link == "" && title == "" {
+		return ""
+	}
+
+	hasher := md5.New()
+	io.WriteString(hasher, strings.TrimSpace(link))
+	io.WriteString(hasher, "\n")
+	io.WriteString(hasher, strings.Join(strings.Fields(strings.ToLower(title)), " "))
+
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// isDuplicateArticle returns true if the subscription already has an 
+// article with the fingerprint, including articles about to be written
+func isDuplicateArticle(c appengine.Context, subscriptionKey *datastore.Key, fingerprint string, pending map[string]bool) bool {
+	if fingerprint == "" {
+		return false
+	} else if pending[fingerprint] {
+		return true
+	}
+
+	q := datastore.NewQuery("Article").Ancestor(subscriptionKey).Filter("Fingerprint =", fingerprint).KeysOnly().Limit(1)
+	if articleKeys, err := q.GetAll(c, nil); err != nil {
+		c.Warningf("Error checking for duplicate article: %s", err)
+		return false
+	} else {
+		return len(articleKeys) > 0
+	}
+}
+
+// normalizeAuthor lowercases an author's name and collapses the 
+// whitespace within it, so that names can be matched as-is
+func normalizeAuthor(name string) string {
+	return strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// entriesByRecency sorts entries newest first. Entries without a 
+// publication date are dated by when they were fetched
+type entriesByRecency struct {
+	entries []*rss.Entry
+	fetched time.Time
+}
+
+func (s entriesByRecency) Len() int {
+	return len(s.entries)
+}
+
+func (s entriesByRecency) Swap(i int, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+}
+
+func (s entriesByRecency) Less(i int, j int) bool {
+	return s.published(j).Before(s.published(i))
+}
+
+func (s entriesByRecency) published(i int) time.Time {
+	if published := s.entries[i].Published; !published.IsZero() {
+		return published
+	}
+
+	return s.fetched
+}
+
+// newestEntries returns up to max of the most recent entries, leaving
+// the original slice as-is. A max of zero or less returns all entries
+func newestEntries(entries []*rss.Entry, fetched time.Time, max int) []*rss.Entry {
+	if max <= 0 || len(entries) <= max {
+		return entries
+	}
+
+	sorted := entriesByRecency {
+		entries: append([]*rss.Entry{}, entries...),
+		fetched: fetched,
+	}
+	sort.Stable(sorted)
+
+	return sorted.entries[:max]
+}
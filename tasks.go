@@ -26,31 +26,64 @@ package gofr
 import (
 	"appengine"
 	"appengine/blobstore"
+	"appengine/channel"
 	"appengine/taskqueue"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 	"rss"
 	"storage"
+	"strconv"
 	"time"
 )
 
 type taskParams map[string]string
 
+const (
+	maxBackfillPages = 5
+
+	// Concurrent fetches when importing subscriptions, and the time 
+	// after which remaining imports are abandoned (tasks have 10 minutes)
+	maxConcurrentImports = 8
+	importDeadline = 9 * time.Minute
+
+	// Time spent on bulk changes to articles (purging, marking as 
+	// read) before the rest is left to a follow-up task
+	purgeDeadline = 8 * time.Minute
+)
+
 func registerTasks() {
 	RegisterTaskRoute("/tasks/subscribe",     subscribeTask)
 	RegisterTaskRoute("/tasks/import",        importOPMLTask)
+	RegisterTaskRoute("/tasks/importJSON",    importJSONTask)
 	RegisterTaskRoute("/tasks/unsubscribe",   unsubscribeTask)
 	RegisterTaskRoute("/tasks/markAllAsRead", markAllAsReadTask)
+	RegisterTaskRoute("/tasks/markNewerUnread", markNewerUnreadTask)
+	RegisterTaskRoute("/tasks/purgeRead",     purgeReadTask)
+	RegisterTaskRoute("/tasks/autoMarkRead",  autoMarkReadTask)
 	RegisterTaskRoute("/tasks/moveSubscription", moveSubscriptionTask)
 	RegisterTaskRoute("/tasks/syncFeeds",     syncFeedsTask)
+	RegisterTaskRoute("/tasks/refreshFeed",   refreshFeedTask)
+	RegisterTaskRoute("/tasks/updateFeedSubscribers", updateFeedSubscribersTask)
 	RegisterTaskRoute("/tasks/removeFolder",  removeFolderTask)
 	RegisterTaskRoute("/tasks/removeTag",     removeTagTask)
+	RegisterTaskRoute("/tasks/notifyWebhook", notifyWebhookTask)
+
+	storage.NewArticleNotifier = queueWebhookNotifications
 }
 
 func startTask(pfc *PFContext, taskName string, params taskParams, queueName string) error {
 	taskValues := url.Values {
 		"userID": { pfc.User.ID },
 		"channelID": { pfc.ChannelID },
+		"locale": { pfc.Locale },
 	}
 
 	for k, v := range params {
@@ -65,86 +98,177 @@ func startTask(pfc *PFContext, taskName string, params taskParams, queueName str
 	return nil
 }
 
-func importSubscription(pfc *PFContext, ch chan<- *rss.Outline, userID storage.UserID, folderRef storage.FolderRef, outline *rss.Outline) {
-	c := pfc.C
+// userMuteWords returns the mute words of the user the task runs for,
+// which new articles are matched against
+func userMuteWords(pfc *PFContext) []string {
+	if pfc.User == nil {
+		return nil
+	}
+
+	return pfc.User.MuteWords
+}
+
+// startTaskOrRun starts a task or, if it can't be queued (e.g. the 
+// queue is backed up), runs its handler within the request instead, 
+// sending its message to the client as the task would. Only for work 
+// small enough to complete before the request times out
+func startTaskOrRun(pfc *PFContext, taskName string, params taskParams, queueName string, handler TaskRouteHandler) error {
+	err := startTask(pfc, taskName, params, queueName)
+	if err == nil {
+		return nil
+	}
+
+	pfc.C.Warningf("Could not queue %s task (%s); running it now", taskName, err)
+
+	// The handler reads its parameters from the task's form
+	form := url.Values {}
+	for k, v := range params {
+		form.Set(k, v)
+	}
+
+	r := *pfc.R
+	r.PostForm = form
+
+	taskPfc := *pfc
+	taskPfc.R = &r
+
+	taskMessage, err := handler(&taskPfc)
+	if err != nil {
+		return err
+	}
+
+	if !taskMessage.Silent && pfc.ChannelID != "" {
+		taskMessage.Message = localize(pfc.Locale, taskMessage.Message)
+		if err := channel.SendJSON(pfc.C, pfc.ChannelID, taskMessage); err != nil {
+			pfc.C.Warningf("Error writing to channel: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// opmlImport is an import of subscriptions in progress. Feeds are 
+// fetched concurrently, but no more than maxConcurrentImports at a time
+type opmlImport struct {
+	pfc *PFContext
+	userID storage.UserID
+	results chan importResult
+	slots chan bool
+	deadline time.Time
+	foldersCreated int
+	foldersMerged int
+}
+
+type importResult struct {
+	Outline *rss.Outline
+	Duplicate bool
+	Err error
+}
+
+var errImportTimedOut = errors.New("Import ran out of time")
+
+// importSubscription subscribes to a single outline, fetching the feed
+// if it's not already known. It returns true if the user was already
+// subscribed
+func (imp *opmlImport)importSubscription(folderRef storage.FolderRef, outline *rss.Outline) (bool, error) {
 	subscriptionURL := outline.FeedURL
+	c := withLogFields(imp.pfc.C, "feed", subscriptionURL)
 
-	if subscribed, err := storage.IsSubscriptionDuplicate(pfc.C, userID, subscriptionURL); err != nil {
-		c.Errorf("Cannot determine if '%s' is duplicate: %s", subscriptionURL, err)
-		goto done
+	if _, subscribed, err := storage.SubscriptionByFeedURL(c, imp.userID, subscriptionURL); err != nil {
+		return false, fmt.Errorf("Cannot determine if '%s' is duplicate: %s", subscriptionURL, err)
 	} else if subscribed {
-		c.Infof("Already subscribed to %s", subscriptionURL)
-		goto done // Already subscribed
+		return true, nil
 	}
 
-	if feed, err := storage.FeedByURL(pfc.C, subscriptionURL); err != nil {
-		c.Errorf("Error locating feed %s: %s", subscriptionURL, err.Error())
-		goto done
+	if feed, err := storage.FeedByURL(c, subscriptionURL); err != nil {
+		return false, fmt.Errorf("Error locating feed %s: %s", subscriptionURL, err)
 	} else if feed == nil {
 		// Feed not available locally - fetch it
-		client := createHttpClient(pfc.C)
-		if response, err := client.Get(subscriptionURL); err != nil {
-			c.Errorf("Error downloading feed %s: %s", subscriptionURL, err)
-			goto done
-		} else {
-			defer response.Body.Close()
-			if parsedFeed, err := rss.UnmarshalStream(subscriptionURL, response.Body); err != nil {
-				c.Errorf("Error reading RSS content (%s): %s", subscriptionURL, err)
-				goto done
-			} else {
-				favIconURL := ""
-				if parsedFeed.WWWURL != "" {
-					if url, err := locateFavIconURL(pfc.C, parsedFeed.WWWURL); err != nil {
-						// Not critical
-						pfc.C.Warningf("FavIcon retrieval error: %s", err)
-					} else if url != "" {
-						favIconURL = url
-					}
-				}
+		// Don't let the fetch outlive the import
+		deadline := time.Duration(fetchDeadlineSeconds) * time.Second
+		if remaining := imp.deadline.Sub(time.Now()); remaining < deadline {
+			deadline = remaining
+		}
 
-				if err := storage.UpdateFeed(pfc.C, parsedFeed, favIconURL, time.Now()); err != nil {
-					c.Errorf("Error updating feed: %s", err)
-					goto done
-				}
+		client := createHttpClientWithDeadline(c, deadline)
+
+		response, err := client.Get(subscriptionURL)
+		if err != nil {
+			return false, fmt.Errorf("Error downloading feed %s: %s", subscriptionURL, err)
+		}
+		defer response.Body.Close()
+
+		parsed, err := rss.UnmarshalStream(subscriptionURL, response.Body)
+		if err != nil {
+			return false, fmt.Errorf("Error reading RSS content (%s): %s", subscriptionURL, err)
+		}
+
+		parsedFeed := parsed.Feed
+
+		favIconURL := ""
+		if parsedFeed.WWWURL != "" {
+			if url, err := locateFavIconURL(c, parsedFeed.WWWURL); err != nil {
+				// Not critical
+				c.Warningf("FavIcon retrieval error: %s", err)
+			} else if url != "" {
+				favIconURL = url
 			}
 		}
-	}
 
-	if subscriptionRef, err := storage.Subscribe(pfc.C, folderRef, subscriptionURL, outline.Title); err != nil {
-		c.Errorf("Error subscribing to feed %s: %s", subscriptionURL, err)
-		goto done
-	} else {
-		if _, err := storage.UpdateSubscription(pfc.C, subscriptionURL, subscriptionRef); err != nil {
-			c.Errorf("Error updating subscription %s: %s", subscriptionURL, err)
-			goto done
+		if err := storage.UpdateFeed(c, parsedFeed, favIconURL, time.Now()); err != nil {
+			return false, fmt.Errorf("Error updating feed: %s", err)
 		}
 	}
 
-done:
-	ch<- outline
+	if subscriptionRef, err := storage.Subscribe(c, folderRef, subscriptionURL, outline.DisplayTitle(), outline.WebURL); err != nil {
+		return false, fmt.Errorf("Error subscribing to feed %s: %s", subscriptionURL, err)
+	} else if _, err := storage.UpdateSubscription(c, subscriptionURL, subscriptionRef, userMuteWords(imp.pfc), false); err != nil {
+		return false, fmt.Errorf("Error updating subscription %s: %s", subscriptionURL, err)
+	}
+
+	return false, nil
 }
 
-func importSubscriptions(pfc *PFContext, ch chan<- *rss.Outline, userID storage.UserID, parentRef storage.FolderRef, outlines []*rss.Outline) int {
-	c := pfc.C
+// importSubscriptions starts importing the outlines (recursively, for 
+// folders), returning the number of results to expect
+func (imp *opmlImport)importSubscriptions(parentRef storage.FolderRef, outlines []*rss.Outline) int {
+	c := imp.pfc.C
 
 	count := 0
 	for _, outline := range outlines {
 		if outline.IsSubscription() {
-			go importSubscription(pfc, ch, userID, parentRef, outline)
+			go func(outline *rss.Outline) {
+				imp.slots<- true
+				defer func() { <-imp.slots }()
+
+				result := importResult { Outline: outline }
+				if time.Now().After(imp.deadline) {
+					result.Err = errImportTimedOut
+				} else {
+					result.Duplicate, result.Err = imp.importSubscription(parentRef, outline)
+				}
+
+				imp.results<- result
+			}(outline)
 			count++
 		} else if outline.IsFolder() {
-			folderRef, err := storage.FolderByTitle(pfc.C, userID, outline.Title)
+			folderRef, err := storage.FolderByTitle(c, imp.userID, outline.DisplayTitle())
 			if err != nil {
 				c.Warningf("Error locating folder: %s", err)
 				continue
 			} else if folderRef.IsZero() {
-				if folderRef, err = storage.CreateFolder(pfc.C, userID, outline.Title); err != nil {
+				if folderRef, err = storage.CreateFolder(c, imp.userID, outline.DisplayTitle()); err != nil {
 					c.Warningf("Error locating folder: %s", err)
 					continue
 				}
+				imp.foldersCreated++
+			} else {
+				// Folder names are matched ignoring case and space, 
+				// so feeds are merged into the existing folder
+				imp.foldersMerged++
 			}
 
-			count += importSubscriptions(pfc, ch, userID, folderRef, outline.Outlines)
+			count += imp.importSubscriptions(folderRef, outline.Outlines)
 		}
 	}
 
@@ -152,10 +276,23 @@ func importSubscriptions(pfc *PFContext, ch chan<- *rss.Outline, userID storage.
 }
 
 func importOPMLTask(pfc *PFContext) (TaskMessage, error) {
+	return importSubscriptionFile(pfc, "opmlBlobKey", rss.ParseOPML)
+}
+
+// importJSONTask imports subscriptions exported by Feedly or Google 
+// Reader, the same way as OPML
+func importJSONTask(pfc *PFContext) (TaskMessage, error) {
+	return importSubscriptionFile(pfc, "jsonBlobKey", rss.ParseSubscriptionsJSON)
+}
+
+// importSubscriptionFile imports the subscriptions in an uploaded 
+// file, named by the blob key in the keyParam field. The file is 
+// deleted once it's parsed
+func importSubscriptionFile(pfc *PFContext, keyParam string, parse func(io.Reader) (*rss.OPML, error)) (TaskMessage, error) {
 	c := pfc.C
 
 	var blobKey appengine.BlobKey
-	if blobKeyString := pfc.R.PostFormValue("opmlBlobKey"); blobKeyString == "" {
+	if blobKeyString := pfc.R.PostFormValue(keyParam); blobKeyString == "" {
 		return TaskMessage{}, errors.New("Missing blob key")
 	} else {
 		blobKey = appengine.BlobKey(blobKeyString)
@@ -163,7 +300,7 @@ func importOPMLTask(pfc *PFContext) (TaskMessage, error) {
 
 	reader := blobstore.NewReader(c, blobKey)
 
-	opml, err := rss.ParseOPML(reader)
+	opml, err := parse(reader)
 	if err != nil {
 		// Remove the blob
 		if err := blobstore.Delete(c, blobKey); err != nil {
@@ -184,18 +321,50 @@ func importOPMLTask(pfc *PFContext) (TaskMessage, error) {
 		UserID: pfc.UserID,
 	}
 
-	doneChannel := make(chan *rss.Outline)
-	importing := importSubscriptions(pfc, doneChannel, pfc.UserID, parentRef, opml.Outlines())
+	imp := &opmlImport {
+		pfc: pfc,
+		userID: pfc.UserID,
+		results: make(chan importResult),
+		slots: make(chan bool, maxConcurrentImports),
+		deadline: importStarted.Add(importDeadline),
+	}
 
+	importing := imp.importSubscriptions(parentRef, opml.Outlines())
+
+	imported, duplicates, failed := 0, 0, 0
 	for i := 0; i < importing; i++ {
-		subscription := <-doneChannel;
-		c.Infof("Completed %s", subscription.Title)
+		result := <-imp.results
+		if result.Err != nil {
+			c.Errorf("Error importing %s: %s", result.Outline.DisplayTitle(), result.Err)
+			failed++
+		} else if result.Duplicate {
+			c.Infof("Already subscribed to %s", result.Outline.FeedURL)
+			duplicates++
+		} else {
+			c.Infof("Completed %s", result.Outline.DisplayTitle())
+			imported++
+		}
 	}
 
-	c.Infof("All completed in %s", time.Since(importStarted))
+	c.Infof("All completed in %s (%d imported, %d duplicate, %d failed; %d folders created, %d merged)", 
+		time.Since(importStarted), imported, duplicates, failed, 
+		imp.foldersCreated, imp.foldersMerged)
+
+	message := _l("Subscriptions imported successfully")
+	if failed > 0 {
+		message = _l("%d subscriptions imported; %d could not be imported", imported, failed)
+	} else if imported == 0 && duplicates > 0 {
+		message = _l("Already subscribed to all %d feeds", duplicates)
+	}
+
+	// Localized in parts, since the whole isn't in the catalogs
+	message = localize(pfc.Locale, message)
+	if imp.foldersCreated > 0 || imp.foldersMerged > 0 {
+		message += " " + localize(pfc.Locale, _l("(%d folders created, %d merged)", imp.foldersCreated, imp.foldersMerged))
+	}
 
 	return TaskMessage{
-		Message: _l("Subscriptions imported successfully"),
+		Message: message,
 		Refresh: true,
 		}, nil
 }
@@ -208,6 +377,8 @@ func subscribeTask(pfc *PFContext) (TaskMessage, error) {
 		return TaskMessage{}, errors.New("Missing subscription URL")
 	}
 
+	c := withLogFields(pfc.C, "feed", subscriptionURL)
+
 	subscriptionRef := storage.SubscriptionRef {
 		FolderRef: storage.FolderRef {
 			UserID: pfc.UserID,
@@ -216,45 +387,51 @@ func subscribeTask(pfc *PFContext) (TaskMessage, error) {
 		SubscriptionID: subscriptionURL,
 	}
 
-	if exists, err := storage.SubscriptionExists(pfc.C, subscriptionRef); err != nil {
+	if exists, err := storage.SubscriptionExists(c, subscriptionRef); err != nil {
 		return TaskMessage{}, err
 	} else if !exists {
-		pfc.C.Warningf("No longer subscribed to %s", subscriptionURL, err)
+		c.Warningf("No longer subscribed to %s", subscriptionURL, err)
 		return TaskMessage{}, nil
 	}
 
-	if feed, err := storage.FeedByURL(pfc.C, subscriptionURL); err != nil {
+	if feed, err := storage.FeedByURL(c, subscriptionURL); err != nil {
 		return TaskMessage{}, err
 	} else if feed == nil {
 		// Feed not available locally - fetch it
-		client := createHttpClient(pfc.C)
+		client := createHttpClient(c)
 		if response, err := client.Get(subscriptionURL); err != nil {
-			pfc.C.Errorf("Error downloading feed (%s): %s", subscriptionURL, err)
+			c.Errorf("Error downloading feed (%s): %s", subscriptionURL, err)
 			return TaskMessage{}, NewReadableError(_l("An error occurred while downloading the feed"), &err)
 		} else {
 			defer response.Body.Close()
-			if parsedFeed, err := rss.UnmarshalStream(subscriptionURL, response.Body); err != nil {
-				pfc.C.Errorf("Error reading RSS content (%s): %s", subscriptionURL, err)
+			if parsed, err := rss.UnmarshalStream(subscriptionURL, response.Body); err != nil {
+				c.Errorf("Error reading RSS content (%s): %s", subscriptionURL, err)
 				return TaskMessage{}, NewReadableError(_l("Error reading RSS content"), &err)
 			} else {
+				parsedFeed := parsed.Feed
+				backfillFeed(c, client, parsedFeed)
+
 				favIconURL := ""
 				if parsedFeed.WWWURL != "" {
-					if url, err := locateFavIconURL(pfc.C, parsedFeed.WWWURL); err != nil {
+					if url, err := locateFavIconURL(c, parsedFeed.WWWURL); err != nil {
 						// Not critical
-						pfc.C.Warningf("FavIcon retrieval error: %s", err)
+						c.Warningf("FavIcon retrieval error: %s", err)
 					} else if url != "" {
 						favIconURL = url
 					}
 				}
 
-				if err := storage.UpdateFeed(pfc.C, parsedFeed, favIconURL, time.Now()); err != nil {
+				if err := storage.UpdateFeed(c, parsedFeed, favIconURL, time.Now()); err != nil {
 					return TaskMessage{}, err
 				}
 			}
 		}
 	}
 
-	if _, err := storage.UpdateSubscription(pfc.C, subscriptionURL, subscriptionRef); err != nil {
+	// Optionally, start with the backlog read; only articles that 
+	// arrive from now on are unread
+	markExistingRead := pfc.R.PostFormValue("markExistingRead") == "true"
+	if _, err := storage.UpdateSubscription(c, subscriptionURL, subscriptionRef, userMuteWords(pfc), markExistingRead); err != nil {
 		return TaskMessage{}, err
 	}
 
@@ -300,7 +477,48 @@ func markAllAsReadTask(pfc *PFContext) (TaskMessage, error) {
 		SubscriptionID: subscriptionID,
 	}
 
-	if marked, err := storage.MarkAllAsRead(pfc.C, ref); err != nil {
+	// Only articles fetched up to a point (Fever's "before") are 
+	// marked, continuing in a new task if it runs out of time
+	if beforeParam := pfc.R.PostFormValue("before"); beforeParam != "" {
+		before, err := time.Parse(time.RFC3339Nano, beforeParam)
+		if err != nil {
+			return TaskMessage{}, err
+		}
+
+		markedEarlier, _ := strconv.Atoi(pfc.R.PostFormValue("marked"))
+		deadline := time.Now().Add(purgeDeadline)
+
+		marked, continueFrom, err := storage.MarkAsReadBefore(pfc.C, ref, before, pfc.R.PostFormValue("continue"), deadline)
+		if err != nil {
+			return TaskMessage{}, err
+		}
+
+		marked += markedEarlier
+		if continueFrom != "" {
+			params := taskParams {
+				"subscriptionID": subscriptionID,
+				"folderID":       folderID,
+				"before":         beforeParam,
+				"continue":       continueFrom,
+				"marked":         strconv.Itoa(marked),
+			}
+			if err := startTask(pfc, "markAllAsRead", params, modificationQueue); err != nil {
+				return TaskMessage{}, err
+			}
+
+			return TaskMessage{ Silent: true }, nil
+		}
+
+		return TaskMessage {
+			Message: _l("%d items marked as read", marked),
+			Refresh: true,
+		}, nil
+	}
+
+	// Starred and liked articles can be left unread
+	keepFlagged := pfc.R.PostFormValue("keepFlagged") == "true"
+
+	if marked, err := storage.MarkAllAsRead(pfc.C, ref, keepFlagged); err != nil {
 		return TaskMessage{}, err
 	} else {
 		return TaskMessage {
@@ -310,6 +528,113 @@ func markAllAsReadTask(pfc *PFContext) (TaskMessage, error) {
 	}
 }
 
+func markNewerUnreadTask(pfc *PFContext) (TaskMessage, error) {
+	folderID := pfc.R.PostFormValue("folderID")
+	subscriptionID := pfc.R.PostFormValue("subscriptionID")
+
+	since, err := time.Parse(time.RFC3339Nano, pfc.R.PostFormValue("since"))
+	if err != nil {
+		return TaskMessage{}, err
+	}
+
+	ref := storage.ArticleScope {
+		FolderRef: storage.FolderRef {
+			UserID: pfc.UserID,
+			FolderID: folderID,
+		},
+		SubscriptionID: subscriptionID,
+	}
+
+	if marked, err := storage.MarkNewerAsUnread(pfc.C, ref, since); err != nil {
+		return TaskMessage{}, err
+	} else {
+		return TaskMessage {
+			Message: _l("%d items marked as unread", marked),
+			Refresh: true,
+		}, nil
+	}
+}
+
+// purgeReadTask deletes read articles within scope. When it runs out
+// of time, it queues itself to pick up where it left off, carrying 
+// over the number deleted so far
+func purgeReadTask(pfc *PFContext) (TaskMessage, error) {
+	r := pfc.R
+	folderID := r.PostFormValue("folderID")
+	subscriptionID := r.PostFormValue("subscriptionID")
+
+	deletedEarlier := 0
+	if deletedParam := r.PostFormValue("deleted"); deletedParam != "" {
+		if count, err := strconv.Atoi(deletedParam); err == nil {
+			deletedEarlier = count
+		}
+	}
+
+	scope := storage.ArticleScope {
+		FolderRef: storage.FolderRef {
+			UserID: pfc.UserID,
+			FolderID: folderID,
+		},
+		SubscriptionID: subscriptionID,
+	}
+
+	deadline := time.Now().Add(purgeDeadline)
+	deleted, continueFrom, err := storage.PurgeReadArticles(pfc.C, scope, r.PostFormValue("continue"), deadline)
+	if err != nil {
+		return TaskMessage{}, err
+	}
+
+	deleted += deletedEarlier
+	if continueFrom != "" {
+		params := taskParams {
+			"subscriptionID": subscriptionID,
+			"folderID":       folderID,
+			"continue":       continueFrom,
+			"deleted":        strconv.Itoa(deleted),
+		}
+		if err := startTask(pfc, "purgeRead", params, modificationQueue); err != nil {
+			return TaskMessage{}, err
+		}
+
+		return TaskMessage{ Silent: true }, nil
+	}
+
+	return TaskMessage {
+		Message: _l("%d read items deleted", deleted),
+		Refresh: true,
+	}, nil
+}
+
+// autoMarkReadTask marks the user's old unread articles as read. As 
+// with purgeReadTask, it continues in a new task if it runs out of time
+func autoMarkReadTask(pfc *PFContext) (TaskMessage, error) {
+	if pfc.User == nil || pfc.User.AutoReadAgeDays <= 0 {
+		return TaskMessage{ Silent: true }, nil
+	}
+
+	olderThan := time.Now().Add(-time.Duration(pfc.User.AutoReadAgeDays) * 24 * time.Hour)
+	deadline := time.Now().Add(purgeDeadline)
+
+	marked, continueFrom, err := storage.AutoMarkAsRead(pfc.C, pfc.UserID, olderThan, pfc.R.PostFormValue("continue"), deadline)
+	if err != nil {
+		return TaskMessage{ Silent: true }, err
+	}
+
+	pfc.C.Infof("%d articles marked as read", marked)
+
+	if continueFrom != "" {
+		task := taskqueue.NewPOSTTask("/tasks/autoMarkRead", url.Values {
+			"userID": { pfc.User.ID },
+			"continue": { continueFrom },
+		})
+		if _, err := taskqueue.Add(pfc.C, task, modificationQueue); err != nil {
+			return TaskMessage{ Silent: true }, err
+		}
+	}
+
+	return TaskMessage{ Silent: true }, nil
+}
+
 func moveSubscriptionTask(pfc *PFContext) (TaskMessage, error) {
 	r := pfc.R
 
@@ -338,7 +663,7 @@ func moveSubscriptionTask(pfc *PFContext) (TaskMessage, error) {
 }
 
 func syncFeedsTask(pfc *PFContext) (TaskMessage, error) {
-	if err := storage.UpdateAllSubscriptions(pfc.C, pfc.UserID); err != nil {
+	if err := storage.UpdateAllSubscriptions(pfc.C, pfc.UserID, userMuteWords(pfc)); err != nil {
 		return TaskMessage{}, err
 	}
 
@@ -353,6 +678,71 @@ func syncFeedsTask(pfc *PFContext) (TaskMessage, error) {
 	}, nil
 }
 
+// refreshFeedTask fetches a feed out of schedule, then brings the 
+// user's subscription to it up to date
+func refreshFeedTask(pfc *PFContext) (TaskMessage, error) {
+	feedURL := pfc.R.PostFormValue("url")
+	if feedURL == "" {
+		return TaskMessage{}, errors.New("Missing feed URL")
+	}
+
+	c := withLogFields(pfc.C, "feed", feedURL)
+
+	feedMeta, err := storage.FeedMetaByURL(c, feedURL)
+	if err != nil {
+		return TaskMessage{}, err
+	} else if feedMeta == nil {
+		return TaskMessage{}, fmt.Errorf("Feed %s not found", feedURL)
+	}
+
+	doneChannel := make(chan *storage.FeedMeta, 1)
+	updateFeed(c, doneChannel, feedURL, feedMeta)
+
+	subscriptionRef, subscribed, err := storage.SubscriptionByFeedURL(c, pfc.UserID, feedURL)
+	if err != nil {
+		return TaskMessage{}, err
+	} else if !subscribed {
+		// Unsubscribed since the refresh was queued
+		return TaskMessage{ Silent: true }, nil
+	}
+
+	if count, err := storage.UpdateSubscription(c, feedURL, subscriptionRef, userMuteWords(pfc), false); err != nil {
+		return TaskMessage{}, err
+	} else if count == 0 {
+		return TaskMessage{ Silent: true }, nil
+	}
+
+	userSubscriptions, err := storage.NewUserSubscriptions(c, pfc.UserID)
+	if err != nil {
+		return TaskMessage{}, err
+	}
+
+	return TaskMessage {
+		Refresh: false,
+		Subscriptions: userSubscriptions,
+	}, nil
+}
+
+// updateFeedSubscribersTask brings every subscription to a feed up to
+// date, after it's been refreshed out of schedule
+func updateFeedSubscribersTask(pfc *PFContext) (TaskMessage, error) {
+	feedURL := pfc.R.PostFormValue("url")
+	if feedURL == "" {
+		return TaskMessage{}, errors.New("Missing feed URL")
+	}
+
+	c := withLogFields(pfc.C, "feed", feedURL)
+
+	started := time.Now()
+	if updated, err := storage.UpdateFeedSubscriptions(c, feedURL); err != nil {
+		return TaskMessage{ Silent: true }, err
+	} else {
+		c.Infof("%d subscriptions updated in %s", updated, time.Since(started))
+	}
+
+	return TaskMessage{ Silent: true }, nil
+}
+
 func removeFolderTask(pfc *PFContext) (TaskMessage, error) {
 	folderID := pfc.R.PostFormValue("folderID")
 	ref := storage.ArticleScope {
@@ -377,3 +767,123 @@ func removeTagTask(pfc *PFContext) (TaskMessage, error) {
 
 	return TaskMessage{}, nil
 }
+
+// queueWebhookNotifications starts a delivery task for each new 
+// article. Failed deliveries are retried by the queue, with backoff
+func queueWebhookNotifications(c appengine.Context, notifications []storage.WebhookNotification) {
+	for _, notification := range notifications {
+		payload, err := json.Marshal(notification)
+		if err != nil {
+			c.Warningf("Error encoding webhook notification: %s", err)
+			continue
+		}
+
+		task := taskqueue.NewPOSTTask("/tasks/notifyWebhook", url.Values {
+			"url": { notification.URL },
+			"secret": { notification.Secret },
+			"payload": { string(payload) },
+		})
+		if _, err := taskqueue.Add(c, task, webhookQueue); err != nil {
+			c.Warningf("Error queueing webhook notification (%s): %s", notification.URL, err)
+		}
+	}
+}
+
+// notifyWebhookTask POSTs a notification to a webhook. The payload is
+// signed with the subscription's secret (HMAC-SHA256, hex-encoded in
+// the X-Gofr-Signature header), so the receiver can verify it
+func notifyWebhookTask(pfc *PFContext) (TaskMessage, error) {
+	r := pfc.R
+	webhookURL := r.PostFormValue("url")
+	payload := []byte(r.PostFormValue("payload"))
+
+	if !isFetchableURL(webhookURL) {
+		// Set before addresses were checked - not worth retrying
+		pfc.C.Errorf("Webhook address can't be loaded (%s)", webhookURL)
+		return TaskMessage{ Silent: true }, nil
+	}
+
+	mac := hmac.New(sha256.New, []byte(r.PostFormValue("secret")))
+	mac.Write(payload)
+
+	request, err := http.NewRequest("POST", webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		// Not worth retrying
+		pfc.C.Errorf("Invalid webhook request (%s): %s", webhookURL, err)
+		return TaskMessage{ Silent: true }, nil
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Gofr-Signature", "sha256=" + hex.EncodeToString(mac.Sum(nil)))
+
+	client := createGuardedHttpClient(pfc.C, webhookDeadline)
+	if response, err := client.Do(request); err != nil {
+		return TaskMessage{ Silent: true }, err
+	} else {
+		response.Body.Close()
+
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			return TaskMessage{ Silent: true }, fmt.Errorf("Webhook %s responded with %s", webhookURL, response.Status)
+		}
+	}
+
+	return TaskMessage{ Silent: true }, nil
+}
+
+// backfillFeed follows the "next" links of a paginated feed, adding
+// older entries to the first page. Errors just end the walk early.
+// No more entries are collected than UpdateFeed would store
+func backfillFeed(c appengine.Context, client *http.Client, parsedFeed *rss.Feed) {
+	isFull := func() bool {
+		return storage.MaxEntriesPerUpdate > 0 && len(parsedFeed.Entries) >= storage.MaxEntriesPerUpdate
+	}
+
+	seenURLs := map[string]bool { parsedFeed.URL: true }
+	seenEntries := make(map[string]bool)
+	for _, entry := range parsedFeed.Entries {
+		seenEntries[entry.UniqueID()] = true
+	}
+
+	pageURL, nextURL := parsedFeed.URL, parsedFeed.NextURL
+	for pages := 0; nextURL != "" && pages < maxBackfillPages && !isFull(); pages++ {
+		if base, err := url.Parse(pageURL); err != nil {
+			break
+		} else if ref, err := url.Parse(nextURL); err != nil {
+			break
+		} else {
+			pageURL = base.ResolveReference(ref).String()
+		}
+
+		if seenURLs[pageURL] {
+			break // Loop
+		}
+		seenURLs[pageURL] = true
+
+		response, err := client.Get(pageURL)
+		if err != nil {
+			c.Warningf("Error downloading feed page (%s): %s", pageURL, err)
+			break
+		}
+
+		parsed, err := rss.UnmarshalStream(pageURL, response.Body)
+		response.Body.Close()
+
+		if err != nil {
+			c.Warningf("Error reading feed page (%s): %s", pageURL, err)
+			break
+		}
+
+		page := parsed.Feed
+		for i, entry := range page.Entries {
+			if isFull() {
+				c.Infof("Discarding %d backfilled entries of %s", len(page.Entries) - i, parsedFeed.URL)
+				break
+			} else if id := entry.UniqueID(); !seenEntries[id] {
+				seenEntries[id] = true
+				parsedFeed.Entries = append(parsedFeed.Entries, entry)
+			}
+		}
+
+		nextURL = page.NextURL
+	}
+}
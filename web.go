@@ -25,19 +25,39 @@ package gofr
 
 import (
 	"appengine/user"
+	"bytes"
+	"encoding/json"
 	"encoding/xml"
 	"html/template"
 	"net/http"
+	"rss"
 	"storage"
+	"time"
 )
 
 func registerWeb() {
 	RegisterHTMLRoute("/reader", reader)
 	RegisterHTMLRoute("/export",  exportOPML)
+	RegisterHTMLRoute("/exportArticles", exportArticles)
 
 	RegisterAnonHTMLRoute("/",    intro)
 }
 
+const (
+	// Stop exporting well before the request deadline is reached, or
+	// once the export gets large, since it's held in memory
+	articleExportDeadlineSeconds = 45
+	maxArticleExportBytes = 16 * 1024 * 1024
+)
+
+type exportedArticle struct {
+	Title string         `json:"title"`
+	Content string       `json:"content"`
+	Published time.Time  `json:"published"`
+	URL string           `json:"url"`
+	Properties []string  `json:"properties"`
+}
+
 var readerTemplate = template.Must(template.New("reader").Parse(readerTemplateHTML))
 var introTemplate = template.Must(template.New("intro").Parse(introTemplateHTML))
 
@@ -70,16 +90,41 @@ func exportOPML(pfc *PFContext) {
 	c := pfc.C
 	w := pfc.W
 
-	if opml, err := storage.SubscriptionsAsOPML(c, pfc.UserID); err != nil {
+	var opml *rss.OPML
+	var err error
+
+	if folderID := pfc.R.FormValue("folder"); folderID != "" {
+		folderRef := storage.FolderRef {
+			UserID: pfc.UserID,
+			FolderID: folderID,
+		}
+
+		if exists, err := storage.FolderExists(c, folderRef); err != nil {
+			c.Errorf("Error locating folder: %s", err)
+			http.Error(w, localize(pfc.Locale, _l("Folder not found")), http.StatusNotFound)
+			return
+		} else if !exists {
+			http.Error(w, localize(pfc.Locale, _l("Folder not found")), http.StatusNotFound)
+			return
+		}
+
+		opml, err = storage.FolderSubscriptionsAsOPML(c, folderRef)
+	} else {
+		opml, err = storage.SubscriptionsAsOPML(c, pfc.UserID)
+	}
+
+	if err != nil {
 		c.Errorf("Error retrieving list of subscriptions: %s", err)
-		http.Error(w, _l("Error retrieving list of subscriptions"), http.StatusInternalServerError)
+		http.Error(w, localize(pfc.Locale, _l("Error retrieving list of subscriptions")), http.StatusInternalServerError)
 		return
 	} else {
 		opml.SetTitle(_l("Gofr subscriptions for %s", pfc.User.EmailAddress))
+		opml.SetDateCreated(time.Now())
+		opml.SetOwner("", pfc.User.EmailAddress)
 
 		if output, err := xml.MarshalIndent(opml, "", "    "); err != nil {
 			c.Errorf("Error generating XML: %s", err)
-			http.Error(w, _l("Error generating subscriptions"), http.StatusInternalServerError)
+			http.Error(w, localize(pfc.Locale, _l("Error generating subscriptions")), http.StatusInternalServerError)
 		} else {
 			w.Header().Set("Content-disposition", "attachment; filename=subscriptions.xml");
 			w.Header().Set("Content-type", "application/xml; charset=utf-8")
@@ -89,3 +134,95 @@ func exportOPML(pfc *PFContext) {
 		}
 	}
 }
+
+func exportArticles(pfc *PFContext) {
+	c := pfc.C
+	w := pfc.W
+	r := pfc.R
+
+	ref := storage.SubscriptionRef {
+		FolderRef: storage.FolderRef {
+			UserID: pfc.UserID,
+			FolderID: r.FormValue("folder"),
+		},
+		SubscriptionID: r.FormValue("subscription"),
+	}
+
+	if !ref.IsSubscriptionExplicit() {
+		http.Error(w, localize(pfc.Locale, _l("Subscription not found")), http.StatusNotFound)
+		return
+	} else if exists, err := storage.SubscriptionExists(c, ref); err != nil || !exists {
+		http.Error(w, localize(pfc.Locale, _l("Subscription not found")), http.StatusNotFound)
+		return
+	}
+
+	filter := storage.ArticleFilter {
+		ArticleScope: storage.ArticleScope(ref),
+	}
+
+	started := time.Now()
+	deadline := time.Duration(articleExportDeadlineSeconds) * time.Second
+	continueFrom := r.FormValue("continue")
+
+	// The export is put together before anything is sent, so that an 
+	// error can still be reported as such, rather than as a short 
+	// (but well-formed) list
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	written := 0
+	resumeFrom := ""
+
+	buffer.WriteString("[")
+	for {
+		page, err := storage.NewArticlePage(c, filter, continueFrom)
+		if err == storage.ErrInvalidCursor {
+			http.Error(w, localize(pfc.Locale, _l("Invalid value for continue")), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			c.Errorf("Error reading articles: %s", err)
+			http.Error(w, localize(pfc.Locale, _l("Error exporting articles")), http.StatusInternalServerError)
+			return
+		}
+
+		for _, article := range page.Articles {
+			exported := exportedArticle {
+				Published: article.Published,
+				Properties: article.Properties,
+			}
+			if article.Details != nil {
+				exported.Title = article.Details.Title
+				exported.Content = article.Details.Content
+				exported.URL = article.Details.Link
+			}
+
+			if written > 0 {
+				buffer.WriteString(",")
+			}
+			if err := encoder.Encode(exported); err != nil {
+				c.Errorf("Error encoding article: %s", err)
+				http.Error(w, localize(pfc.Locale, _l("Error exporting articles")), http.StatusInternalServerError)
+				return
+			}
+			written++
+		}
+
+		if continueFrom = page.Continue; continueFrom == "" {
+			break
+		} else if time.Since(started) > deadline || buffer.Len() > maxArticleExportBytes {
+			c.Warningf("Article export stopped after %d articles (took %s)", written, time.Since(started))
+			resumeFrom = continueFrom
+			break
+		}
+	}
+	buffer.WriteString("]")
+
+	// If the export didn't complete, the position to resume from 
+	// (passed back as "continue") is sent in a header
+	if resumeFrom != "" {
+		w.Header().Set("X-Continue", resumeFrom)
+	}
+	w.Header().Set("Content-disposition", "attachment; filename=articles.json")
+	w.Header().Set("Content-type", "application/json; charset=utf-8")
+
+	w.Write(buffer.Bytes())
+}